@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// adaptiveSampler picks the intel_gpu_top sampling interval from load:
+// fast while the GPU is busy for detail during activity, slow otherwise to
+// keep overhead down. Separate thresholds for entering and leaving the busy
+// state stop load hovering around one value from restarting intel_gpu_top
+// on every sample.
+type adaptiveSampler struct {
+	// high is the busy percentage at or above which sampling speeds up.
+	high float64
+	// low is the busy percentage below which sampling slows down again.
+	low float64
+	// busyInterval and idleInterval are the sampling intervals used in
+	// each state.
+	busyInterval time.Duration
+	idleInterval time.Duration
+
+	busy bool
+}
+
+// Interval returns the sampling interval for the current state.
+func (a *adaptiveSampler) Interval() time.Duration {
+	if a.busy {
+		return a.busyInterval
+	}
+	return a.idleInterval
+}
+
+// Observe feeds a sample and reports whether the state, and so the
+// sampling interval, changed as a result.
+func (a *adaptiveSampler) Observe(stats IntelTopStats) bool {
+	load := gpuBusy(stats)
+	switch {
+	case !a.busy && load >= a.high:
+		a.busy = true
+		slog.Info("GPU busy, sampling faster", "busy_percent", load, "interval", a.busyInterval)
+		return true
+	case a.busy && load < a.low:
+		a.busy = false
+		slog.Info("GPU load down, sampling slower", "busy_percent", load, "interval", a.idleInterval)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestAdaptiveSamplerObserve(t *testing.T) {
+	c := qt.New(t)
+
+	load := func(busy float64) IntelTopStats {
+		return IntelTopStats{Engine: map[string]IntelEngine{"RCS": {BusyPercent: 1}, "VCS": {BusyPercent: busy}}}
+	}
+
+	steps := []struct {
+		busy       float64
+		changed    bool
+		expectFast bool
+	}{
+		{busy: 0, changed: false, expectFast: false},
+		{busy: 49.9, changed: false, expectFast: false},
+		// busy -> at the high threshold
+		{busy: 50, changed: true, expectFast: true},
+		// Dips between the thresholds stay fast
+		{busy: 30, changed: false, expectFast: true},
+		{busy: 20, changed: false, expectFast: true},
+		// idle -> only below the low threshold
+		{busy: 19.9, changed: true, expectFast: false},
+		// Rises between the thresholds stay slow
+		{busy: 45, changed: false, expectFast: false},
+		{busy: 90, changed: true, expectFast: true},
+		{busy: 90, changed: false, expectFast: true},
+	}
+
+	a := &adaptiveSampler{high: 50, low: 20, busyInterval: 250 * time.Millisecond, idleInterval: 5 * time.Second}
+	for i, step := range steps {
+		c.Assert(a.Observe(load(step.busy)), qt.Equals, step.changed, qt.Commentf("step %d", i))
+		want := a.idleInterval
+		if step.expectFast {
+			want = a.busyInterval
+		}
+		c.Assert(a.Interval(), qt.Equals, want, qt.Commentf("step %d", i))
+	}
+}
+
+func TestRunGPUTopAdaptiveRestarts(t *testing.T) {
+	c := qt.New(t)
+
+	header := "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa\n"
+	idle := "300.0,300.0,0.0,99.0,5.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0\n"
+	middling := "900.0,900.0,200.0,50.0,35.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0\n"
+	busy := "1200.0,1150.0,500.0,10.0,80.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0\n"
+
+	runner := &fakeRunner{outputs: []string{
+		header + idle + middling + busy,
+		header + middling + idle,
+		header + middling,
+	}}
+	opts := collectOptions{
+		interval: time.Second,
+		adaptive: &adaptiveSampler{high: 50, low: 20, busyInterval: 250 * time.Millisecond, idleInterval: 5 * time.Second},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runGPUTop(ctx, cancel, runner, discardSink{}, opts)
+
+	// Slow, fast once busy, then slow again only once load drops below low
+	c.Assert(runner.intervals, qt.DeepEquals, []time.Duration{5 * time.Second, 250 * time.Millisecond, 5 * time.Second})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Aggregation methods accepted by -aggregate-method. -scrape-aggregate also
+// accepts aggregateLatest, while aggregateMin is only used by the -aggregate
+// window.
+const (
+	aggregateMean   = "mean"
+	aggregateMax    = "max"
+	aggregateMin    = "min"
+	aggregateLatest = "latest"
+)
+
+// aggregatingSink buffers samples for a fixed window and forwards a single
+// aggregate of them to next once per window, smoothing out sample-to-sample
+// noise between scrapes.
+type aggregatingSink struct {
+	next    MetricsSink
+	window  time.Duration
+	method  string
+	now     func() time.Time
+	start   time.Time
+	samples []IntelTopStats
+	prev    *IntelTopStats
+}
+
+func newAggregatingSink(next MetricsSink, window time.Duration, method string) (*aggregatingSink, error) {
+	if method != aggregateMean && method != aggregateMax {
+		return nil, fmt.Errorf("invalid aggregation method %q: must be %s or %s", method, aggregateMean, aggregateMax)
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("invalid aggregation window %s: must be positive", window)
+	}
+
+	return &aggregatingSink{next: next, window: window, method: method, now: time.Now}, nil
+}
+
+func (a *aggregatingSink) Update(stats IntelTopStats, _ *IntelTopStats) {
+	now := a.now()
+	if len(a.samples) == 0 {
+		a.start = now
+	}
+	a.samples = append(a.samples, stats)
+
+	if now.Sub(a.start) < a.window {
+		return
+	}
+
+	agg := aggregateStats(a.samples, a.method)
+	a.next.Update(agg, a.prev)
+	a.prev = &agg
+	a.samples = a.samples[:0]
+}
+
+// aggregateStats combines samples field by field using method. Engines are
+// aggregated over the samples that reported them, while clients come and go
+// so those of the last sample are kept.
+func aggregateStats(samples []IntelTopStats, method string) IntelTopStats {
+	combine := func(values []float64) float64 {
+		return combineValues(values, method)
+	}
+
+	field := func(get func(IntelTopStats) float64) float64 {
+		values := make([]float64, len(samples))
+		for i, s := range samples {
+			values[i] = get(s)
+		}
+		return combine(values)
+	}
+
+	// Optional readings are aggregated over the samples reporting them
+	optionalField := func(get func(IntelTopStats) *float64) *float64 {
+		var values []float64
+		for _, s := range samples {
+			if v := get(s); v != nil {
+				values = append(values, *v)
+			}
+		}
+		if len(values) == 0 {
+			return nil
+		}
+		v := combine(values)
+		return &v
+	}
+
+	engines := make(map[string][]IntelEngine)
+	rc6States := make(map[string][]float64)
+	for _, s := range samples {
+		for name, engine := range s.Engine {
+			engines[name] = append(engines[name], engine)
+		}
+		for state, value := range s.Rc6StatePercent {
+			rc6States[state] = append(rc6States[state], value)
+		}
+	}
+
+	agg := IntelTopStats{
+		Device:            samples[len(samples)-1].Device,
+		FreqMhzRequested:  field(func(s IntelTopStats) float64 { return s.FreqMhzRequested }),
+		FreqMhzActual:     field(func(s IntelTopStats) float64 { return s.FreqMhzActual }),
+		IRQPerSec:         field(func(s IntelTopStats) float64 { return s.IRQPerSec }),
+		Rc6Percent:        field(func(s IntelTopStats) float64 { return s.Rc6Percent }),
+		Engine:            make(map[string]IntelEngine, len(engines)),
+		PowerGPUWatts:     optionalField(func(s IntelTopStats) *float64 { return s.PowerGPUWatts }),
+		PowerPackageWatts: optionalField(func(s IntelTopStats) *float64 { return s.PowerPackageWatts }),
+		IMCReadsMiBs:      optionalField(func(s IntelTopStats) *float64 { return s.IMCReadsMiBs }),
+		IMCWritesMiBs:     optionalField(func(s IntelTopStats) *float64 { return s.IMCWritesMiBs }),
+		FanRPM:            optionalField(func(s IntelTopStats) *float64 { return s.FanRPM }),
+		VoltageVolts:      optionalField(func(s IntelTopStats) *float64 { return s.VoltageVolts }),
+		Clients:           samples[len(samples)-1].Clients,
+	}
+
+	// Like optional readings, RC6 states are aggregated over the samples
+	// reporting them
+	for state, values := range rc6States {
+		if agg.Rc6StatePercent == nil {
+			agg.Rc6StatePercent = make(map[string]float64, len(rc6States))
+		}
+		agg.Rc6StatePercent[state] = combine(values)
+	}
+
+	for name, observed := range engines {
+		engineField := func(get func(IntelEngine) float64) float64 {
+			values := make([]float64, len(observed))
+			for i, e := range observed {
+				values[i] = get(e)
+			}
+			return combine(values)
+		}
+
+		agg.Engine[name] = IntelEngine{
+			BusyPercent: engineField(func(e IntelEngine) float64 { return e.BusyPercent }),
+			SemaPercent: engineField(func(e IntelEngine) float64 { return e.SemaPercent }),
+			WaitPercent: engineField(func(e IntelEngine) float64 { return e.WaitPercent }),
+		}
+	}
+
+	return agg
+}
+
+// combineValues aggregates values, which must not be empty, using method.
+func combineValues(values []float64, method string) float64 {
+	switch method {
+	case aggregateMax:
+		return slices.Max(values)
+	case aggregateMin:
+		return slices.Min(values)
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// parseAggregateSpec parses -aggregate, comma separated key=value settings
+// of which only window, a positive duration, is known so far.
+func parseAggregateSpec(spec string) (time.Duration, error) {
+	var window time.Duration
+	for setting := range strings.SplitSeq(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+		if !ok || key != "window" {
+			return 0, fmt.Errorf("invalid -aggregate setting %q: must be window=<duration>", setting)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid -aggregate window %q: must be a positive duration", value)
+		}
+		window = d
+	}
+	return window, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestAggregateStats(t *testing.T) {
+	c := qt.New(t)
+
+	fan := func(rpm float64) *float64 { return &rpm }
+	samples := []IntelTopStats{
+		{
+			FreqMhzRequested: 1000, FreqMhzActual: 900, IRQPerSec: 100, Rc6Percent: 20,
+			Engine: map[string]IntelEngine{
+				"RCS": {BusyPercent: 10, SemaPercent: 2, WaitPercent: 4},
+			},
+		},
+		{
+			FreqMhzRequested: 1200, FreqMhzActual: 1100, IRQPerSec: 300, Rc6Percent: 60,
+			Engine: map[string]IntelEngine{
+				"RCS": {BusyPercent: 30, SemaPercent: 6, WaitPercent: 0},
+				"VCS": {BusyPercent: 50, SemaPercent: 1, WaitPercent: 1},
+			},
+			FanRPM: fan(1500),
+		},
+	}
+
+	tests := []struct {
+		method   string
+		expected IntelTopStats
+	}{
+		{
+			method: aggregateMean,
+			expected: IntelTopStats{
+				FreqMhzRequested: 1100, FreqMhzActual: 1000, IRQPerSec: 200, Rc6Percent: 40,
+				Engine: map[string]IntelEngine{
+					"RCS": {BusyPercent: 20, SemaPercent: 4, WaitPercent: 2},
+					// Only averaged over the samples that reported it
+					"VCS": {BusyPercent: 50, SemaPercent: 1, WaitPercent: 1},
+				},
+				// Likewise for optional readings
+				FanRPM: fan(1500),
+			},
+		},
+		{
+			method: aggregateMax,
+			expected: IntelTopStats{
+				FreqMhzRequested: 1200, FreqMhzActual: 1100, IRQPerSec: 300, Rc6Percent: 60,
+				Engine: map[string]IntelEngine{
+					"RCS": {BusyPercent: 30, SemaPercent: 6, WaitPercent: 4},
+					"VCS": {BusyPercent: 50, SemaPercent: 1, WaitPercent: 1},
+				},
+				FanRPM: fan(1500),
+			},
+		},
+		{
+			method: aggregateMin,
+			expected: IntelTopStats{
+				FreqMhzRequested: 1000, FreqMhzActual: 900, IRQPerSec: 100, Rc6Percent: 20,
+				Engine: map[string]IntelEngine{
+					"RCS": {BusyPercent: 10, SemaPercent: 2, WaitPercent: 0},
+					"VCS": {BusyPercent: 50, SemaPercent: 1, WaitPercent: 1},
+				},
+				FanRPM: fan(1500),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.method, func(c *qt.C) {
+			c.Assert(aggregateStats(samples, tt.method), qt.DeepEquals, tt.expected)
+		})
+	}
+}
+
+func TestAggregatingSinkWindow(t *testing.T) {
+	c := qt.New(t)
+
+	next := &recordingSink{}
+	agg, err := newAggregatingSink(next, 3*time.Second, aggregateMean)
+	c.Assert(err, qt.IsNil)
+
+	now := time.Unix(0, 0)
+	agg.now = func() time.Time { return now }
+
+	// Samples arrive once per second; the window closes on the fourth
+	for i, freq := range []float64{100, 200, 300, 400, 500, 600, 700, 800} {
+		now = time.Unix(int64(i), 0)
+		agg.Update(IntelTopStats{FreqMhzActual: freq}, nil)
+	}
+
+	c.Assert(next.updates, qt.HasLen, 2)
+	c.Assert(next.updates[0].FreqMhzActual, qt.Equals, 250.0)
+	c.Assert(next.updates[1].FreqMhzActual, qt.Equals, 650.0)
+	c.Assert(next.prevs[0], qt.IsNil)
+	c.Assert(next.prevs[1].FreqMhzActual, qt.Equals, 250.0)
+}
+
+func TestNewAggregatingSinkInvalid(t *testing.T) {
+	c := qt.New(t)
+
+	_, err := newAggregatingSink(prometheusSink{collector: newCollector(collectorOptions{})}, time.Second, "median")
+	c.Assert(err, qt.ErrorMatches, `invalid aggregation method "median": must be mean or max`)
+
+	_, err = newAggregatingSink(prometheusSink{collector: newCollector(collectorOptions{})}, -time.Second, aggregateMean)
+	c.Assert(err, qt.ErrorMatches, `invalid aggregation window -1s: must be positive`)
+}
+
+func TestParseAggregateSpec(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		spec    string
+		want    time.Duration
+		wantErr string
+	}{
+		{spec: "window=5s", want: 5 * time.Second},
+		{spec: "window=1m, window=30s", want: 30 * time.Second},
+		{spec: "5s", wantErr: `invalid -aggregate setting "5s": must be window=<duration>`},
+		{spec: "size=5", wantErr: `invalid -aggregate setting "size=5": must be window=<duration>`},
+		{spec: "window=0s", wantErr: `invalid -aggregate window "0s": must be a positive duration`},
+		{spec: "window=soon", wantErr: `invalid -aggregate window "soon": must be a positive duration`},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.spec, func(c *qt.C) {
+			got, err := parseAggregateSpec(tt.spec)
+			if tt.wantErr != "" {
+				c.Assert(err, qt.ErrorMatches, tt.wantErr)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(got, qt.Equals, tt.want)
+		})
+	}
+}
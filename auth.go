@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"slices"
+)
+
+// authRealm is announced to clients failing basic auth.
+const authRealm = "intel-gpu-exporter"
+
+// basicAuth wraps next so that it is only reached with the given basic auth
+// credentials. Digests of the credentials are compared in constant time so
+// neither their contents nor their lengths leak through response timing.
+func basicAuth(next http.Handler, user, pass string) http.Handler {
+	wantUser := sha256.Sum256([]byte(user))
+	wantPass := sha256.Sum256([]byte(pass))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, gotPass, ok := r.BasicAuth()
+		userHash := sha256.Sum256([]byte(gotUser))
+		passHash := sha256.Sum256([]byte(gotPass))
+		userOK := subtle.ConstantTimeCompare(userHash[:], wantUser[:])
+		passOK := subtle.ConstantTimeCompare(passHash[:], wantPass[:])
+		if !ok || userOK&passOK != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+authRealm+`", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// basicAuthExcept wraps next like basicAuth, except that requests for the
+// open paths reach it without credentials, as Kubernetes probes carry none.
+func basicAuthExcept(next http.Handler, user, pass string, open ...string) http.Handler {
+	authed := basicAuth(next, user, pass)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(open, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		authed.ServeHTTP(w, r)
+	})
+}
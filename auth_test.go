@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBasicAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		user, pass string
+		noAuth     bool
+		want       int
+	}{
+		{name: "Correct", user: "prometheus", pass: "s3cret", want: http.StatusOK},
+		{name: "WrongPassword", user: "prometheus", pass: "guess", want: http.StatusUnauthorized},
+		{name: "WrongUser", user: "admin", pass: "s3cret", want: http.StatusUnauthorized},
+		{name: "Missing", noAuth: true, want: http.StatusUnauthorized},
+	}
+
+	handler := basicAuth(newMetricsMux(prometheus.NewRegistry(), defaultMetricsPath, false), "prometheus", "s3cret")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if !tt.noAuth {
+				req.SetBasicAuth(tt.user, tt.pass)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			c.Assert(rec.Code, qt.Equals, tt.want)
+			if tt.want == http.StatusUnauthorized {
+				c.Assert(rec.Header().Get("WWW-Authenticate"), qt.Equals, `Basic realm="intel-gpu-exporter", charset="UTF-8"`)
+			} else {
+				c.Assert(rec.Header().Get("WWW-Authenticate"), qt.Equals, "")
+			}
+		})
+	}
+}
+
+func TestBasicAuthExcept(t *testing.T) {
+	mux := newMetricsMux(prometheus.NewRegistry(), defaultMetricsPath, true)
+	mux.Handle("/healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler := basicAuthExcept(mux, "prometheus", "s3cret", "/healthz")
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/healthz", want: http.StatusOK},
+		{path: "/metrics", want: http.StatusUnauthorized},
+		{path: "/metadata", want: http.StatusUnauthorized},
+		{path: "/ui", want: http.StatusUnauthorized},
+		// Unclean paths aren't matched, so can't sneak past
+		{path: "//healthz", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			c := qt.New(t)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			c.Assert(rec.Code, qt.Equals, tt.want)
+		})
+	}
+}
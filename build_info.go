@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// version and commit identify the build, set at build time with
+// -ldflags "-X main.version=... -X main.commit=...".
+var (
+	version = "dev"
+	commit  string
+)
+
+// unknownGPUTopVersion is the igt_version label until intel_gpu_top's
+// version is known, and for good when it can't be determined.
+const unknownGPUTopVersion = "unknown"
+
+// gpuTopVersionTimeout bounds how long intel_gpu_top --version may take.
+const gpuTopVersionTimeout = 2 * time.Second
+
+// gpuTopVersionPattern matches the version number in intel_gpu_top
+// --version output, e.g. 1.28 or 1.27.1-g2e6e8a0f.
+var gpuTopVersionPattern = regexp.MustCompile(`\d+\.\d+[\w.+-]*`)
+
+// BuildInfoGauge is the conventional constant 1 whose labels dashboards read
+// the exporter version from. igt_version is the intel-gpu-tools release
+// whose intel_gpu_top is launched, as its output format varies between
+// releases.
+var BuildInfoGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "exporter_build_info",
+	Help: "Intel GPU exporter build information, always 1",
+}, []string{"version", "commit", "goversion", "igt_version"})
+
+func init() {
+	setBuildInfo(unknownGPUTopVersion)
+}
+
+// setBuildInfo publishes BuildInfoGauge with igtVersion as the intel_gpu_top
+// version.
+func setBuildInfo(igtVersion string) {
+	BuildInfoGauge.Reset()
+	BuildInfoGauge.WithLabelValues(version, commit, runtime.Version(), igtVersion).Set(1)
+}
+
+// gpuTopVersion runs binary --version and returns the version number it
+// reports.
+func gpuTopVersion(ctx context.Context, binary string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, gpuTopVersionTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, binary, "--version")
+	cmd.WaitDelay = gpuTopVersionTimeout
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+
+	v := gpuTopVersionPattern.FindString(string(output))
+	if v == "" {
+		return "", errors.New("no version in intel_gpu_top --version output")
+	}
+	return v, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBuildInfoRegistered(t *testing.T) {
+	c := qt.New(t)
+
+	reg := prometheus.NewRegistry()
+	registerExporterMetrics(reg, defaultNamespace)
+	families, err := reg.Gather()
+	c.Assert(err, qt.IsNil)
+
+	for _, family := range families {
+		if family.GetName() != "intel_gpu_exporter_build_info" {
+			continue
+		}
+		c.Assert(family.GetMetric(), qt.HasLen, 1)
+		m := family.GetMetric()[0]
+		labels := make(map[string]string)
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		c.Assert(labels, qt.DeepEquals, map[string]string{
+			"version":     version,
+			"commit":      commit,
+			"goversion":   runtime.Version(),
+			"igt_version": unknownGPUTopVersion,
+		})
+		c.Assert(m.GetGauge().GetValue(), qt.Equals, 1.0)
+		return
+	}
+	c.Fatal("intel_gpu_exporter_build_info is not registered")
+}
+
+func TestGPUTopVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "Release",
+			script: "echo 'intel_gpu_top 1.28'",
+			want:   "1.28",
+		},
+		{
+			name:   "GitBuild",
+			script: "echo 'IGT-Version: 1.27.1-g2e6e8a0f (x86_64) (Linux: 6.8.0 x86_64)' >&2",
+			want:   "1.27.1-g2e6e8a0f",
+		},
+		{
+			name:    "NoVersion",
+			script:  "echo 'usage: intel_gpu_top [parameters]'",
+			wantErr: "no version in intel_gpu_top --version output",
+		},
+		{
+			name:    "Fails",
+			script:  "exit 1",
+			wantErr: "exit status 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			// Stub intel_gpu_top that only answers --version
+			binary := filepath.Join(c.TempDir(), "intel_gpu_top")
+			script := "#!/bin/sh\n[ \"$1\" = --version ] || exit 2\n" + tt.script + "\n"
+			c.Assert(os.WriteFile(binary, []byte(script), 0o755), qt.IsNil)
+
+			got, err := gpuTopVersion(context.Background(), binary)
+			if tt.wantErr != "" {
+				c.Assert(err, qt.ErrorMatches, tt.wantErr)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(got, qt.Equals, tt.want)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/mikeodr/intel-gpu-exporter-go/pkg/igtparse"
+)
+
+// IntelClient is a process using the GPU.
+type IntelClient = igtparse.IntelClient
+
+// gpuTopJSONClient is one entry of the clients section of intel_gpu_top -J
+// output, keyed there by an internal client id. Numbers are printed quoted.
+type gpuTopJSONClient struct {
+	Name          string                            `json:"name"`
+	PID           json.Number                       `json:"pid"`
+	EngineClasses map[string]gpuTopJSONClientEngine `json:"engine-classes"`
+}
+
+// gpuTopJSONClientEngine is the usage of an engine class by a client.
+type gpuTopJSONClientEngine struct {
+	Busy json.Number `json:"busy"`
+}
+
+// parseJSONClients converts the clients section of a sample, ordered by PID
+// and name.
+func parseJSONClients(clients map[string]gpuTopJSONClient) ([]IntelClient, error) {
+	parsed := make([]IntelClient, 0, len(clients))
+	for id, client := range clients {
+		pid, err := strconv.Atoi(client.PID.String())
+		if err != nil {
+			return nil, fmt.Errorf("client %s pid: %w", id, err)
+		}
+
+		busy := make(map[string]float64, len(client.EngineClasses))
+		for class, engine := range client.EngineClasses {
+			value, err := engine.Busy.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("client %s %s busy: %w", id, class, err)
+			}
+			busy[jsonEngineName(class)] = value
+		}
+
+		parsed = append(parsed, IntelClient{PID: pid, Name: client.Name, EngineBusyPercent: busy})
+	}
+
+	slices.SortFunc(parsed, func(a, b IntelClient) int {
+		return cmp.Or(cmp.Compare(a.PID, b.PID), cmp.Compare(a.Name, b.Name))
+	})
+	return parsed, nil
+}
+
+// clientSeriesKey identifies a series of intel_gpu_client_engine_percent
+// within a device.
+type clientSeriesKey struct {
+	pid    string
+	name   string
+	engine string
+}
+
+// clientBusy returns the busy percentage of every client series of clients.
+// A process holding several DRM clients is reported as the sum of their busy
+// percentages.
+func clientBusy(clients []IntelClient) map[clientSeriesKey]float64 {
+	busy := make(map[clientSeriesKey]float64)
+	for _, client := range clients {
+		pid := strconv.Itoa(client.PID)
+		for engine, value := range client.EngineBusyPercent {
+			busy[clientSeriesKey{pid: pid, name: client.Name, engine: engine}] += value
+		}
+	}
+	return busy
+}
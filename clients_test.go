@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseJSONClients(t *testing.T) {
+	tests := []struct {
+		name    string
+		clients map[string]gpuTopJSONClient
+		want    []IntelClient
+		wantErr string
+	}{
+		{
+			name: "QuotedNumbers",
+			clients: map[string]gpuTopJSONClient{
+				"12": {Name: "vlc", PID: "900", EngineClasses: map[string]gpuTopJSONClientEngine{"Video": {Busy: "12.5"}}},
+				"7":  {Name: "ffmpeg", PID: "4293", EngineClasses: map[string]gpuTopJSONClientEngine{"Render/3D": {Busy: "64.000000"}, "VideoEnhance": {Busy: "3"}}},
+			},
+			want: []IntelClient{
+				{PID: 900, Name: "vlc", EngineBusyPercent: map[string]float64{"VCS": 12.5}},
+				{PID: 4293, Name: "ffmpeg", EngineBusyPercent: map[string]float64{"RCS": 64, "VECS": 3}},
+			},
+		},
+		{
+			name: "BadPID",
+			clients: map[string]gpuTopJSONClient{
+				"7": {Name: "ffmpeg", PID: "x"},
+			},
+			wantErr: `client 7 pid: .*`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			clients, err := parseJSONClients(tt.clients)
+			if tt.wantErr != "" {
+				c.Assert(err, qt.ErrorMatches, tt.wantErr)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(clients, qt.DeepEquals, tt.want)
+		})
+	}
+}
+
+func TestClientSeriesReaped(t *testing.T) {
+	c := qt.New(t)
+
+	col := newCollector(collectorOptions{namespace: defaultNamespace, clients: true})
+
+	update := func(clients ...IntelClient) {
+		col.Update(IntelTopStats{Engine: map[string]IntelEngine{}, Clients: clients}, nil)
+	}
+
+	update(
+		IntelClient{PID: 4293, Name: "ffmpeg", EngineBusyPercent: map[string]float64{"RCS": 64, "VCS": 80.5}},
+		IntelClient{PID: 900, Name: "vlc", EngineBusyPercent: map[string]float64{"VCS": 10}},
+		// A second DRM client of the same process adds up
+		IntelClient{PID: 900, Name: "vlc", EngineBusyPercent: map[string]float64{"VCS": 5}},
+	)
+	c.Assert(collected(c, col, "intel_gpu_client_engine_percent"), qt.DeepEquals, map[string]float64{
+		`device="",engine="RCS",name="ffmpeg",pid="4293"`: 64,
+		`device="",engine="VCS",name="ffmpeg",pid="4293"`: 80.5,
+		`device="",engine="VCS",name="vlc",pid="900"`:     15,
+	})
+
+	// ffmpeg exited and its PID must not linger
+	update(IntelClient{PID: 900, Name: "vlc", EngineBusyPercent: map[string]float64{"VCS": 20}})
+	err := testutil.GatherAndCompare(col.registry, strings.NewReader(`
+# HELP intel_gpu_client_engine_percent Intel GPU engine busy percentage of a client process
+# TYPE intel_gpu_client_engine_percent gauge
+intel_gpu_client_engine_percent{device="",engine="VCS",name="vlc",pid="900"} 20
+`), "intel_gpu_client_engine_percent")
+	c.Assert(err, qt.IsNil)
+
+	update()
+	c.Assert(collected(c, col, "intel_gpu_client_engine_percent"), qt.HasLen, 0)
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// defaultNamespace is the -namespace every metric name starts with unless
+// overridden.
+const defaultNamespace = "intel_gpu"
+
+// namespacePattern matches a valid -namespace. Colons are left out as
+// Prometheus reserves them for recording rules.
+var namespacePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// deviceLabel identifies the GPU a series describes by its PCI address, or
+// its -device filter when that can't be resolved. It is empty without
+// -device, which Prometheus treats as the label being
+// absent, so single GPU series are unchanged.
+const deviceLabel = "device"
+
+// collectorOptions shape the GPU metrics of a Collector.
+type collectorOptions struct {
+	// namespace prefixes every metric name, as set by -namespace. Empty
+	// leaves names unprefixed.
+	namespace string
+	// compact publishes only the high-level metric set of -compact.
+	compact bool
+	// fraction describes percentages as 0-1 fractions, for -fraction.
+	fraction bool
+	// ratio names the percentage metrics _ratio rather than _percent, for
+	// -ratio. Samples must already be scaled as with fraction.
+	ratio bool
+	// splitEngineInstance publishes engine names such as "VCS/1" as
+	// separate engine and engine_instance labels. The instance label is
+	// left alone as Prometheus sets it on every target.
+	splitEngineInstance bool
+	// engineOccupancy additionally publishes engine occupancy.
+	engineOccupancy bool
+	// clients publishes per-client engine busy.
+	clients bool
+	// scrapeAggregate is what a scrape reports of the samples since the
+	// previous one, as set by -scrape-aggregate: aggregateLatest, or an
+	// aggregateStats method. Empty means aggregateLatest.
+	scrapeAggregate string
+	// window is how far back -aggregate publishes the min, avg and max of
+	// the key metrics over. Zero publishes none.
+	window time.Duration
+	// disabled are the metric families left out by the -disable-* flags.
+	disabled disabledFamilies
+}
+
+// disabledFamilies are metric families that are neither described nor
+// collected, to cut cardinality when they aren't wanted.
+type disabledFamilies struct {
+	// sema and wait drop the engine percentage series of their type, and
+	// either drops the sema/wait ratio along with them.
+	sema bool
+	wait bool
+	// irq drops IRQs per second, their delta and the IRQ total.
+	irq bool
+	// rc6 drops RC6 residency.
+	rc6 bool
+}
+
+// collectorSample is the latest sample of a device as stored by Update.
+type collectorSample struct {
+	stats IntelTopStats
+	// irqDelta is nil until a device has a previous sample.
+	irqDelta *float64
+}
+
+// Collector is the prometheus.Collector serving the GPU metrics. Update
+// stores the latest sample of each device and Collect reports it, so a
+// scrape always reflects one whole sample and series of engines, clients or
+// readings a sample no longer has disappear with it.
+//
+// The Collector is registered on its own registry, which also serves the
+// exporter's other metrics, so several can live in one process.
+type Collector struct {
+	opts     collectorOptions
+	registry *prometheus.Registry
+	// fullScale is the value of a fully occupied engine in published
+	// samples: 100, or 1 with -fraction.
+	fullScale float64
+
+	freqRequested *prometheus.Desc
+	freqActual    *prometheus.Desc
+	// throttleGap is requested minus actual frequency, positive while the
+	// GPU runs below what was asked of it.
+	throttleGap *prometheus.Desc
+	irqPerSec   *prometheus.Desc
+	irqDelta    *prometheus.Desc
+	// powerGPU and powerPackage are only reported where the kernel exposes
+	// energy counters, so like the other optional readings a device's
+	// series exists only while reported.
+	powerGPU     *prometheus.Desc
+	powerPackage *prometheus.Desc
+	// imcReads and imcWrites are only reported on CPUs that expose memory
+	// controller counters.
+	imcReads  *prometheus.Desc
+	imcWrites *prometheus.Desc
+	// fanRPM and voltage are only reported by some discrete cards.
+	fanRPM  *prometheus.Desc
+	voltage *prometheus.Desc
+	// lastSample is when a device last delivered a sample, for staleness
+	// alerts more precise than intel_gpu_exporter_up.
+	lastSample *prometheus.Desc
+	// rc6, busy, engine, occupancy and client describe their unit in their
+	// help text, which depends on -fraction, and engine metrics take their
+	// labels from -split-engine-instance.
+	rc6           *prometheus.Desc
+	busy          *prometheus.Desc
+	engine        *prometheus.Desc
+	semaWaitRatio *prometheus.Desc
+	occupancy     *prometheus.Desc
+	client        *prometheus.Desc
+	// windows describe the aggregates of -aggregate, empty without it.
+	windows []windowDescs
+
+	// now returns the current time, stubbed in tests.
+	now func() time.Time
+
+	// mu guards samples, pending, updated and window, as each device is
+	// collected from its own goroutine.
+	mu sync.Mutex
+	// samples holds the latest sample of each device.
+	samples map[string]collectorSample
+	// pending holds the samples of each device since the previous scrape
+	// when they are aggregated, which the next scrape replaces the
+	// device's sample by.
+	pending map[string][]IntelTopStats
+	// updated holds when each device's latest sample was stored.
+	updated map[string]time.Time
+	// window holds the samples of each device in the -aggregate window,
+	// oldest first.
+	window map[string][]windowSample
+}
+
+// newCollector returns a Collector shaped by opts, registered on a new
+// registry.
+func newCollector(opts collectorOptions) *Collector {
+	rc6Help := "Intel GPU RC6 power state percentage"
+	busyHelp := "Intel GPU busy percentage of its busiest engine"
+	engineHelp := "Intel GPU engine busy percentage"
+	occupancyHelp := "Intel GPU engine busy plus semaphore and wait percentage, capped at 100"
+	clientHelp := "Intel GPU engine busy percentage of a client process"
+	freqRequestedHelp := "Intel GPU requested frequency in MHz"
+	freqActualHelp := "Intel GPU actual frequency in MHz"
+	irqHelp := "Intel GPU IRQs per second"
+	powerGPUHelp := "Intel GPU power draw in watts, when reported"
+	powerPackageHelp := "CPU package power draw in watts including an integrated GPU, when reported"
+	fullScale := 100.0
+	if opts.fraction {
+		rc6Help = "Intel GPU RC6 power state residency as a 0-1 fraction"
+		busyHelp = "Intel GPU busy of its busiest engine as a 0-1 fraction"
+		engineHelp = "Intel GPU engine busy as a 0-1 fraction"
+		occupancyHelp = "Intel GPU engine busy plus semaphore and wait as a 0-1 fraction, capped at 1"
+		clientHelp = "Intel GPU engine busy of a client process as a 0-1 fraction"
+		fullScale = 1
+	}
+
+	name := func(name string) string {
+		return prometheus.BuildFQName(opts.namespace, "", name)
+	}
+	percentName := func(base string) string {
+		if opts.ratio {
+			return name(base + "_ratio")
+		}
+		return name(base + "_percent")
+	}
+	device := []string{deviceLabel}
+	engine := engineLabelNames(opts.splitEngineInstance)
+	c := &Collector{
+		opts:      opts,
+		registry:  prometheus.NewRegistry(),
+		fullScale: fullScale,
+		samples:   make(map[string]collectorSample),
+		pending:   make(map[string][]IntelTopStats),
+		updated:   make(map[string]time.Time),
+		window:    make(map[string][]windowSample),
+		now:       time.Now,
+
+		freqRequested: prometheus.NewDesc(name("freq_mhz_requested"), freqRequestedHelp, device, nil),
+		freqActual:    prometheus.NewDesc(name("freq_mhz_actual"), freqActualHelp, device, nil),
+		throttleGap:   prometheus.NewDesc(name("freq_mhz_throttle_gap"), "Intel GPU requested minus actual frequency in MHz, positive while running below the requested frequency", device, nil),
+		irqPerSec:     prometheus.NewDesc(name("irq_per_sec"), irqHelp, device, nil),
+		irqDelta:      prometheus.NewDesc(name("irq_delta"), "Change in Intel GPU IRQs per second since the previous sample", device, nil),
+		powerGPU:      prometheus.NewDesc(name("power_gpu_watts"), powerGPUHelp, device, nil),
+		powerPackage:  prometheus.NewDesc(name("power_package_watts"), powerPackageHelp, device, nil),
+		imcReads:      prometheus.NewDesc(name("imc_reads_mib_per_sec"), "Memory controller read bandwidth in MiB/s, when reported", device, nil),
+		imcWrites:     prometheus.NewDesc(name("imc_writes_mib_per_sec"), "Memory controller write bandwidth in MiB/s, when reported", device, nil),
+		fanRPM:        prometheus.NewDesc(name("fan_rpm"), "Intel GPU fan speed in RPM, when reported", device, nil),
+		voltage:       prometheus.NewDesc(name("voltage_volts"), "Intel GPU voltage in volts, when reported", device, nil),
+		lastSample:    prometheus.NewDesc(name("exporter_last_sample_timestamp_seconds"), "Unix time the latest intel_gpu_top record was parsed", device, nil),
+		rc6:           prometheus.NewDesc(percentName("rc6"), rc6Help, []string{deviceLabel, "state"}, nil),
+		busy:          prometheus.NewDesc(percentName("busy"), busyHelp, device, nil),
+		engine:        prometheus.NewDesc(percentName("engine"), engineHelp, append(engine, "type"), nil),
+		semaWaitRatio: prometheus.NewDesc(name("engine_sema_wait_ratio"), "Share of Intel GPU engine stall time spent on semaphores rather than waits, sema/(sema+wait)", engine, nil),
+		occupancy:     prometheus.NewDesc(percentName("engine_occupancy"), occupancyHelp, engine, nil),
+		client:        prometheus.NewDesc(percentName("client_engine"), clientHelp, []string{deviceLabel, "pid", "name", "engine"}, nil),
+	}
+	if opts.window > 0 {
+		for _, stat := range windowStats {
+			// Variants are named after the metric they aggregate, e.g.
+			// intel_gpu_engine_percent_max
+			variant := func(name, help string, labels []string) *prometheus.Desc {
+				return prometheus.NewDesc(name+"_"+stat.suffix, help+", "+stat.help+" over the -aggregate window", labels, nil)
+			}
+			c.windows = append(c.windows, windowDescs{
+				windowStat:    stat,
+				freqRequested: variant(name("freq_mhz_requested"), freqRequestedHelp, device),
+				freqActual:    variant(name("freq_mhz_actual"), freqActualHelp, device),
+				irqPerSec:     variant(name("irq_per_sec"), irqHelp, device),
+				powerGPU:      variant(name("power_gpu_watts"), powerGPUHelp, device),
+				powerPackage:  variant(name("power_package_watts"), powerPackageHelp, device),
+				rc6:           variant(percentName("rc6"), rc6Help, []string{deviceLabel, "state"}),
+				busy:          variant(percentName("busy"), busyHelp, device),
+				engine:        variant(percentName("engine"), engineHelp, append(engine, "type")),
+			})
+		}
+	}
+	c.registry.MustRegister(c)
+	return c
+}
+
+// namespaced returns reg prefixing the names of the metrics registered
+// through it with namespace. Package-level metrics are named without a
+// namespace and registered this way, as -namespace is only known at run
+// time.
+func namespaced(reg prometheus.Registerer, namespace string) prometheus.Registerer {
+	if namespace == "" {
+		return reg
+	}
+	return prometheus.WrapRegistererWithPrefix(namespace+"_", reg)
+}
+
+// registerExporterMetrics registers the process-wide metrics every exporter
+// serves alongside the GPU metrics on reg, including the Go runtime and
+// process collectors the default registry would have. Every metric but the
+// Go and process ones is named under namespace.
+func registerExporterMetrics(reg prometheus.Registerer, namespace string) {
+	reg.MustRegister(collectors.NewGoCollector())
+	reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	reg = namespaced(reg, namespace)
+	reg.MustRegister(BuildInfoGauge)
+	reg.MustRegister(ConfigInfo)
+	reg.MustRegister(DeviceInfo)
+	reg.MustRegister(SamplesDroppedCounter)
+	reg.MustRegister(HeaderReparsedCounter)
+	reg.MustRegister(ParseSuccessRatioGauge)
+	reg.MustRegister(RecordsCounter, ParseErrorsCounter)
+	reg.MustRegister(HeartbeatCounter)
+	reg.MustRegister(GPUTopRestartsCounter)
+	reg.MustRegister(ExporterUpGauge)
+	reg.MustRegister(GPUTopFailureGauge)
+	reg.MustRegister(ScrapeDurationHistogram, ScrapeErrorsCounter)
+}
+
+// engineLabelNames returns the labels identifying an engine of a device,
+// with split as set by -split-engine-instance.
+func engineLabelNames(split bool) []string {
+	if split {
+		return []string{deviceLabel, "engine", "engine_instance"}
+	}
+	return []string{deviceLabel, "engine"}
+}
+
+// engineLabelValues returns the values of engineLabelNames for the engine
+// called name on device. Engines without an instance suffix, as on GPUs
+// with a single engine of each class, are instance 0.
+func engineLabelValues(split bool, device, name string) []string {
+	if split {
+		base, instance, ok := strings.Cut(name, "/")
+		if !ok {
+			instance = "0"
+		}
+		return []string{device, base, instance}
+	}
+	return []string{device, name}
+}
+
+// descs returns the metrics the Collector publishes. With compact only the
+// high-level set is published, without engine occupancy or clients, and
+// disabled families are never published.
+func (c *Collector) descs() []*prometheus.Desc {
+	disabled := c.opts.disabled
+	descs := []*prometheus.Desc{c.freqActual, c.busy, c.powerGPU, c.powerPackage, c.lastSample}
+	if !disabled.rc6 {
+		descs = append(descs, c.rc6)
+	}
+	for _, w := range c.windows {
+		descs = append(descs, w.descs(c.opts.compact, disabled)...)
+	}
+	if c.opts.compact {
+		return descs
+	}
+	descs = append(descs, c.freqRequested, c.throttleGap, c.engine)
+	if !disabled.irq {
+		descs = append(descs, c.irqPerSec, c.irqDelta)
+	}
+	if !disabled.sema && !disabled.wait {
+		descs = append(descs, c.semaWaitRatio)
+	}
+	descs = append(descs, c.imcReads, c.imcWrites, c.fanRPM, c.voltage)
+	if c.opts.engineOccupancy {
+		descs = append(descs, c.occupancy)
+	}
+	if c.opts.clients {
+		descs = append(descs, c.client)
+	}
+	return descs
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.descs() {
+		ch <- desc
+	}
+}
+
+// maxPendingSamples bounds the samples kept per device between scrapes
+// with -scrape-aggregate, so an exporter nobody scrapes doesn't grow without
+// bound. The oldest are dropped beyond it.
+const maxPendingSamples = 3600
+
+// aggregating reports whether scrapes aggregate the samples since the
+// previous one rather than report the latest.
+func (c *Collector) aggregating() bool {
+	return c.opts.scrapeAggregate != "" && c.opts.scrapeAggregate != aggregateLatest
+}
+
+// Update stores stats as the latest sample of its device. prev is the
+// previously published sample, or nil for the first one. With
+// -scrape-aggregate the sample is held until the next scrape instead.
+func (c *Collector) Update(stats IntelTopStats, prev *IntelTopStats) {
+	SampleSequenceCounter.Inc()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.updated[stats.Device] = now
+	if c.opts.window > 0 {
+		c.recordWindow(stats, now)
+	}
+	if c.aggregating() {
+		pending := append(c.pending[stats.Device], stats)
+		if len(pending) > maxPendingSamples {
+			pending = slices.Delete(pending, 0, len(pending)-maxPendingSamples)
+		}
+		c.pending[stats.Device] = pending
+		return
+	}
+
+	sample := collectorSample{stats: stats}
+	if prev != nil {
+		delta := stats.IRQPerSec - prev.IRQPerSec
+		sample.irqDelta = &delta
+	}
+	c.samples[stats.Device] = sample
+	c.updateSeriesCount()
+}
+
+// Snapshot returns the latest sample of each device, ordered by device,
+// including any a scrape hasn't aggregated yet.
+func (c *Collector) Snapshot() []IntelTopStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	latest := make(map[string]IntelTopStats, len(c.samples))
+	for device, sample := range c.samples {
+		latest[device] = sample.stats
+	}
+	for device, pending := range c.pending {
+		if len(pending) > 0 {
+			latest[device] = pending[len(pending)-1]
+		}
+	}
+
+	snapshot := make([]IntelTopStats, 0, len(latest))
+	for _, device := range slices.Sorted(maps.Keys(latest)) {
+		snapshot = append(snapshot, latest[device])
+	}
+	return snapshot
+}
+
+// aggregatePending replaces the sample of every device with samples since
+// the previous scrape by their aggregate, so a burst between scrapes isn't
+// lost. Devices without new samples keep their previous aggregate. c.mu must
+// be held.
+func (c *Collector) aggregatePending() {
+	if len(c.pending) == 0 {
+		return
+	}
+	for device, pending := range c.pending {
+		agg := aggregateStats(pending, c.opts.scrapeAggregate)
+		sample := collectorSample{stats: agg}
+		// The IRQ delta is taken between consecutive aggregates
+		if prev, ok := c.samples[device]; ok {
+			delta := agg.IRQPerSec - prev.stats.IRQPerSec
+			sample.irqDelta = &delta
+		}
+		c.samples[device] = sample
+	}
+	clear(c.pending)
+	c.updateSeriesCount()
+}
+
+// seriesPerEngine is how many series each engine publishes: busy, sema and
+// wait engine percentages plus its sema/wait ratio. -engine-occupancy adds
+// one more, -aggregate repeats the percentages for each of its aggregates,
+// and -disable-sema and -disable-wait take some away.
+const seriesPerEngine = 4
+
+// updateSeriesCount sets SeriesCountGauge from the engines and, with
+// -enable-clients, the clients of the latest samples. -compact publishes
+// neither. c.mu must be held.
+func (c *Collector) updateSeriesCount() {
+	if c.opts.compact {
+		SeriesCountGauge.Set(0)
+		return
+	}
+	engines, clients := 0, 0
+	for _, sample := range c.samples {
+		engines += len(sample.stats.Engine)
+		if c.opts.clients {
+			clients += len(clientBusy(sample.stats.Clients))
+		}
+	}
+	perEngine := seriesPerEngine
+	if c.opts.engineOccupancy {
+		perEngine++
+	}
+	// The busy, sema and wait percentages, repeated per -aggregate aggregate
+	percentages := 3
+	if c.opts.disabled.sema {
+		perEngine--
+		percentages--
+	}
+	if c.opts.disabled.wait {
+		perEngine--
+		percentages--
+	}
+	if c.opts.disabled.sema || c.opts.disabled.wait {
+		// The sema/wait ratio
+		perEngine--
+	}
+	perEngine += len(c.windows) * percentages
+	SeriesCountGauge.Set(float64(engines*perEngine + clients))
+}
+
+// Collect implements prometheus.Collector, reporting the latest sample of
+// every device, or with -scrape-aggregate the aggregate of its samples since
+// the previous scrape, along with the aggregates of -aggregate over the
+// samples in its window.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.aggregatePending()
+	for device, sample := range c.samples {
+		c.collectSample(ch, device, sample)
+	}
+
+	// A device that stopped delivering samples drops out once its last
+	// leaves the window
+	now := c.now()
+	for device := range c.window {
+		c.pruneWindow(device, now)
+	}
+	for device, samples := range c.window {
+		c.collectWindow(ch, device, samples)
+	}
+}
+
+// collectSample reports sample, the latest of device.
+func (c *Collector) collectSample(ch chan<- prometheus.Metric, device string, sample collectorSample) {
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+	// Optional readings only have a series while reported
+	optional := func(desc *prometheus.Desc, value *float64) {
+		if value != nil {
+			gauge(desc, *value, device)
+		}
+	}
+
+	stats := sample.stats
+	disabled := c.opts.disabled
+	gauge(c.freqActual, stats.FreqMhzActual, device)
+	if !disabled.rc6 {
+		gauge(c.rc6, stats.Rc6Percent, device, "rc6")
+		for state, value := range stats.Rc6StatePercent {
+			gauge(c.rc6, value, device, state)
+		}
+	}
+	gauge(c.busy, gpuBusy(stats), device)
+	optional(c.powerGPU, stats.PowerGPUWatts)
+	optional(c.powerPackage, stats.PowerPackageWatts)
+	if updated, ok := c.updated[device]; ok {
+		gauge(c.lastSample, float64(updated.UnixNano())/1e9, device)
+	}
+	if c.opts.compact {
+		return
+	}
+
+	gauge(c.freqRequested, stats.FreqMhzRequested, device)
+	gauge(c.throttleGap, stats.FreqMhzRequested-stats.FreqMhzActual, device)
+	if !disabled.irq {
+		gauge(c.irqPerSec, stats.IRQPerSec, device)
+		optional(c.irqDelta, sample.irqDelta)
+	}
+	optional(c.imcReads, stats.IMCReadsMiBs)
+	optional(c.imcWrites, stats.IMCWritesMiBs)
+	optional(c.fanRPM, stats.FanRPM)
+	optional(c.voltage, stats.VoltageVolts)
+
+	for name, engine := range stats.Engine {
+		labels := engineLabelValues(c.opts.splitEngineInstance, device, name)
+		gauge(c.engine, engine.BusyPercent, append(labels, "busy")...)
+		if !disabled.sema {
+			gauge(c.engine, engine.SemaPercent, append(labels, "sema")...)
+		}
+		if !disabled.wait {
+			gauge(c.engine, engine.WaitPercent, append(labels, "wait")...)
+		}
+		if !disabled.sema && !disabled.wait {
+			gauge(c.semaWaitRatio, semaWaitRatio(engine), labels...)
+		}
+		if c.opts.engineOccupancy {
+			gauge(c.occupancy, occupancy(engine, c.fullScale), labels...)
+		}
+	}
+
+	if c.opts.clients {
+		for key, value := range clientBusy(stats.Clients) {
+			gauge(c.client, value, device, key.pid, key.name, key.engine)
+		}
+	}
+}
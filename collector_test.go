@@ -0,0 +1,396 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// collected returns the values col reports for the metric called name,
+// keyed by their labels as in `device="",engine="RCS"`.
+func collected(c *qt.C, col *Collector, name string) map[string]float64 {
+	c.Helper()
+
+	families, err := col.registry.Gather()
+	c.Assert(err, qt.IsNil)
+	values := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make([]string, 0, len(metric.GetLabel()))
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+			}
+			values[strings.Join(labels, ",")] = metric.GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+func TestCollectorCollect(t *testing.T) {
+	power := 4.25
+	stats := IntelTopStats{
+		FreqMhzRequested: 1200,
+		FreqMhzActual:    1150,
+		IRQPerSec:        250,
+		Rc6Percent:       12.5,
+		PowerGPUWatts:    &power,
+		Engine: map[string]IntelEngine{
+			"RCS":   {BusyPercent: 60, SemaPercent: 3, WaitPercent: 1},
+			"VCS/1": {BusyPercent: 40},
+		},
+	}
+	prev := IntelTopStats{IRQPerSec: 200}
+
+	tests := []struct {
+		name  string
+		opts  collectorOptions
+		names []string
+		want  string
+	}{
+		{
+			name:  "Full",
+			opts:  collectorOptions{namespace: defaultNamespace},
+			names: []string{"intel_gpu_freq_mhz_requested", "intel_gpu_irq_delta", "intel_gpu_power_gpu_watts", "intel_gpu_engine_percent", "intel_gpu_engine_sema_wait_ratio"},
+			want: `
+# HELP intel_gpu_engine_percent Intel GPU engine busy percentage
+# TYPE intel_gpu_engine_percent gauge
+intel_gpu_engine_percent{device="",engine="RCS",type="busy"} 60
+intel_gpu_engine_percent{device="",engine="RCS",type="sema"} 3
+intel_gpu_engine_percent{device="",engine="RCS",type="wait"} 1
+intel_gpu_engine_percent{device="",engine="VCS/1",type="busy"} 40
+intel_gpu_engine_percent{device="",engine="VCS/1",type="sema"} 0
+intel_gpu_engine_percent{device="",engine="VCS/1",type="wait"} 0
+# HELP intel_gpu_engine_sema_wait_ratio Share of Intel GPU engine stall time spent on semaphores rather than waits, sema/(sema+wait)
+# TYPE intel_gpu_engine_sema_wait_ratio gauge
+intel_gpu_engine_sema_wait_ratio{device="",engine="RCS"} 0.75
+intel_gpu_engine_sema_wait_ratio{device="",engine="VCS/1"} 0
+# HELP intel_gpu_freq_mhz_requested Intel GPU requested frequency in MHz
+# TYPE intel_gpu_freq_mhz_requested gauge
+intel_gpu_freq_mhz_requested{device=""} 1200
+# HELP intel_gpu_irq_delta Change in Intel GPU IRQs per second since the previous sample
+# TYPE intel_gpu_irq_delta gauge
+intel_gpu_irq_delta{device=""} 50
+# HELP intel_gpu_power_gpu_watts Intel GPU power draw in watts, when reported
+# TYPE intel_gpu_power_gpu_watts gauge
+intel_gpu_power_gpu_watts{device=""} 4.25
+`,
+		},
+		{
+			name:  "Compact",
+			opts:  collectorOptions{namespace: defaultNamespace, compact: true},
+			names: []string{"intel_gpu_freq_mhz_actual", "intel_gpu_freq_mhz_requested", "intel_gpu_busy_percent", "intel_gpu_engine_percent"},
+			want: `
+# HELP intel_gpu_busy_percent Intel GPU busy percentage of its busiest engine
+# TYPE intel_gpu_busy_percent gauge
+intel_gpu_busy_percent{device=""} 60
+# HELP intel_gpu_freq_mhz_actual Intel GPU actual frequency in MHz
+# TYPE intel_gpu_freq_mhz_actual gauge
+intel_gpu_freq_mhz_actual{device=""} 1150
+`,
+		},
+		{
+			name:  "SplitEngineInstance",
+			opts:  collectorOptions{namespace: defaultNamespace, splitEngineInstance: true, engineOccupancy: true},
+			names: []string{"intel_gpu_engine_occupancy_percent"},
+			want: `
+# HELP intel_gpu_engine_occupancy_percent Intel GPU engine busy plus semaphore and wait percentage, capped at 100
+# TYPE intel_gpu_engine_occupancy_percent gauge
+intel_gpu_engine_occupancy_percent{device="",engine="RCS",engine_instance="0"} 64
+intel_gpu_engine_occupancy_percent{device="",engine="VCS",engine_instance="1"} 40
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			col := newCollector(tt.opts)
+			col.Update(stats, &prev)
+			err := testutil.CollectAndCompare(col, strings.NewReader(tt.want), tt.names...)
+			c.Assert(err, qt.IsNil)
+		})
+	}
+}
+
+func TestCollectorLastSampleTimestamp(t *testing.T) {
+	c := qt.New(t)
+
+	now := time.Unix(1700000000, 0)
+	col := newCollector(collectorOptions{namespace: defaultNamespace, compact: true})
+	col.now = func() time.Time { return now }
+	c.Assert(collected(c, col, "intel_gpu_exporter_last_sample_timestamp_seconds"), qt.HasLen, 0)
+
+	col.Update(IntelTopStats{Device: "card0"}, nil)
+	c.Assert(collected(c, col, "intel_gpu_exporter_last_sample_timestamp_seconds"), qt.DeepEquals, map[string]float64{
+		`device="card0"`: 1700000000,
+	})
+
+	// Each record moves it forward, per device
+	now = now.Add(1500 * time.Millisecond)
+	col.Update(IntelTopStats{Device: "card0"}, nil)
+	now = now.Add(time.Second)
+	col.Update(IntelTopStats{Device: "card1"}, nil)
+	c.Assert(collected(c, col, "intel_gpu_exporter_last_sample_timestamp_seconds"), qt.DeepEquals, map[string]float64{
+		`device="card0"`: 1700000001.5,
+		`device="card1"`: 1700000002.5,
+	})
+}
+
+func TestCollectorsIndependent(t *testing.T) {
+	c := qt.New(t)
+
+	// Each Collector has its own samples and registry, so they can't clash
+	a := newCollector(collectorOptions{namespace: defaultNamespace})
+	b := newCollector(collectorOptions{namespace: defaultNamespace, fraction: true})
+
+	a.Update(IntelTopStats{FreqMhzActual: 1150, Engine: map[string]IntelEngine{"RCS": {BusyPercent: 40}}}, nil)
+	b.Update(IntelTopStats{FreqMhzActual: 300, Engine: map[string]IntelEngine{"VCS": {BusyPercent: 0.5}}}, nil)
+
+	c.Assert(collected(c, a, "intel_gpu_freq_mhz_actual"), qt.DeepEquals, map[string]float64{`device=""`: 1150})
+	c.Assert(collected(c, b, "intel_gpu_freq_mhz_actual"), qt.DeepEquals, map[string]float64{`device=""`: 300})
+
+	count, err := testutil.GatherAndCount(a.registry, "intel_gpu_engine_percent")
+	c.Assert(err, qt.IsNil)
+	c.Assert(count, qt.Equals, 3)
+	count, err = testutil.GatherAndCount(b.registry, "intel_gpu_engine_percent")
+	c.Assert(err, qt.IsNil)
+	c.Assert(count, qt.Equals, 3)
+}
+
+func TestScrapeAggregate(t *testing.T) {
+	c := qt.New(t)
+
+	burst := func(col *Collector, busy ...float64) {
+		for _, b := range busy {
+			col.Update(IntelTopStats{FreqMhzActual: 10 * b, Engine: map[string]IntelEngine{"RCS": {BusyPercent: b}}}, nil)
+		}
+	}
+
+	tests := []struct {
+		method string
+		// want is the busy each of three scrapes reports
+		want []float64
+	}{
+		{method: aggregateLatest, want: []float64{20, 20, 5}},
+		{method: aggregateMax, want: []float64{90, 90, 5}},
+		{method: aggregateMean, want: []float64{40, 40, 5}},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.method, func(c *qt.C) {
+			col := newCollector(collectorOptions{namespace: defaultNamespace, scrapeAggregate: tt.method})
+			scrape := func() float64 {
+				return collected(c, col, "intel_gpu_busy_percent")[`device=""`]
+			}
+
+			// A short burst between scrapes
+			burst(col, 10, 90, 20)
+			c.Assert(scrape(), qt.Equals, tt.want[0])
+			c.Assert(collected(c, col, "intel_gpu_freq_mhz_actual")[`device=""`], qt.Equals, 10*tt.want[0])
+
+			// No samples since the previous scrape
+			c.Assert(scrape(), qt.Equals, tt.want[1])
+
+			// The burst doesn't carry over into the next scrape
+			burst(col, 5)
+			c.Assert(scrape(), qt.Equals, tt.want[2])
+		})
+	}
+}
+
+func TestCollectorNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		want      []string
+	}{
+		{
+			name:      "Default",
+			namespace: defaultNamespace,
+			want:      []string{"intel_gpu_busy_percent", "intel_gpu_exporter_heartbeat_total", "intel_gpu_exporter_last_sample_timestamp_seconds", "intel_gpu_freq_mhz_actual", "intel_gpu_rc6_percent"},
+		},
+		{
+			name:      "Custom",
+			namespace: "lab_gpu0",
+			want:      []string{"lab_gpu0_busy_percent", "lab_gpu0_exporter_heartbeat_total", "lab_gpu0_exporter_last_sample_timestamp_seconds", "lab_gpu0_freq_mhz_actual", "lab_gpu0_rc6_percent"},
+		},
+		{
+			name: "Dropped",
+			want: []string{"busy_percent", "exporter_heartbeat_total", "exporter_last_sample_timestamp_seconds", "freq_mhz_actual", "rc6_percent"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			// GPU metrics are named by the collector, package-level ones as
+			// they are registered
+			col := newCollector(collectorOptions{namespace: tt.namespace, compact: true})
+			namespaced(col.registry, tt.namespace).MustRegister(HeartbeatCounter)
+			col.Update(IntelTopStats{FreqMhzActual: 1150}, nil)
+
+			families, err := col.registry.Gather()
+			c.Assert(err, qt.IsNil)
+			var names []string
+			for _, family := range families {
+				names = append(names, family.GetName())
+			}
+			c.Assert(names, qt.DeepEquals, tt.want)
+		})
+	}
+}
+
+func TestCollectorDisabledFamilies(t *testing.T) {
+	stats := IntelTopStats{
+		FreqMhzActual: 1150,
+		IRQPerSec:     500,
+		Rc6Percent:    12.5,
+		Engine: map[string]IntelEngine{
+			"RCS": {BusyPercent: 60, SemaPercent: 3, WaitPercent: 1},
+		},
+	}
+	families := []string{
+		`intel_gpu_engine_percent{device="",engine="RCS",type="busy"}`,
+		`intel_gpu_engine_percent{device="",engine="RCS",type="sema"}`,
+		`intel_gpu_engine_percent{device="",engine="RCS",type="wait"}`,
+		`intel_gpu_engine_sema_wait_ratio{`,
+		`intel_gpu_irq_per_sec{`,
+		`intel_gpu_irq_delta{`,
+		`intel_gpu_rc6_percent{`,
+	}
+
+	tests := []struct {
+		name     string
+		disabled disabledFamilies
+		// absent are the families expected missing from /metrics.
+		absent []string
+	}{
+		{
+			name: "None",
+		},
+		{
+			name:     "Sema",
+			disabled: disabledFamilies{sema: true},
+			absent:   []string{families[1], families[3]},
+		},
+		{
+			name:     "Wait",
+			disabled: disabledFamilies{wait: true},
+			absent:   []string{families[2], families[3]},
+		},
+		{
+			name:     "IRQ",
+			disabled: disabledFamilies{irq: true},
+			absent:   []string{families[4], families[5]},
+		},
+		{
+			name:     "RC6",
+			disabled: disabledFamilies{rc6: true},
+			absent:   []string{families[6]},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			col := newCollector(collectorOptions{namespace: defaultNamespace, disabled: tt.disabled})
+			col.Update(stats, nil)
+			// A second sample gives irq_delta a value
+			col.Update(stats, &stats)
+			server := httptest.NewServer(newMetricsMux(col.registry, defaultMetricsPath, false))
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + defaultMetricsPath)
+			c.Assert(err, qt.IsNil)
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			c.Assert(err, qt.IsNil)
+
+			for _, family := range families {
+				c.Assert(strings.Contains(string(body), family), qt.Equals, !slices.Contains(tt.absent, family), qt.Commentf("%s", family))
+			}
+		})
+	}
+}
+
+func TestCollectorRatio(t *testing.T) {
+	stats := IntelTopStats{
+		Rc6Percent: 12.5,
+		Engine: map[string]IntelEngine{
+			"RCS": {BusyPercent: 60, SemaPercent: 3, WaitPercent: 1},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		ratio bool
+		want  string
+	}{
+		{
+			name: "Percent",
+			want: `
+# HELP intel_gpu_busy_percent Intel GPU busy percentage of its busiest engine
+# TYPE intel_gpu_busy_percent gauge
+intel_gpu_busy_percent{device=""} 60
+# HELP intel_gpu_engine_percent Intel GPU engine busy percentage
+# TYPE intel_gpu_engine_percent gauge
+intel_gpu_engine_percent{device="",engine="RCS",type="busy"} 60
+intel_gpu_engine_percent{device="",engine="RCS",type="sema"} 3
+intel_gpu_engine_percent{device="",engine="RCS",type="wait"} 1
+# HELP intel_gpu_rc6_percent Intel GPU RC6 power state percentage
+# TYPE intel_gpu_rc6_percent gauge
+intel_gpu_rc6_percent{device="",state="rc6"} 12.5
+`,
+		},
+		{
+			name:  "Ratio",
+			ratio: true,
+			want: `
+# HELP intel_gpu_busy_ratio Intel GPU busy of its busiest engine as a 0-1 fraction
+# TYPE intel_gpu_busy_ratio gauge
+intel_gpu_busy_ratio{device=""} 0.6
+# HELP intel_gpu_engine_ratio Intel GPU engine busy as a 0-1 fraction
+# TYPE intel_gpu_engine_ratio gauge
+intel_gpu_engine_ratio{device="",engine="RCS",type="busy"} 0.6
+intel_gpu_engine_ratio{device="",engine="RCS",type="sema"} 0.03
+intel_gpu_engine_ratio{device="",engine="RCS",type="wait"} 0.01
+# HELP intel_gpu_rc6_ratio Intel GPU RC6 power state residency as a 0-1 fraction
+# TYPE intel_gpu_rc6_ratio gauge
+intel_gpu_rc6_ratio{device="",state="rc6"} 0.125
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			// The same sample is scaled on its way to the collector, as run
+			// wires -ratio
+			col := newCollector(collectorOptions{namespace: defaultNamespace, fraction: tt.ratio, ratio: tt.ratio})
+			var sink MetricsSink = prometheusSink{collector: col}
+			if tt.ratio {
+				sink = fractionSink{next: sink}
+			}
+			sink.Update(stats, nil)
+
+			names := []string{"intel_gpu_busy_percent", "intel_gpu_engine_percent", "intel_gpu_rc6_percent", "intel_gpu_busy_ratio", "intel_gpu_engine_ratio", "intel_gpu_rc6_ratio"}
+			err := testutil.CollectAndCompare(col, strings.NewReader(tt.want), names...)
+			c.Assert(err, qt.IsNil)
+		})
+	}
+}
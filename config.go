@@ -0,0 +1,300 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mikeodr/intel-gpu-exporter-go/pkg/igtparse"
+)
+
+// redactedValue replaces sensitive settings in ConfigInfo.
+const redactedValue = "<redacted>"
+
+// sensitiveFlags are never exposed in ConfigInfo.
+var sensitiveFlags = map[string]bool{
+	"remote-write-password": true,
+	"auth-pass":             true,
+}
+
+// urlFlags are URLs whose userinfo, which can hold credentials, is redacted
+// in ConfigInfo.
+var urlFlags = map[string]bool{
+	"remote-write-url": true,
+}
+
+var ConfigInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "exporter_config_info",
+	Help: "Effective exporter settings, one series per setting with value 1",
+}, []string{"setting", "value"})
+
+// config holds the exporter's settings, populated from command-line flags.
+type config struct {
+	port                int
+	listen              string
+	metricsPath         string
+	unixSocket          string
+	authUser            string
+	authPass            string
+	interval            time.Duration
+	exporter            string
+	remoteWriteURL      string
+	remoteWriteUsername string
+	remoteWritePassword string
+	remoteWriteBuffer   int
+	onlyOnChange        bool
+	changeEpsilon       float64
+	aggregateWindow     time.Duration
+	aggregateMethod     string
+	scrapeAggregate     string
+	aggregate           string
+	columnMap           string
+	dumpPath            string
+	idleAfter           time.Duration
+	idleThreshold       float64
+	idleInterval        time.Duration
+	adaptive            bool
+	adaptiveHigh        float64
+	adaptiveLow         float64
+	adaptiveBusy        time.Duration
+	adaptiveIdle        time.Duration
+	collectInternal     bool
+	staleAfter          time.Duration
+	readTimeout         time.Duration
+	termTimeout         time.Duration
+	sinkAsync           bool
+	sinkAsyncBuffer     int
+	source              string
+	input               string
+	inputLoop           bool
+	binary              string
+	sudo                bool
+	devices             stringList
+	devicePaths         stringList
+	format              string
+	summaries           bool
+	summaryObjectives   string
+	summaryMaxAge       time.Duration
+	tlsCert             string
+	tlsKey              string
+	fraction            bool
+	ratio               bool
+	goroutineWarn       int
+	debugListenAddress  string
+	pprof               bool
+	histograms          bool
+	splitEngineInstance bool
+	engineOccupancy     bool
+	enableClients       bool
+	openMetrics         bool
+	compact             bool
+	disabled            disabledFamilies
+	namespace           string
+	memoryTotalFile     string
+	memoryUsedFile      string
+	csvOutput           string
+	syslog              bool
+	syslogInterval      time.Duration
+	syslogPriority      string
+	singleInstance      bool
+	lockFile            string
+	maxFreqMhz          float64
+	maxIRQPerSec        float64
+	strict              bool
+	busyWeightedWindow  time.Duration
+	ui                  bool
+	freqBins            string
+	logFormat           string
+	logLevel            string
+}
+
+// registerFlags defines a flag on fs for every setting in c.
+func (c *config) registerFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.port, "port", 8080, "Port to expose metrics on, on all interfaces")
+	fs.StringVar(&c.authUser, "auth-user", "", "Require HTTP basic auth with this username on the metrics listener (needs -auth-pass)")
+	fs.StringVar(&c.authPass, "auth-pass", "", "Password for -auth-user")
+	fs.StringVar(&c.listen, "listen", "", "Address to expose metrics on, e.g. 127.0.0.1:9102; takes precedence over -port")
+	fs.StringVar(&c.metricsPath, "metrics-path", defaultMetricsPath, "Path to serve metrics on, e.g. behind a reverse proxy")
+	fs.StringVar(&c.unixSocket, "unix-socket", "", "Serve metrics on this Unix domain socket instead of TCP; can't be combined with -listen or -port")
+	fs.DurationVar(&c.interval, "interval", time.Second, "intel_gpu_top sampling interval")
+	fs.StringVar(&c.exporter, "exporter", "prometheus", "Metrics backend: prometheus or remote-write")
+	fs.StringVar(&c.remoteWriteURL, "remote-write-url", "", "Prometheus remote_write endpoint URL")
+	fs.StringVar(&c.remoteWriteUsername, "remote-write-username", "", "Basic auth username for the remote_write endpoint")
+	fs.StringVar(&c.remoteWritePassword, "remote-write-password", "", "Basic auth password for the remote_write endpoint")
+	fs.IntVar(&c.remoteWriteBuffer, "remote-write-buffer", 100, "Samples to buffer for remote_write before dropping")
+	fs.BoolVar(&c.onlyOnChange, "only-on-change", false, "Skip pushing samples identical to the last pushed one (push backends only)")
+	fs.Float64Var(&c.changeEpsilon, "change-epsilon", 0.01, "Largest difference treated as unchanged by -only-on-change")
+	fs.DurationVar(&c.aggregateWindow, "aggregate-window", 0, "Publish one aggregate of all samples per window instead of every sample (0 disables)")
+	fs.StringVar(&c.aggregateMethod, "aggregate-method", aggregateMean, "Aggregation used with -aggregate-window: mean or max")
+	fs.StringVar(&c.scrapeAggregate, "scrape-aggregate", aggregateLatest, "What each scrape reports of the samples since the previous one: latest, mean or max")
+	fs.StringVar(&c.aggregate, "aggregate", "", "Also publish _min, _avg and _max of the key metrics over a sliding window, e.g. window=5s (empty disables)")
+	fs.StringVar(&c.columnMap, "column-map", "", "File mapping CSV columns to metrics, overriding header auto-detection")
+	fs.StringVar(&c.dumpPath, "dump-path", "", "File the current metrics are written to on SIGUSR1 (empty disables)")
+	fs.BoolVar(&c.histograms, "histograms", false, "Expose histograms of RC6 residency")
+	fs.BoolVar(&c.splitEngineInstance, "split-engine-instance", false, "Publish engines such as VCS/1 with separate engine and engine_instance labels; unnumbered engines are instance 0")
+	fs.BoolVar(&c.enableClients, "enable-clients", false, "Publish per-process engine busy from the clients intel_gpu_top reports (needs -format=json)")
+	fs.BoolVar(&c.engineOccupancy, "engine-occupancy", false, "Also publish engine occupancy, busy+sema+wait capped at 100%")
+	fs.BoolVar(&c.openMetrics, "openmetrics", false, "Expose target_info with host, GPU and exporter metadata for OpenTelemetry-style joins")
+	fs.StringVar(&c.namespace, "namespace", defaultNamespace, "Prefix of every exporter metric name, e.g. to tell several exporters apart; empty drops it")
+	fs.BoolVar(&c.compact, "compact", false, "Publish only overall GPU busy, actual frequency, RC6 and power, without per-engine detail")
+	fs.BoolVar(&c.disabled.sema, "disable-sema", false, "Don't publish engine semaphore percentages, nor the sema/wait ratio")
+	fs.BoolVar(&c.disabled.wait, "disable-wait", false, "Don't publish engine wait percentages, nor the sema/wait ratio")
+	fs.BoolVar(&c.disabled.irq, "disable-irq", false, "Don't publish IRQ metrics")
+	fs.BoolVar(&c.disabled.rc6, "disable-rc6", false, "Don't publish RC6 residency")
+	fs.StringVar(&c.memoryTotalFile, "memory-total-file", "", "sysfs file holding total GPU memory in bytes")
+	fs.StringVar(&c.memoryUsedFile, "memory-used-file", "", "sysfs file holding used GPU memory in bytes")
+	fs.StringVar(&c.csvOutput, "csv-output", "", "Also write parsed samples as clean CSV to this file, or - for stdout")
+	fs.BoolVar(&c.syslog, "syslog", false, "Write a summary of the samples to the local syslog every -syslog-interval")
+	fs.DurationVar(&c.syslogInterval, "syslog-interval", time.Minute, "How often -syslog writes a summary")
+	fs.StringVar(&c.syslogPriority, "syslog-priority", "info", "Syslog priority of -syslog summaries, e.g. info or notice")
+	fs.BoolVar(&c.singleInstance, "single-instance", false, "Refuse to start while another exporter holds -lock-file")
+	fs.StringVar(&c.lockFile, "lock-file", defaultLockFile, "Lock file used by -single-instance")
+	fs.Float64Var(&c.maxFreqMhz, "max-freq-mhz", igtparse.DefaultLimits.FreqMhz, "Skip records reporting a frequency above this as implausible (0 disables)")
+	fs.Float64Var(&c.maxIRQPerSec, "max-irq-per-sec", igtparse.DefaultLimits.IRQPerSec, "Skip records reporting IRQs per second above this as implausible (0 disables)")
+	fs.BoolVar(&c.strict, "strict", false, "Skip records reporting a percentage outside 0-100 instead of clamping it into range")
+	fs.DurationVar(&c.busyWeightedWindow, "busy-weighted-window", 0, "Window for the busy-weighted average frequency (0 disables)")
+	fs.BoolVar(&c.ui, "ui", false, "Serve a live chart page at /ui")
+	fs.StringVar(&c.freqBins, "freq-bins", "", "Comma separated frequency band boundaries in MHz for time-in-band counters (empty disables)")
+	fs.DurationVar(&c.idleAfter, "idle-after", 0, "Sample at -idle-interval once every engine has been idle this long (0 disables)")
+	fs.Float64Var(&c.idleThreshold, "idle-threshold", 1, "Busy percentage below which an engine counts as idle")
+	fs.DurationVar(&c.idleInterval, "idle-interval", 10*time.Second, "intel_gpu_top sampling interval while idle")
+	fs.BoolVar(&c.adaptive, "adaptive-sampling", false, "Sample faster while the GPU is busy and slower otherwise")
+	fs.Float64Var(&c.adaptiveHigh, "adaptive-high", 50, "Busy percentage at or above which -adaptive-sampling speeds up")
+	fs.Float64Var(&c.adaptiveLow, "adaptive-low", 20, "Busy percentage below which -adaptive-sampling slows down again")
+	fs.DurationVar(&c.adaptiveBusy, "adaptive-busy-interval", 250*time.Millisecond, "intel_gpu_top sampling interval while busy with -adaptive-sampling")
+	fs.DurationVar(&c.adaptiveIdle, "adaptive-idle-interval", 5*time.Second, "intel_gpu_top sampling interval while not busy with -adaptive-sampling")
+	fs.DurationVar(&c.staleAfter, "stale-after", 0, "Report intel_gpu_exporter_up as 0 when no record was parsed for this long (0 uses three times the slowest sampling interval)")
+	fs.DurationVar(&c.readTimeout, "read-timeout", 30*time.Second, "Kill and relaunch intel_gpu_top when it writes no record for this long, at least twice the sampling interval (0 disables, -source=exec only)")
+	fs.DurationVar(&c.termTimeout, "term-timeout", defaultTermTimeout, "How long intel_gpu_top gets to exit after SIGTERM on shutdown or restart before it is sent SIGKILL (0 kills it straight away)")
+	fs.BoolVar(&c.collectInternal, "collect-internal", true, "Expose metrics about the exporter itself")
+	fs.BoolVar(&c.sinkAsync, "sink-async", false, "Publish to push backends from a separate goroutine so slow backends don't stall collection")
+	fs.IntVar(&c.sinkAsyncBuffer, "sink-async-buffer", 100, "Samples to buffer for -sink-async before dropping")
+	fs.StringVar(&c.source, "source", "exec", "Where intel_gpu_top output comes from: exec to launch it, or stdin")
+	fs.StringVar(&c.input, "input", "", "Replay intel_gpu_top output captured to this file, or - for stdin, instead of -source; the exporter stops at its end")
+	fs.BoolVar(&c.inputLoop, "input-loop", false, "Start -input over from the beginning once it ends, after the restart delay, instead of stopping")
+	fs.StringVar(&c.binary, "binary", "intel_gpu_top", "intel_gpu_top executable to launch with -source=exec, looked up in PATH unless it contains a slash")
+	fs.BoolVar(&c.sudo, "sudo", false, "Launch -binary through sudo -n, which needs a sudoers rule letting the exporter's user run it without a password")
+	fs.Var(&c.devices, "device", "intel_gpu_top -d device filter of a GPU to monitor, e.g. pci:slot=0000:03:00.0; repeat for several GPUs, told apart by the device label")
+	fs.Var(&c.devicePaths, "device-path", "DRM device node of a GPU to monitor, e.g. /dev/dri/card0 passed into a container, monitored as -device drm:<path>; repeatable")
+	fs.StringVar(&c.format, "format", formatCSV, "intel_gpu_top output format: csv (-c) or json (-J)")
+	fs.BoolVar(&c.summaries, "summaries", false, "Expose quantile summaries of actual frequency and GPU power (costly)")
+	fs.StringVar(&c.summaryObjectives, "summary-objectives", defaultSummaryObjectives, "Summary quantiles as comma separated quantile:error pairs")
+	fs.DurationVar(&c.summaryMaxAge, "summary-max-age", 10*time.Minute, "Sliding window summaries are computed over")
+	fs.StringVar(&c.tlsCert, "tls-cert", "", "TLS certificate file; serves HTTPS when set with -tls-key and is reloaded when it changes")
+	fs.StringVar(&c.tlsKey, "tls-key", "", "TLS private key file")
+	fs.BoolVar(&c.fraction, "fraction", false, "Publish percentages (engine busy/sema/wait, RC6) as 0-1 fractions")
+	fs.BoolVar(&c.ratio, "ratio", false, "Publish percentages as 0-1 ratios like -fraction, renaming their metrics from _percent to _ratio (Prometheus exporter only)")
+	fs.IntVar(&c.goroutineWarn, "goroutine-warn-threshold", 0, "Log a possible leak when the goroutine count keeps growing past this (0 disables, needs -collect-internal)")
+	fs.StringVar(&c.debugListenAddress, "debug-listen-address", "", "Address to serve /debug/pprof/ on, separate from /metrics (empty disables)")
+	fs.StringVar(&c.logFormat, "log-format", logFormatText, "Log output format: text or json")
+	fs.StringVar(&c.logLevel, "log-level", "info", "Least severe log level written: debug, info, warn or error")
+	fs.BoolVar(&c.pprof, "pprof", false, "Also serve /debug/pprof/ on the metrics listener; exposes profiling to anyone who can scrape")
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable
+// flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// singleDeviceFlags returns the flags set in c whose state or output isn't
+// kept per device, so they can't be combined with several -device flags.
+func (c *config) singleDeviceFlags() []string {
+	var flags []string
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"aggregate-window", c.aggregateWindow != 0},
+		{"only-on-change", c.onlyOnChange},
+		{"csv-output", c.csvOutput != ""},
+		{"syslog", c.syslog},
+		{"summaries", c.summaries},
+		{"histograms", c.histograms},
+		{"busy-weighted-window", c.busyWeightedWindow != 0},
+		{"freq-bins", c.freqBins != ""},
+	} {
+		if f.set {
+			flags = append(flags, "-"+f.name)
+		}
+	}
+	return flags
+}
+
+// listenAddress returns the address to serve metrics on: -listen when set,
+// otherwise -port on all interfaces.
+func (c *config) listenAddress() (string, error) {
+	if c.listen == "" {
+		if c.port <= 0 || c.port > 65535 {
+			return "", fmt.Errorf("invalid port number %d", c.port)
+		}
+		return fmt.Sprintf(":%d", c.port), nil
+	}
+
+	_, port, err := net.SplitHostPort(c.listen)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address: %w", err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid listen address %q: invalid port %q", c.listen, port)
+	}
+	return c.listen, nil
+}
+
+// gpuTopArgs returns the intel_gpu_top arguments for c monitoring device,
+// a -d filter or empty for intel_gpu_top's choice. The sampling interval is
+// added by the runner.
+func (c *config) gpuTopArgs(device string) []string {
+	args := []string{"-c"}
+	if c.format == formatJSON {
+		args = []string{"-J"}
+	}
+	if device != "" {
+		args = append(args, "-d", device)
+	}
+	return args
+}
+
+// setConfigInfo publishes the effective value of every flag defined on fs,
+// redacting sensitive ones.
+func setConfigInfo(fs *flag.FlagSet) {
+	ConfigInfo.Reset()
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if sensitiveFlags[f.Name] && value != "" {
+			value = redactedValue
+		}
+		if urlFlags[f.Name] {
+			value = redactURL(value)
+		}
+		ConfigInfo.WithLabelValues(f.Name, value).Set(1)
+	})
+}
+
+// redactURL replaces the userinfo of rawURL with redactedValue. A URL that
+// doesn't parse is redacted as a whole, as where its userinfo ends is
+// unknown.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redactedValue
+	}
+	if u.User == nil {
+		return rawURL
+	}
+	u.User = nil
+	return u.Scheme + "://" + redactedValue + "@" + strings.TrimPrefix(u.String(), u.Scheme+"://")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// csvSink re-emits samples as clean CSV in intel_gpu_top's column layout,
+// acting as a sanitising passthrough for tools that choke on the raw
+// stream. Values always use dot decimals regardless of locale. A header is
+// written before the first sample and again whenever the set of engines
+// changes, just as intel_gpu_top does. Power and IMC bandwidth columns are
+// only written while samples report them.
+type csvSink struct {
+	w *csv.Writer
+	// header is the last header written.
+	header []string
+}
+
+func newCSVSink(w io.Writer) *csvSink {
+	return &csvSink{w: csv.NewWriter(w)}
+}
+
+func (s *csvSink) Update(stats IntelTopStats, _ *IntelTopStats) {
+	header := []string{"Freq MHz req", "Freq MHz act", "IRQ /s", "RC6 %"}
+	record := []string{
+		formatCSVValue(stats.FreqMhzRequested),
+		formatCSVValue(stats.FreqMhzActual),
+		formatCSVValue(stats.IRQPerSec),
+		formatCSVValue(stats.Rc6Percent),
+	}
+	if stats.PowerGPUWatts != nil {
+		header = append(header, "Power W gpu")
+		record = append(record, formatCSVValue(*stats.PowerGPUWatts))
+	}
+	if stats.PowerPackageWatts != nil {
+		header = append(header, "Power W pkg")
+		record = append(record, formatCSVValue(*stats.PowerPackageWatts))
+	}
+	if stats.IMCReadsMiBs != nil {
+		header = append(header, "IMC MiB/s rd")
+		record = append(record, formatCSVValue(*stats.IMCReadsMiBs))
+	}
+	if stats.IMCWritesMiBs != nil {
+		header = append(header, "IMC MiB/s wr")
+		record = append(record, formatCSVValue(*stats.IMCWritesMiBs))
+	}
+
+	engines := slices.Sorted(maps.Keys(stats.Engine))
+	for _, name := range engines {
+		header = append(header, name+" %", name+" se", name+" wa")
+	}
+	if !slices.Equal(header, s.header) {
+		s.write(header)
+		s.header = header
+	}
+
+	for _, name := range engines {
+		e := stats.Engine[name]
+		record = append(record, formatCSVValue(e.BusyPercent), formatCSVValue(e.SemaPercent), formatCSVValue(e.WaitPercent))
+	}
+	s.write(record)
+}
+
+// write writes record and flushes it so consumers see samples as they come.
+func (s *csvSink) write(record []string) {
+	if err := s.w.Write(record); err != nil {
+		slog.Error("Error writing CSV output", "err", err)
+		return
+	}
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		slog.Error("Error writing CSV output", "err", err)
+	}
+}
+
+// formatCSVValue formats v with a dot decimal and no exponent.
+func formatCSVValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestCSVSink(t *testing.T) {
+	c := qt.New(t)
+
+	watts := func(w float64) *float64 { return &w }
+	samples := []IntelTopStats{
+		{
+			FreqMhzRequested: 1200,
+			FreqMhzActual:    1150.5,
+			IRQPerSec:        500,
+			Rc6Percent:       85.25,
+			Engine: map[string]IntelEngine{
+				"VCS": {BusyPercent: 8.9, SemaPercent: 4.5, WaitPercent: 1.8},
+				"RCS": {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+			},
+		},
+		{
+			FreqMhzRequested: 1300,
+			FreqMhzActual:    1250,
+			IRQPerSec:        1e7,
+			Rc6Percent:       90,
+			Engine: map[string]IntelEngine{
+				"RCS": {BusyPercent: 20.5},
+				"VCS": {BusyPercent: 18.8},
+			},
+		},
+		{
+			FreqMhzRequested: 300,
+			FreqMhzActual:    300,
+			Rc6Percent:       100,
+			Engine: map[string]IntelEngine{
+				"RCS": {},
+			},
+		},
+		{
+			FreqMhzRequested:  300,
+			FreqMhzActual:     300,
+			Rc6Percent:        100,
+			PowerGPUWatts:     watts(0.75),
+			PowerPackageWatts: watts(3.5),
+			Engine: map[string]IntelEngine{
+				"RCS": {},
+			},
+		},
+	}
+
+	var out strings.Builder
+	sink := newCSVSink(&out)
+	for _, stats := range samples {
+		sink.Update(stats, nil)
+	}
+
+	c.Assert(out.String(), qt.Equals, `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,VCS %,VCS se,VCS wa
+1200,1150.5,500,85.25,10.2,5.1,2.3,8.9,4.5,1.8
+1300,1250,10000000,90,20.5,0,0,18.8,0,0
+Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa
+300,300,0,100,0,0,0
+Freq MHz req,Freq MHz act,IRQ /s,RC6 %,Power W gpu,Power W pkg,RCS %,RCS se,RCS wa
+300,300,0,100,0.75,3.5,0,0,0
+`)
+
+	// The output reads back into the same samples
+	var results []IntelTopStats
+	for stats, err := range readMetrics(context.Background(), strings.NewReader(out.String()), nil) {
+		c.Assert(err, qt.IsNil)
+		results = append(results, stats)
+	}
+	c.Assert(results, qt.DeepEquals, samples)
+}
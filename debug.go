@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	ScrapeDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "exporter_scrape_duration_seconds",
+		Help:    "Time taken to serve the metrics endpoint",
+		Buckets: prometheus.DefBuckets,
+	}, nil)
+	ScrapeErrorsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "exporter_scrape_errors_total",
+		Help: "Errors gathering or encoding metrics while serving the metrics endpoint",
+	})
+)
+
+// defaultMetricsPath is where metrics are served unless -metrics-path says
+// otherwise.
+const defaultMetricsPath = "/metrics"
+
+// reservedPaths are served by the exporter itself, so metrics can't be.
+var reservedPaths = []string{"/", "/metadata", "/ui", "/healthz", "/readyz"}
+
+// checkMetricsPath reports whether -metrics-path is a path metrics can be
+// served on.
+func checkMetricsPath(path string) error {
+	switch {
+	case !strings.HasPrefix(path, "/"):
+		return fmt.Errorf("invalid -metrics-path %q: must start with /", path)
+	case strings.ContainsAny(path, " \t{}"):
+		return fmt.Errorf("invalid -metrics-path %q: must not contain spaces or braces", path)
+	case slices.Contains(reservedPaths, path) || strings.HasPrefix(path, "/debug/"):
+		return fmt.Errorf("invalid -metrics-path %q: already served by the exporter", path)
+	}
+	return nil
+}
+
+// relativeMetricsPath returns metricsPath relative to /, for links from
+// pages served at the root so they still work when a reverse proxy mounts
+// the exporter under a prefix.
+func relativeMetricsPath(metricsPath string) string {
+	return strings.TrimPrefix(metricsPath, "/")
+}
+
+// newMetricsMux returns the handler for the public listener, exposing g. It
+// serves only metricsPath and the read-only /metadata, so debug routes only
+// reach it when -pprof adds them explicitly. Scrapers asking for OpenMetrics
+// get it, others the Prometheus text format, and every scrape is timed in
+// ScrapeDurationHistogram. With ui, the live chart page is also served at
+// /ui.
+func newMetricsMux(g prometheus.Gatherer, metricsPath string, ui bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	metrics := promhttp.HandlerFor(g, promhttp.HandlerOpts{ErrorLog: scrapeErrorLog{}, EnableOpenMetrics: true})
+	mux.Handle(metricsPath, promhttp.InstrumentHandlerDuration(ScrapeDurationHistogram, metrics))
+	mux.Handle("/metadata", metadataHandler(g))
+	if ui {
+		mux.Handle("/ui", uiHandler(metricsPath))
+	}
+	return mux
+}
+
+// scrapeErrorLog counts and logs the errors promhttp hits serving metrics.
+type scrapeErrorLog struct{}
+
+func (scrapeErrorLog) Println(v ...any) {
+	ScrapeErrorsCounter.Inc()
+	slog.Error("Error serving metrics", "err", strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+// newDebugMux returns the handler for the -debug-listen-address listener,
+// serving the runtime profiling endpoints under /debug/pprof/.
+func newDebugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	handlePprof(mux)
+	return mux
+}
+
+// handlePprof registers the runtime profiling endpoints under /debug/pprof/
+// on mux. They are registered on mux alone, never on
+// http.DefaultServeMux.
+func handlePprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
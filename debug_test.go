@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestListenerRoutes(t *testing.T) {
+	c := qt.New(t)
+
+	metrics := httptest.NewServer(newMetricsMux(prometheus.DefaultGatherer, defaultMetricsPath, false))
+	defer metrics.Close()
+	debug := httptest.NewServer(newDebugMux())
+	defer debug.Close()
+
+	// -pprof adds the debug routes to the main listener
+	withPprof := newMetricsMux(prometheus.DefaultGatherer, defaultMetricsPath, false)
+	handlePprof(withPprof)
+	pprofMain := httptest.NewServer(withPprof)
+	defer pprofMain.Close()
+
+	tests := []struct {
+		name   string
+		server *httptest.Server
+		path   string
+		want   int
+	}{
+		{name: "MetricsOnMain", server: metrics, path: "/metrics", want: http.StatusOK},
+		{name: "MetadataOnMain", server: metrics, path: "/metadata", want: http.StatusOK},
+		{name: "PprofNotOnMain", server: metrics, path: "/debug/pprof/", want: http.StatusNotFound},
+		{name: "CmdlineNotOnMain", server: metrics, path: "/debug/pprof/cmdline", want: http.StatusNotFound},
+		{name: "PprofOnDebug", server: debug, path: "/debug/pprof/", want: http.StatusOK},
+		{name: "MetricsNotOnDebug", server: debug, path: "/metrics", want: http.StatusNotFound},
+		{name: "PprofOnMainWithFlag", server: pprofMain, path: "/debug/pprof/", want: http.StatusOK},
+		{name: "MetricsOnMainWithFlag", server: pprofMain, path: "/metrics", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			resp, err := http.Get(tt.server.URL + tt.path)
+			c.Assert(err, qt.IsNil)
+			resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, tt.want)
+		})
+	}
+}
+
+func TestMetricsMuxNegotiation(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	registerExporterMetrics(reg, defaultNamespace)
+	server := httptest.NewServer(newMetricsMux(reg, defaultMetricsPath, false))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		eof         bool
+	}{
+		{
+			name:        "OpenMetrics",
+			accept:      string(expfmt.NewFormat(expfmt.TypeOpenMetrics)),
+			contentType: "application/openmetrics-text; version=1.0.0; charset=utf-8.*",
+			eof:         true,
+		},
+		{
+			name:        "Text",
+			contentType: "text/plain; version=0.0.4; charset=utf-8.*",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+			c.Assert(err, qt.IsNil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			c.Assert(err, qt.IsNil)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			c.Assert(err, qt.IsNil)
+			c.Assert(resp.Header.Get("Content-Type"), qt.Matches, tt.contentType)
+			c.Assert(string(body), qt.Contains, "# TYPE intel_gpu_exporter_build_info gauge")
+			c.Assert(strings.HasSuffix(string(body), "# EOF\n"), qt.Equals, tt.eof)
+		})
+	}
+}
+
+func TestMetricsMuxPath(t *testing.T) {
+	c := qt.New(t)
+
+	reg := prometheus.NewRegistry()
+	registerExporterMetrics(reg, defaultNamespace)
+	server := httptest.NewServer(newMetricsMux(reg, "/gpu/metrics", true))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/gpu/metrics")
+	c.Assert(err, qt.IsNil)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(string(body), qt.Contains, "# TYPE intel_gpu_exporter_build_info gauge")
+
+	resp, err = http.Get(server.URL + "/metrics")
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusNotFound)
+
+	// The live chart page polls the metrics where they are
+	resp, err = http.Get(server.URL + "/ui")
+	c.Assert(err, qt.IsNil)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(body), qt.Contains, `fetch("gpu/metrics")`)
+}
+
+func TestMetricsMuxInstrumented(t *testing.T) {
+	c := qt.New(t)
+
+	reg := prometheus.NewRegistry()
+	registerExporterMetrics(reg, defaultNamespace)
+	server := httptest.NewServer(newMetricsMux(reg, defaultMetricsPath, false))
+	defer server.Close()
+
+	scrapes := func() uint64 {
+		families, err := reg.Gather()
+		c.Assert(err, qt.IsNil)
+		for _, family := range families {
+			if family.GetName() == "intel_gpu_exporter_scrape_duration_seconds" {
+				return family.GetMetric()[0].GetHistogram().GetSampleCount()
+			}
+		}
+		c.Fatal("scrape duration histogram not registered")
+		return 0
+	}
+	before := scrapes()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(scrapes()-before, qt.Equals, uint64(1))
+
+	// A gatherer failing mid-scrape is counted as well as timed
+	errors := testutil.ToFloat64(ScrapeErrorsCounter)
+	failing := prometheus.NewRegistry()
+	failing.MustRegister(brokenCollector{})
+	broken := httptest.NewServer(newMetricsMux(failing, defaultMetricsPath, false))
+	defer broken.Close()
+
+	resp, err = http.Get(broken.URL + "/metrics")
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusInternalServerError)
+	c.Assert(testutil.ToFloat64(ScrapeErrorsCounter)-errors, qt.Equals, 1.0)
+	c.Assert(scrapes()-before, qt.Equals, uint64(2))
+}
+
+// brokenCollector is a prometheus.Collector failing every collection.
+type brokenCollector struct{}
+
+var brokenDesc = prometheus.NewDesc("broken", "Never collected", nil, nil)
+
+func (brokenCollector) Describe(ch chan<- *prometheus.Desc) { ch <- brokenDesc }
+
+func (brokenCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.NewInvalidMetric(brokenDesc, errors.New("collector broke"))
+}
+
+func TestCheckMetricsPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{path: "/metrics"},
+		{path: "/gpu/metrics"},
+		{path: "metrics", wantErr: `invalid -metrics-path "metrics": must start with /`},
+		{path: "", wantErr: `invalid -metrics-path "": must start with /`},
+		{path: "/{name}", wantErr: `invalid -metrics-path "/{name}": must not contain spaces or braces`},
+		{path: "/", wantErr: `invalid -metrics-path "/": already served by the exporter`},
+		{path: "/healthz", wantErr: `invalid -metrics-path "/healthz": already served by the exporter`},
+		{path: "/debug/pprof/", wantErr: `invalid -metrics-path "/debug/pprof/": already served by the exporter`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			c := qt.New(t)
+
+			err := checkMetricsPath(tt.path)
+			if tt.wantErr == "" {
+				c.Assert(err, qt.IsNil)
+				return
+			}
+			c.Assert(err, qt.ErrorMatches, regexp.QuoteMeta(tt.wantErr))
+		})
+	}
+}
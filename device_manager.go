@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// deviceCollector collects the samples of one GPU.
+type deviceCollector struct {
+	runner gpuTopRunner
+	opts   collectOptions
+}
+
+// deviceManager runs a collector per GPU, each with its own intel_gpu_top,
+// all publishing to a shared sink.
+type deviceManager struct {
+	collectors []deviceCollector
+}
+
+// Run collects from every device until ctx is cancelled. A collector giving
+// up cancels ctx, stopping the others too.
+func (m *deviceManager) Run(ctx context.Context, cancel context.CancelFunc, sink MetricsSink) {
+	var wg sync.WaitGroup
+	for _, c := range m.collectors {
+		wg.Go(func() {
+			runGPUTop(ctx, cancel, c.runner, sink, c.opts)
+		})
+	}
+	wg.Wait()
+}
+
+// health returns the health trackers of every collector.
+func (m *deviceManager) health() []*collectorHealth {
+	var health []*collectorHealth
+	for _, c := range m.collectors {
+		health = append(health, c.opts.health)
+	}
+	return health
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestDeviceManagerLabels(t *testing.T) {
+	c := qt.New(t)
+
+	col := newCollector(collectorOptions{namespace: defaultNamespace})
+
+	const (
+		igpu = "pci:slot=0000:00:02.0"
+		arc  = "pci:slot=0000:03:00.0"
+	)
+	outputs := map[string]string{
+		igpu: "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa\n1200.0,1150.0,500.0,10.0,80.0,0.0,0.0\n",
+		arc:  "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,CCS %,CCS se,CCS wa\n2050.0,2000.0,1830.0,12.3,64.0,0.0,1.5,20.0,0.2,0.0\n",
+	}
+
+	opts := collectOptions{interval: time.Second}
+	manager := &deviceManager{}
+	var writers []*io.PipeWriter
+	for _, device := range []string{igpu, arc} {
+		runner, w := newStreamingRunner()
+		writers = append(writers, w)
+		manager.collectors = append(manager.collectors, deviceCollector{
+			runner: runner,
+			opts:   opts.forDevice(device),
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		manager.Run(ctx, cancel, prometheusSink{collector: col})
+		close(done)
+	}()
+
+	for i, device := range []string{igpu, arc} {
+		_, err := io.WriteString(writers[i], outputs[device])
+		c.Assert(err, qt.IsNil)
+	}
+
+	// Wait for both devices to publish
+	deadline := time.Now().Add(5 * time.Second)
+	for len(collected(c, col, "intel_gpu_freq_mhz_actual")) < 2 {
+		if time.Now().After(deadline) {
+			c.Fatal("devices never published")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	freq := collected(c, col, "intel_gpu_freq_mhz_actual")
+	c.Assert(freq[`device="`+igpu+`"`], qt.Equals, 1150.0)
+	c.Assert(freq[`device="`+arc+`"`], qt.Equals, 2000.0)
+	c.Assert(collected(c, col, "intel_gpu_busy_percent")[`device="`+arc+`"`], qt.Equals, 64.0)
+
+	// Engines are kept apart by device, with CCS only on the Arc card
+	engines := collected(c, col, "intel_gpu_engine_percent")
+	c.Assert(engines[`device="`+igpu+`",engine="RCS",type="busy"`], qt.Equals, 80.0)
+	c.Assert(engines[`device="`+arc+`",engine="RCS",type="busy"`], qt.Equals, 64.0)
+	c.Assert(engines[`device="`+arc+`",engine="CCS",type="busy"`], qt.Equals, 20.0)
+	c.Assert(engines, qt.HasLen, 9)
+}
+
+func TestCollectOptionsForDevice(t *testing.T) {
+	c := qt.New(t)
+
+	opts := collectOptions{
+		interval: time.Second,
+		idle:     &idleDetector{threshold: 1, after: time.Minute, interval: 10 * time.Second},
+		backoff:  &restartBackoff{min: time.Second, max: time.Minute},
+	}
+	a := opts.forDevice("card0")
+	b := opts.forDevice("card1")
+
+	c.Assert(a.device, qt.Equals, "card0")
+	c.Assert(b.device, qt.Equals, "card1")
+
+	// Settings carry over, state does not
+	c.Assert(a.idle.interval, qt.Equals, 10*time.Second)
+	c.Assert(a.idle == b.idle, qt.IsFalse)
+	c.Assert(a.backoff == b.backoff, qt.IsFalse)
+	c.Assert(a.health == b.health, qt.IsFalse)
+	c.Assert(a.health, qt.Not(qt.IsNil))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// dumpMetrics writes the current exposition of g to path. The file is
+// written next to path and renamed into place so readers never see a
+// partial dump.
+func dumpMetrics(g prometheus.Gatherer, path string) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(tmp, mf); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// handleDumpSignal dumps the metrics in g to path each time SIGUSR1 is
+// received, until ctx is cancelled. Dumping is a side channel, so the
+// exporter carries on without it where SIGUSR1 doesn't exist.
+func handleDumpSignal(ctx context.Context, g prometheus.Gatherer, path string) {
+	sigs := make(chan os.Signal, 1)
+	if err := notifyDump(sigs); err != nil {
+		slog.Warn("Unable to watch for SIGUSR1, not dumping metrics", "err", err)
+		return
+	}
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigs:
+			if err := dumpMetrics(g, path); err != nil {
+				slog.Error("Error dumping metrics", "path", path, "err", err)
+			} else {
+				slog.Info("Dumped metrics", "path", path)
+			}
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// notifyDump reports that SIGUSR1 isn't available on this platform.
+func notifyDump(c chan<- os.Signal) error {
+	return errors.New("SIGUSR1 is not supported on this platform")
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDumpMetrics(t *testing.T) {
+	c := qt.New(t)
+
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "intel_gpu_freq_mhz_actual",
+		Help: "Intel GPU actual frequency in MHz",
+	})
+	reg.MustRegister(gauge)
+	gauge.Set(1150)
+
+	dir := c.TempDir()
+	path := filepath.Join(dir, "metrics.prom")
+	c.Assert(os.WriteFile(path, []byte("stale"), 0o644), qt.IsNil)
+
+	c.Assert(dumpMetrics(reg, path), qt.IsNil)
+
+	content, err := os.ReadFile(path)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(content), qt.Equals, `# HELP intel_gpu_freq_mhz_actual Intel GPU actual frequency in MHz
+# TYPE intel_gpu_freq_mhz_actual gauge
+intel_gpu_freq_mhz_actual 1150
+`)
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 1)
+}
+
+func TestDumpMetricsMissingDir(t *testing.T) {
+	c := qt.New(t)
+
+	err := dumpMetrics(prometheus.NewRegistry(), filepath.Join(c.TempDir(), "missing", "metrics.prom"))
+	c.Assert(err, qt.ErrorMatches, ".*no such file or directory")
+}
@@ -0,0 +1,15 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyDump relays SIGUSR1 to c.
+func notifyDump(c chan<- os.Signal) error {
+	signal.Notify(c, syscall.SIGUSR1)
+	return nil
+}
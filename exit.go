@@ -0,0 +1,31 @@
+package main
+
+import "errors"
+
+// Classes of startup failure run wraps its errors in, each exiting with its
+// own code so supervisors and scripts can tell them apart.
+var (
+	errConfig = errors.New("invalid configuration")
+	errListen = errors.New("unable to serve HTTP")
+	errTLS    = errors.New("invalid TLS configuration")
+)
+
+// exitCode returns the process exit code for an error returned by run.
+// Unclassified errors exit with 1. Code 3 was used for a missing
+// intel_gpu_top, which no longer stops the exporter.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errConfig):
+		return 2
+	case errors.Is(err, errListen):
+		return 4
+	case errors.Is(err, errTLS):
+		return 5
+	case errors.Is(err, errAlreadyRunning):
+		return 6
+	default:
+		return 1
+	}
+}
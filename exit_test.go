@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestRunStartupErrors(t *testing.T) {
+	c := qt.New(t)
+
+	limits := sampleLimits
+	c.Cleanup(func() { sampleLimits = limits })
+
+	// Hold a port so the exporter can't bind it
+	busy, err := net.Listen("tcp", ":0")
+	c.Assert(err, qt.IsNil)
+	defer busy.Close()
+	busyPort := busy.Addr().(*net.TCPAddr).Port
+
+	missing := filepath.Join(c.TempDir(), "missing.pem")
+
+	notExecutable := filepath.Join(c.TempDir(), "intel_gpu_top")
+	c.Assert(os.WriteFile(notExecutable, nil, 0o644), qt.IsNil)
+
+	// Hold the instance lock as if another exporter were running
+	lockFile := filepath.Join(c.TempDir(), "exporter.lock")
+	lock, err := acquireInstanceLock(lockFile)
+	c.Assert(err, qt.IsNil)
+	defer lock.Release()
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantErr  error
+		wantMsg  string
+		wantCode int
+	}{
+		{
+			name:     "UnknownFlag",
+			args:     []string{"-no-such-flag"},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: flag provided but not defined: -no-such-flag",
+			wantCode: 2,
+		},
+		{
+			name:     "BadPort",
+			args:     []string{"-port=0"},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: invalid port number 0",
+			wantCode: 2,
+		},
+		{
+			name:     "BadListen",
+			args:     []string{"-listen=127.0.0.1"},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: invalid listen address: address 127.0.0.1: missing port in address",
+			wantCode: 2,
+		},
+		{
+			name:     "AuthUserWithoutPass",
+			args:     []string{"-auth-user=prometheus"},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: -auth-user and -auth-pass must be set together",
+			wantCode: 2,
+		},
+		{
+			name:     "BadInterval",
+			args:     []string{"-interval=0s"},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: invalid interval 0s",
+			wantCode: 2,
+		},
+		{
+			name:     "BadSource",
+			args:     []string{"-source=pipe"},
+			wantErr:  errConfig,
+			wantMsg:  `invalid configuration: invalid source "pipe"`,
+			wantCode: 2,
+		},
+		{
+			name:     "SocketWithPort",
+			args:     []string{"-unix-socket=/run/exporter.sock", "-port=9102"},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: -unix-socket can't be combined with -listen or -port",
+			wantCode: 2,
+		},
+		{
+			name:     "BadNamespace",
+			args:     []string{"-namespace=0gpu"},
+			wantErr:  errConfig,
+			wantMsg:  `invalid configuration: invalid -namespace "0gpu": must be letters, digits and underscores, not starting with a digit`,
+			wantCode: 2,
+		},
+		{
+			name:     "BadMetricsPath",
+			args:     []string{"-metrics-path=metrics"},
+			wantErr:  errConfig,
+			wantMsg:  `invalid configuration: invalid -metrics-path "metrics": must start with /`,
+			wantCode: 2,
+		},
+		{
+			name:     "BadScrapeAggregate",
+			args:     []string{"-scrape-aggregate=median"},
+			wantErr:  errConfig,
+			wantMsg:  `invalid configuration: invalid -scrape-aggregate "median": must be latest, mean or max`,
+			wantCode: 2,
+		},
+		{
+			name:     "ClientsWithCSV",
+			args:     []string{"-enable-clients"},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: -enable-clients needs -format=json",
+			wantCode: 2,
+		},
+		{
+			name:     "BadAggregate",
+			args:     []string{"-aggregate=window=-5s"},
+			wantErr:  errConfig,
+			wantMsg:  `invalid configuration: invalid -aggregate window "-5s": must be a positive duration`,
+			wantCode: 2,
+		},
+		{
+			name:     "DevicePathWithoutDRI",
+			args:     []string{"-device-path=" + filepath.Join(c.TempDir(), "dri", "card0")},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: -device-path: .*/dri is not accessible, pass the GPU into the container.*",
+			wantCode: 2,
+		},
+		{
+			name:     "DevicesWithStdin",
+			args:     []string{"-source=stdin", "-device=card0", "-device=card1"},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: -source=stdin reads a single device",
+			wantCode: 2,
+		},
+		{
+			name:     "DevicesWithSingleDeviceFlags",
+			args:     []string{"-device=card0", "-device=card1", "-aggregate-window=1m", "-syslog"},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: -aggregate-window, -syslog can't be combined with several -device flags",
+			wantCode: 2,
+		},
+		{
+			name:     "BadFormat",
+			args:     []string{"-format=xml"},
+			wantErr:  errConfig,
+			wantMsg:  `invalid configuration: invalid format "xml"`,
+			wantCode: 2,
+		},
+		{
+			name:     "InputWithSource",
+			args:     []string{"-source=stdin", "-input=testdata/intel_gpu_top_imc.csv"},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: -input can't be combined with -source",
+			wantCode: 2,
+		},
+		{
+			name:     "MissingInput",
+			args:     []string{"-input=" + missing},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: input: stat .*/missing.pem: no such file or directory",
+			wantCode: 2,
+		},
+		{
+			name:     "BadReadTimeout",
+			args:     []string{"-read-timeout=-1s"},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: invalid read-timeout -1s",
+			wantCode: 2,
+		},
+		{
+			name:     "BadTermTimeout",
+			args:     []string{"-term-timeout=-1s"},
+			wantErr:  errConfig,
+			wantMsg:  "invalid configuration: invalid term-timeout -1s",
+			wantCode: 2,
+		},
+		{
+			name:     "BadLogFormat",
+			args:     []string{"-log-format=logfmt"},
+			wantErr:  errConfig,
+			wantMsg:  `invalid configuration: invalid -log-format "logfmt": must be text or json`,
+			wantCode: 2,
+		},
+		{
+			name:     "BadLogLevel",
+			args:     []string{"-log-level=verbose"},
+			wantErr:  errConfig,
+			wantMsg:  `invalid configuration: invalid -log-level "verbose": must be debug, info, warn or error`,
+			wantCode: 2,
+		},
+		{
+			name:     "PortInUse",
+			args:     []string{"-source=stdin", fmt.Sprintf("-port=%d", busyPort)},
+			wantErr:  errListen,
+			wantMsg:  "unable to serve HTTP: listen tcp .*: address already in use",
+			wantCode: 4,
+		},
+		{
+			name:     "SocketNotASocket",
+			args:     []string{"-source=stdin", "-unix-socket=" + notExecutable},
+			wantErr:  errListen,
+			wantMsg:  "unable to serve HTTP: .*/intel_gpu_top exists and is not a socket",
+			wantCode: 4,
+		},
+		{
+			name:     "CertWithoutKey",
+			args:     []string{"-source=stdin", "-tls-cert=" + missing},
+			wantErr:  errTLS,
+			wantMsg:  "invalid TLS configuration: -tls-cert and -tls-key must be set together",
+			wantCode: 5,
+		},
+		{
+			name:     "MissingCert",
+			args:     []string{"-source=stdin", "-tls-cert=" + missing, "-tls-key=" + missing},
+			wantErr:  errTLS,
+			wantMsg:  "invalid TLS configuration: .*missing.pem: no such file or directory",
+			wantCode: 5,
+		},
+		{
+			name:     "AlreadyRunning",
+			args:     []string{"-single-instance", "-lock-file=" + lockFile},
+			wantErr:  errAlreadyRunning,
+			wantMsg:  "another exporter instance is already running: .*",
+			wantCode: 6,
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			err := run(tt.args)
+			c.Assert(err, qt.ErrorIs, tt.wantErr)
+			c.Assert(err, qt.ErrorMatches, tt.wantMsg)
+			c.Assert(exitCode(err), qt.Equals, tt.wantCode)
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(exitCode(nil), qt.Equals, 0)
+	c.Assert(exitCode(fmt.Errorf("unexpected")), qt.Equals, 1)
+	c.Assert(exitCode(fmt.Errorf("wrapped: %w", fmt.Errorf("%w: bad", errConfig))), qt.Equals, 2)
+}
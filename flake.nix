@@ -30,8 +30,10 @@
         ldflags = [
           "-s"
           "-w"
+          "-X main.version=${self.shortRev or "dev"}"
+          "-X main.commit=${self.rev or ""}"
         ];
-        vendorHash = "sha256-s6wRiGWbzwDHgtPuQjUpdvt/Hk/f0KpcMpBiFvrre+Q="; # SHA based on vendoring go.mod
+        vendorHash = "sha256-OazkNuwE79NxfSApxKQaHkW2EXbDfhIKR39IVQI81dY="; # SHA based on vendoring go.mod
 
         # Rename the binary from intel-gpu-exporter-go to intel-gpu-exporter
         postInstall = ''
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// FreqResidencyCounter accumulates time spent with the actual frequency in
+// each band configured by -freq-bins.
+var FreqResidencyCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "freq_residency_seconds_total",
+	Help: "Time in seconds the Intel GPU actual frequency spent in each frequency band",
+}, []string{"bin"})
+
+// freqBins are ascending band boundaries in MHz. Each band includes its
+// lower boundary, the first starts at 0 and the last is unbounded.
+type freqBins []float64
+
+// parseFreqBins parses comma separated, strictly ascending, positive
+// boundaries in MHz, e.g. "300,600,900".
+func parseFreqBins(s string) (freqBins, error) {
+	var bins freqBins
+	for field := range strings.SplitSeq(s, ",") {
+		boundary, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || boundary <= 0 {
+			return nil, fmt.Errorf("invalid boundary %q: must be a positive number", field)
+		}
+		if len(bins) > 0 && boundary <= bins[len(bins)-1] {
+			return nil, fmt.Errorf("invalid boundary %q: boundaries must be ascending", field)
+		}
+		bins = append(bins, boundary)
+	}
+	return bins, nil
+}
+
+// Labels returns the label of every band in order, e.g. "0-300",
+// "300-600" and "600+".
+func (b freqBins) Labels() []string {
+	labels := make([]string, 0, len(b)+1)
+	lower := 0.0
+	for _, upper := range b {
+		labels = append(labels, formatMhz(lower)+"-"+formatMhz(upper))
+		lower = upper
+	}
+	return append(labels, formatMhz(lower)+"+")
+}
+
+// Label returns the label of the band freq falls in.
+func (b freqBins) Label(freq float64) string {
+	i, found := slices.BinarySearch(b, freq)
+	if found {
+		// freq is the lower boundary of the next band
+		i++
+	}
+	return b.Labels()[i]
+}
+
+// Observe credits interval, the time the sample stats covers, to the band
+// of its actual frequency.
+func (b freqBins) Observe(stats IntelTopStats, interval time.Duration) {
+	FreqResidencyCounter.WithLabelValues(b.Label(stats.FreqMhzActual)).Add(interval.Seconds())
+}
+
+// formatMhz formats a boundary without a trailing ".0".
+func formatMhz(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseFreqBins(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    freqBins
+		wantErr string
+	}{
+		{name: "Valid", input: "300, 600,900.5", want: freqBins{300, 600, 900.5}},
+		{name: "Single", input: "1000", want: freqBins{1000}},
+		{name: "NotANumber", input: "300,x", wantErr: `invalid boundary "x": must be a positive number`},
+		{name: "Zero", input: "0,300", wantErr: `invalid boundary "0": must be a positive number`},
+		{name: "Descending", input: "600,300", wantErr: `invalid boundary "300": boundaries must be ascending`},
+		{name: "Repeated", input: "300,300", wantErr: `invalid boundary "300": boundaries must be ascending`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+			bins, err := parseFreqBins(tt.input)
+			if tt.wantErr != "" {
+				c.Assert(err, qt.ErrorMatches, tt.wantErr)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(bins, qt.DeepEquals, tt.want)
+		})
+	}
+}
+
+func TestFreqBinsLabel(t *testing.T) {
+	c := qt.New(t)
+	bins := freqBins{300, 600, 900.5}
+
+	c.Assert(bins.Labels(), qt.DeepEquals, []string{"0-300", "300-600", "600-900.5", "900.5+"})
+
+	tests := []struct {
+		freq float64
+		want string
+	}{
+		{freq: 0, want: "0-300"},
+		{freq: 299.9, want: "0-300"},
+		{freq: 300, want: "300-600"},
+		{freq: 450, want: "300-600"},
+		{freq: 900.5, want: "900.5+"},
+		{freq: 2400, want: "900.5+"},
+	}
+	for _, tt := range tests {
+		c.Check(bins.Label(tt.freq), qt.Equals, tt.want, qt.Commentf("freq %v", tt.freq))
+	}
+}
+
+func TestFreqBinsObserve(t *testing.T) {
+	c := qt.New(t)
+	c.Cleanup(FreqResidencyCounter.Reset)
+	bins := freqBins{300, 600}
+
+	bins.Observe(IntelTopStats{FreqMhzActual: 450}, time.Second)
+	bins.Observe(IntelTopStats{FreqMhzActual: 500}, 500*time.Millisecond)
+	bins.Observe(IntelTopStats{FreqMhzActual: 1200}, time.Second)
+
+	c.Assert(testutil.ToFloat64(FreqResidencyCounter.WithLabelValues("0-300")), qt.Equals, 0.0)
+	c.Assert(testutil.ToFloat64(FreqResidencyCounter.WithLabelValues("300-600")), qt.Equals, 1.5)
+	c.Assert(testutil.ToFloat64(FreqResidencyCounter.WithLabelValues("600+")), qt.Equals, 1.0)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// drmSysfsPath is where the kernel exposes DRM devices.
+const drmSysfsPath = "/sys/class/drm"
+
+// integratedGPUSlot is the PCI address Intel integrated GPUs always occupy.
+// Anything else is a discrete card such as Arc.
+const integratedGPUSlot = "0000:00:02.0"
+
+// intelVendorID is the PCI vendor ID reported in sysfs for Intel devices.
+const intelVendorID = "0x8086"
+
+// listDevicesTimeout bounds intel_gpu_top -L at startup.
+const listDevicesTimeout = 5 * time.Second
+
+var (
+	IsDiscreteGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "is_discrete",
+		Help: "Whether the Intel GPU is a discrete card (1) or integrated (0)",
+	}, []string{deviceLabel})
+	DeviceInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "device_info",
+		Help: "Intel GPUs listed by intel_gpu_top, labelled by their stable PCI address",
+	}, []string{deviceLabel, "card", "name"})
+)
+
+// gpuDevice is a GPU listed by intel_gpu_top -L.
+type gpuDevice struct {
+	card string
+	name string
+	// filter is the intel_gpu_top -d filter selecting the device.
+	filter string
+	// pciAddress is the device's PCI slot, e.g. 0000:03:00.0. Unlike the
+	// card number it is stable across reboots.
+	pciAddress string
+}
+
+// detectDiscrete reports whether each Intel GPU found under root (a
+// /sys/class/drm style directory) is a discrete card, keyed by PCI address.
+// An error is returned when no Intel GPU can be found or a PCI address
+// cannot be resolved.
+func detectDiscrete(root string) (map[string]bool, error) {
+	cards, err := findIntelCards(root)
+	if err != nil {
+		return nil, err
+	}
+
+	discrete := make(map[string]bool, len(cards))
+	for _, card := range cards {
+		address, err := resolvePCIAddress(root, filepath.Base(card))
+		if err != nil {
+			return nil, err
+		}
+		discrete[address] = address != integratedGPUSlot
+	}
+	return discrete, nil
+}
+
+// findIntelCard returns the directory of the first Intel GPU under root.
+func findIntelCard(root string) (string, error) {
+	cards, err := findIntelCards(root)
+	if err != nil {
+		return "", err
+	}
+	return cards[0], nil
+}
+
+// findIntelCards returns the directories of the Intel GPUs under root, or an
+// error when there are none.
+func findIntelCards(root string) ([]string, error) {
+	cards, err := filepath.Glob(filepath.Join(root, "card[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var intel []string
+	for _, card := range cards {
+		// Skip connectors such as card0-HDMI-A-1
+		if strings.Contains(filepath.Base(card), "-") {
+			continue
+		}
+
+		vendor, err := os.ReadFile(filepath.Join(card, "device", "vendor"))
+		if err != nil || strings.TrimSpace(string(vendor)) != intelVendorID {
+			continue
+		}
+
+		intel = append(intel, card)
+	}
+
+	if len(intel) == 0 {
+		return nil, errors.New("no Intel GPU found in " + root)
+	}
+	return intel, nil
+}
+
+// engineClassOrder is the order intel_gpu_top lists engine classes in.
+var engineClassOrder = []string{"RCS", "BCS", "VCS", "VECS", "CCS"}
+
+// detectEngines returns the engine classes, e.g. RCS or VCS, of the first
+// Intel GPU under root from its sysfs engine listing, in the order
+// intel_gpu_top prints them.
+func detectEngines(root string) ([]string, error) {
+	card, err := findIntelCard(root)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(card, "engine"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries are instances such as vcs0 and vcs1; keep their class
+	present := make(map[string]bool)
+	for _, e := range entries {
+		class := strings.ToUpper(strings.TrimRight(e.Name(), "0123456789"))
+		if class != "" {
+			present[class] = true
+		}
+	}
+	if len(present) == 0 {
+		return nil, errors.New("no engines listed for " + card)
+	}
+
+	var engines []string
+	for _, class := range engineClassOrder {
+		if present[class] {
+			engines = append(engines, class)
+			delete(present, class)
+		}
+	}
+	return append(engines, slices.Sorted(maps.Keys(present))...), nil
+}
+
+// registerGPUInfo registers IsDiscreteGauge on reg, labelled with the PCI
+// address of each GPU, when the GPU types can be determined and omits it
+// otherwise.
+func registerGPUInfo(reg prometheus.Registerer, root string) error {
+	discrete, err := detectDiscrete(root)
+	if err != nil {
+		return err
+	}
+
+	IsDiscreteGauge.Reset()
+	for address, d := range discrete {
+		value := 0.0
+		if d {
+			value = 1
+		}
+		IsDiscreteGauge.WithLabelValues(address).Set(value)
+	}
+	reg.MustRegister(IsDiscreteGauge)
+
+	return nil
+}
+
+// parseDeviceList parses intel_gpu_top -L output. Each device is a line
+// starting with its card name followed by a description and a device
+// filter, for example:
+//
+//	card0                    Intel Alderlake_p (Gen12)         pci:vendor=8086,device=46A6,card=0
+//	└─renderD128
+func parseDeviceList(output string) []gpuDevice {
+	var devices []gpuDevice
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "card") {
+			continue
+		}
+
+		// The last field is the device filter, everything between is the name
+		var name string
+		if len(fields) > 2 {
+			name = strings.Join(fields[1:len(fields)-1], " ")
+		}
+		devices = append(devices, gpuDevice{card: fields[0], name: name, filter: fields[len(fields)-1]})
+	}
+
+	return devices
+}
+
+// resolvePCIAddress returns the PCI address of card from root, a
+// /sys/class/drm style directory.
+func resolvePCIAddress(root, card string) (string, error) {
+	device, err := filepath.EvalSymlinks(filepath.Join(root, card, "device"))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(device), nil
+}
+
+// listDevices runs binary -L and resolves each listed device's PCI address
+// from root. Devices whose address can't be resolved fall back to their
+// card name.
+func listDevices(ctx context.Context, binary, root string) ([]gpuDevice, error) {
+	ctx, cancel := context.WithTimeout(ctx, listDevicesTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, binary, "-L").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := parseDeviceList(string(output))
+	for i, d := range devices {
+		if devices[i].pciAddress, err = resolvePCIAddress(root, d.card); err != nil {
+			devices[i].pciAddress = d.card
+		}
+	}
+
+	return devices, nil
+}
+
+// pciSlotPattern matches a PCI address, with or without its domain.
+var pciSlotPattern = regexp.MustCompile(`^(?:[0-9a-fA-F]{4}:)?[0-9a-fA-F]{2}:[0-9a-fA-F]{2}\.[0-7]$`)
+
+// normalizePCISlot returns slot, a PCI address, lower case and with its
+// domain, as sysfs names devices.
+func normalizePCISlot(slot string) (string, error) {
+	if !pciSlotPattern.MatchString(slot) {
+		return "", fmt.Errorf("invalid PCI address %q", slot)
+	}
+	if strings.Count(slot, ":") == 1 {
+		slot = "0000:" + slot
+	}
+	return strings.ToLower(slot), nil
+}
+
+// devicePCIAddress returns the PCI address of the GPU intel_gpu_top selects
+// with filter, a -d device filter. Filters listed by intel_gpu_top -L are
+// looked up in devices, and DRM device nodes resolved from root, a
+// /sys/class/drm style directory.
+func devicePCIAddress(filter string, devices []gpuDevice, root string) (string, error) {
+	for _, d := range devices {
+		if d.filter == filter {
+			return d.pciAddress, nil
+		}
+	}
+
+	kind, spec, _ := strings.Cut(filter, ":")
+	switch kind {
+	case "pci":
+		for setting := range strings.SplitSeq(spec, ",") {
+			if key, value, _ := strings.Cut(setting, "="); key == "slot" {
+				return normalizePCISlot(value)
+			}
+		}
+	case "drm":
+		// The node may be a link such as /dev/dri/by-path/pci-...-card
+		node, err := filepath.EvalSymlinks(spec)
+		if err != nil {
+			node = spec
+		}
+		return resolvePCIAddress(root, filepath.Base(node))
+	case "sys":
+		return normalizePCISlot(filepath.Base(spec))
+	}
+	return "", fmt.Errorf("no PCI address in device filter %q", filter)
+}
+
+// deviceLabelValue returns the device label of the GPU selected by filter:
+// its PCI address, which unlike card numbers survives reboots and matches
+// DeviceInfo, or filter itself when that can't be resolved. Without a filter
+// the label is empty.
+func deviceLabelValue(filter string, devices []gpuDevice, root string) string {
+	if filter == "" {
+		return ""
+	}
+	address, err := devicePCIAddress(filter, devices, root)
+	if err != nil {
+		slog.Warn("Unable to resolve the PCI address of a GPU, labelling its series by its device filter", "device", filter, "err", err)
+		return filter
+	}
+	return address
+}
+
+// deviceFilterForPath returns the intel_gpu_top -d filter of the DRM device
+// node at path, e.g. drm:/dev/dri/card0, checking the node exists first.
+func deviceFilterForPath(path string) (string, error) {
+	if _, err := os.Stat(path); err != nil {
+		// A container started without the GPU passed through has no
+		// /dev/dri at all, the usual cause, so blame that over the node
+		dir := filepath.Dir(path)
+		if _, dirErr := os.Stat(dir); dirErr != nil {
+			return "", fmt.Errorf("%s is not accessible, pass the GPU into the container, e.g. docker run --device /dev/dri: %w", dir, dirErr)
+		}
+		return "", err
+	}
+	return "drm:" + path, nil
+}
+
+// setDeviceInfo publishes DeviceInfo for devices.
+func setDeviceInfo(devices []gpuDevice) {
+	DeviceInfo.Reset()
+	for _, d := range devices {
+		DeviceInfo.WithLabelValues(d.pciAddress, d.card, d.name).Set(1)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// gpuDeviceEquals compares values containing the unexported gpuDevice.
+var gpuDeviceEquals = qt.CmpEquals(cmp.AllowUnexported(gpuDevice{}))
+
+// fakeDRMCard creates a card entry under root whose device symlink points at
+// a PCI device directory named slot with the given vendor ID.
+func fakeDRMCard(c *qt.C, root, card, slot, vendor string) {
+	device := filepath.Join(root, "devices", slot)
+	c.Assert(os.MkdirAll(device, 0o755), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(device, "vendor"), []byte(vendor+"\n"), 0o644), qt.IsNil)
+
+	c.Assert(os.MkdirAll(filepath.Join(root, card), 0o755), qt.IsNil)
+	c.Assert(os.Symlink(device, filepath.Join(root, card, "device")), qt.IsNil)
+}
+
+func TestDetectDiscrete(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name      string
+		setup     func(c *qt.C, root string)
+		expected  map[string]bool
+		expectErr bool
+	}{
+		{
+			name: "Integrated",
+			setup: func(c *qt.C, root string) {
+				fakeDRMCard(c, root, "card0", "0000:00:02.0", "0x8086")
+			},
+			expected: map[string]bool{"0000:00:02.0": false},
+		},
+		{
+			name: "Discrete",
+			setup: func(c *qt.C, root string) {
+				fakeDRMCard(c, root, "card1", "0000:03:00.0", "0x8086")
+			},
+			expected: map[string]bool{"0000:03:00.0": true},
+		},
+		{
+			name: "Both",
+			setup: func(c *qt.C, root string) {
+				fakeDRMCard(c, root, "card0", "0000:00:02.0", "0x8086")
+				fakeDRMCard(c, root, "card1", "0000:03:00.0", "0x8086")
+			},
+			expected: map[string]bool{"0000:00:02.0": false, "0000:03:00.0": true},
+		},
+		{
+			name: "SkipsOtherVendors",
+			setup: func(c *qt.C, root string) {
+				fakeDRMCard(c, root, "card0", "0000:01:00.0", "0x10de")
+				fakeDRMCard(c, root, "card1", "0000:00:02.0", "0x8086")
+			},
+			expected: map[string]bool{"0000:00:02.0": false},
+		},
+		{
+			name: "SkipsConnectors",
+			setup: func(c *qt.C, root string) {
+				c.Assert(os.MkdirAll(filepath.Join(root, "card0-HDMI-A-1"), 0o755), qt.IsNil)
+				fakeDRMCard(c, root, "card0", "0000:03:00.0", "0x8086")
+			},
+			expected: map[string]bool{"0000:03:00.0": true},
+		},
+		{
+			name:      "NoIntelGPU",
+			setup:     func(c *qt.C, root string) {},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			root := c.TempDir()
+			tt.setup(c, root)
+
+			discrete, err := detectDiscrete(root)
+			if tt.expectErr {
+				c.Assert(err, qt.ErrorMatches, "no Intel GPU found in .*")
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(discrete, qt.DeepEquals, tt.expected)
+		})
+	}
+}
+
+func TestDetectEngines(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name      string
+		engines   []string
+		expected  []string
+		expectErr string
+	}{
+		{
+			name:     "IntegratedWithoutVECS",
+			engines:  []string{"vcs0", "rcs0", "bcs0"},
+			expected: []string{"RCS", "BCS", "VCS"},
+		},
+		{
+			name:     "DiscreteWithInstances",
+			engines:  []string{"bcs0", "ccs0", "ccs1", "rcs0", "vcs0", "vcs1", "vecs0", "vecs1"},
+			expected: []string{"RCS", "BCS", "VCS", "VECS", "CCS"},
+		},
+		{
+			name:     "UnknownClass",
+			engines:  []string{"rcs0", "xyz0"},
+			expected: []string{"RCS", "XYZ"},
+		},
+		{
+			name:      "NoEngines",
+			engines:   []string{},
+			expectErr: "no engines listed for .*",
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			root := c.TempDir()
+			fakeDRMCard(c, root, "card0", "0000:00:02.0", "0x8086")
+			for _, e := range tt.engines {
+				c.Assert(os.MkdirAll(filepath.Join(root, "card0", "engine", e), 0o755), qt.IsNil)
+			}
+			c.Assert(os.MkdirAll(filepath.Join(root, "card0", "engine"), 0o755), qt.IsNil)
+
+			engines, err := detectEngines(root)
+			if tt.expectErr != "" {
+				c.Assert(err, qt.ErrorMatches, tt.expectErr)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(engines, qt.DeepEquals, tt.expected)
+		})
+	}
+}
+
+func TestParseDeviceList(t *testing.T) {
+	c := qt.New(t)
+
+	output := `card1                    Intel Dg2 (Gen12)                 pci:vendor=8086,device=56A0,card=1
+└─renderD129
+card0                    Intel Alderlake_p (Gen12)         pci:vendor=8086,device=46A6,card=0
+└─renderD128
+card2                    8086:3E92                         drm:/dev/dri/card2
+`
+
+	c.Assert(parseDeviceList(output), gpuDeviceEquals, []gpuDevice{
+		{card: "card1", name: "Intel Dg2 (Gen12)", filter: "pci:vendor=8086,device=56A0,card=1"},
+		{card: "card0", name: "Intel Alderlake_p (Gen12)", filter: "pci:vendor=8086,device=46A6,card=0"},
+		{card: "card2", name: "8086:3E92", filter: "drm:/dev/dri/card2"},
+	})
+	c.Assert(parseDeviceList("No GPU devices found\n"), qt.HasLen, 0)
+}
+
+func TestDevicePCIAddress(t *testing.T) {
+	c := qt.New(t)
+
+	root := c.TempDir()
+	fakeDRMCard(c, root, "card0", "0000:00:02.0", "0x8086")
+	fakeDRMCard(c, root, "card1", "0000:03:00.0", "0x8086")
+	devices := []gpuDevice{
+		{card: "card1", filter: "pci:vendor=8086,device=56A0,card=0", pciAddress: "0000:03:00.0"},
+	}
+
+	// A link such as /dev/dri/by-path/pci-0000:03:00.0-card resolves to its
+	// node
+	dri := c.TempDir()
+	c.Assert(os.WriteFile(filepath.Join(dri, "card1"), nil, 0o644), qt.IsNil)
+	link := filepath.Join(dri, "pci-0000:03:00.0-card")
+	c.Assert(os.Symlink(filepath.Join(dri, "card1"), link), qt.IsNil)
+
+	tests := []struct {
+		name    string
+		filter  string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "Listed",
+			filter: "pci:vendor=8086,device=56A0,card=0",
+			want:   "0000:03:00.0",
+		},
+		{
+			name:   "Slot",
+			filter: "pci:vendor=8086,slot=03:00.0",
+			want:   "0000:03:00.0",
+		},
+		{
+			name:   "DRMNode",
+			filter: "drm:/dev/dri/card0",
+			want:   "0000:00:02.0",
+		},
+		{
+			name:   "DRMLink",
+			filter: "drm:" + link,
+			want:   "0000:03:00.0",
+		},
+		{
+			name:   "Sysfs",
+			filter: "sys:/sys/devices/pci0000:00/0000:00:02.0",
+			want:   "0000:00:02.0",
+		},
+		{
+			name:    "Unresolvable",
+			filter:  "pci:vendor=8086,device=46A6",
+			wantErr: `no PCI address in device filter "pci:vendor=8086,device=46A6"`,
+		},
+		{
+			name:    "BadSlot",
+			filter:  "pci:slot=card0",
+			wantErr: `invalid PCI address "card0"`,
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			got, err := devicePCIAddress(tt.filter, devices, root)
+			if tt.wantErr != "" {
+				c.Assert(err, qt.ErrorMatches, tt.wantErr)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(got, qt.Equals, tt.want)
+		})
+	}
+
+	// Labels fall back to the filter, and stay empty without one
+	c.Assert(deviceLabelValue("drm:/dev/dri/card1", devices, root), qt.Equals, "0000:03:00.0")
+	c.Assert(deviceLabelValue("pci:vendor=8086,device=46A6", devices, root), qt.Equals, "pci:vendor=8086,device=46A6")
+	c.Assert(deviceLabelValue("", devices, root), qt.Equals, "")
+}
+
+func TestDeviceFilterForPath(t *testing.T) {
+	c := qt.New(t)
+
+	dri := filepath.Join(c.TempDir(), "dri")
+	c.Assert(os.Mkdir(dri, 0o755), qt.IsNil)
+	card := filepath.Join(dri, "card0")
+	c.Assert(os.WriteFile(card, nil, 0o644), qt.IsNil)
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "Present",
+			path: card,
+			want: "drm:" + card,
+		},
+		{
+			name:    "MissingNode",
+			path:    filepath.Join(dri, "card1"),
+			wantErr: "stat .*/dri/card1: no such file or directory",
+		},
+		{
+			name:    "MissingDRI",
+			path:    filepath.Join(c.TempDir(), "dri", "card0"),
+			wantErr: `.*/dri is not accessible, pass the GPU into the container, e\.g\. docker run --device /dev/dri: .*`,
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			got, err := deviceFilterForPath(tt.path)
+			if tt.wantErr != "" {
+				c.Assert(err, qt.ErrorMatches, tt.wantErr)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(got, qt.Equals, tt.want)
+		})
+	}
+}
+
+func TestListDevices(t *testing.T) {
+	c := qt.New(t)
+
+	root := c.TempDir()
+	fakeDRMCard(c, root, "card0", "0000:00:02.0", "0x8086")
+	fakeDRMCard(c, root, "card1", "0000:03:00.0", "0x8086")
+
+	// Stub intel_gpu_top printing a canned device listing; card2 has no
+	// sysfs entry so falls back to its card name
+	binary := filepath.Join(c.TempDir(), "intel_gpu_top")
+	script := `#!/bin/sh
+[ "$1" = "-L" ] || exit 1
+echo "card0                    Intel Alderlake_p (Gen12)         pci:vendor=8086,device=46A6,card=0"
+echo "card1                    Intel Dg2 (Gen12)                 pci:vendor=8086,device=56A0,card=1"
+echo "card2                    Intel Dg2 (Gen12)                 pci:vendor=8086,device=56A0,card=2"
+`
+	c.Assert(os.WriteFile(binary, []byte(script), 0o755), qt.IsNil)
+
+	devices, err := listDevices(context.Background(), binary, root)
+	c.Assert(err, qt.IsNil)
+	c.Assert(devices, gpuDeviceEquals, []gpuDevice{
+		{card: "card0", name: "Intel Alderlake_p (Gen12)", filter: "pci:vendor=8086,device=46A6,card=0", pciAddress: "0000:00:02.0"},
+		{card: "card1", name: "Intel Dg2 (Gen12)", filter: "pci:vendor=8086,device=56A0,card=1", pciAddress: "0000:03:00.0"},
+		{card: "card2", name: "Intel Dg2 (Gen12)", filter: "pci:vendor=8086,device=56A0,card=2", pciAddress: "card2"},
+	})
+
+	setDeviceInfo(devices)
+	c.Assert(testutil.ToFloat64(DeviceInfo.WithLabelValues("0000:03:00.0", "card1", "Intel Dg2 (Gen12)")), qt.Equals, 1.0)
+	c.Assert(testutil.CollectAndCount(DeviceInfo), qt.Equals, 3)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ExporterUpGauge tells a stalled or exited intel_gpu_top apart from an
+// idle GPU, as the GPU gauges keep their last values either way.
+var ExporterUpGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "exporter_up",
+	Help: "1 while intel_gpu_top records are flowing for every device, 0 once one exited or had none parsed within -stale-after",
+})
+
+// GPUTopFailureGauge tells why intel_gpu_top isn't delivering records, so a
+// missing binary or missing permissions show up without reading logs.
+var GPUTopFailureGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "top_failure",
+	Help: "1 labelled with why intel_gpu_top last failed for a device: not_found, permission_denied or exited; absent while records are flowing",
+}, []string{deviceLabel, "reason"})
+
+// Reasons GPUTopFailureGauge reports intel_gpu_top failing for.
+const (
+	failureNotFound         = "not_found"
+	failurePermissionDenied = "permission_denied"
+	failureExited           = "exited"
+)
+
+// healthCheckInterval is how often ExporterUpGauge is re-evaluated.
+const healthCheckInterval = time.Second
+
+// staleIntervals is how many of the longest sampling interval may pass
+// without a record before collection is considered stalled, by default.
+const staleIntervals = 3
+
+// collectorHealth tracks when the collection pipeline last parsed a record.
+type collectorHealth struct {
+	mu sync.Mutex
+	// last is when the last record was parsed, zero while intel_gpu_top
+	// isn't running.
+	last time.Time
+	// parsed is set once any record was parsed, confirming the pipeline
+	// works.
+	parsed bool
+}
+
+// Observe records a record parsed at now.
+func (h *collectorHealth) Observe(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = now
+	h.parsed = true
+}
+
+// Exited records that intel_gpu_top exited, so no records are coming.
+func (h *collectorHealth) Exited() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = time.Time{}
+}
+
+// Up reports whether a record was parsed within staleAfter of now.
+func (h *collectorHealth) Up(now time.Time, staleAfter time.Duration) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.last.IsZero() && now.Sub(h.last) <= staleAfter
+}
+
+// Ready reports whether a record was ever parsed.
+func (h *collectorHealth) Ready() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.parsed
+}
+
+// runHealthCheck updates ExporterUpGauge every interval until ctx is
+// cancelled, reporting up only while every device in health is.
+func runHealthCheck(ctx context.Context, health []*collectorHealth, staleAfter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			up := 1.0
+			for _, h := range health {
+				if !h.Up(now, staleAfter) {
+					up = 0
+				}
+			}
+			ExporterUpGauge.Set(up)
+		}
+	}
+}
+
+// livenessHandler serves /healthz, answering 200 until ctx is cancelled.
+func livenessHandler(ctx context.Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ctx.Err() != nil {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// readinessHandler serves /readyz, answering 200 once any device in health
+// parsed a record and 503 before, so probes wait for intel_gpu_top to
+// actually work.
+func readinessHandler(health []*collectorHealth) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range health {
+			if h.Ready() {
+				w.Write([]byte("ok\n"))
+				return
+			}
+		}
+		http.Error(w, "no intel_gpu_top record parsed yet", http.StatusServiceUnavailable)
+	})
+}
+
+// gpuTopFailure classifies err, returned when intel_gpu_top failed to start
+// or exited. Lacking access to the GPU's performance counters is only
+// reported on intel_gpu_top's stderr, which the runner's error carries.
+func gpuTopFailure(err error) string {
+	switch {
+	case err == nil:
+		return failureExited
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return failureNotFound
+	case errors.Is(err, fs.ErrPermission), strings.Contains(strings.ToLower(err.Error()), "permission denied"):
+		return failurePermissionDenied
+	default:
+		return failureExited
+	}
+}
+
+// setGPUTopFailure reports reason as why intel_gpu_top fails on device, or
+// clears it when reason is empty.
+func setGPUTopFailure(device, reason string) {
+	GPUTopFailureGauge.DeletePartialMatch(prometheus.Labels{deviceLabel: device})
+	if reason != "" {
+		GPUTopFailureGauge.WithLabelValues(device, reason).Set(1)
+	}
+}
+
+// logGPUTopFailure logs msg for intel_gpu_top failing with err, spelling
+// out the fix when the reason is a common one.
+func logGPUTopFailure(events *slog.Logger, msg, reason string, err error) {
+	switch reason {
+	case failureNotFound:
+		msg += ": not found, install intel-gpu-tools or point -binary at it"
+	case failurePermissionDenied:
+		msg += ": permission denied, run the exporter as root or grant intel_gpu_top CAP_PERFMON"
+	}
+	events.Error(msg, "event", "crash", "reason", reason, "err", err)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollectorHealth(t *testing.T) {
+	c := qt.New(t)
+
+	var h collectorHealth
+	start := time.Now()
+	c.Assert(h.Up(start, time.Second), qt.IsFalse)
+
+	h.Observe(start)
+	c.Assert(h.Up(start.Add(time.Second), time.Second), qt.IsTrue)
+	c.Assert(h.Up(start.Add(2*time.Second), time.Second), qt.IsFalse)
+
+	h.Observe(start)
+	h.Exited()
+	c.Assert(h.Up(start, time.Second), qt.IsFalse)
+}
+
+func TestExporterUpStalledReader(t *testing.T) {
+	c := qt.New(t)
+	c.Cleanup(func() { ExporterUpGauge.Set(0) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runner, w := newStreamingRunner()
+	health := &collectorHealth{}
+	opts := collectOptions{interval: time.Second, health: health}
+	done := make(chan struct{})
+	go func() {
+		runGPUTop(ctx, cancel, runner, discardSink{}, opts)
+		close(done)
+	}()
+	go runHealthCheck(ctx, []*collectorHealth{health}, 50*time.Millisecond, 5*time.Millisecond)
+
+	waitForUp := func(want float64) {
+		c.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for testutil.ToFloat64(ExporterUpGauge) != want {
+			if time.Now().After(deadline) {
+				c.Fatalf("intel_gpu_exporter_up never became %v", want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Up while records flow, then down once the reader stalls
+	_, err := io.WriteString(w, "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa\n1200.0,1150.0,500.0,10.0,80.0,0.0,0.0\n")
+	c.Assert(err, qt.IsNil)
+	waitForUp(1)
+	waitForUp(0)
+
+	// Records flowing again bring it back up
+	_, err = io.WriteString(w, "1200.0,1150.0,500.0,10.0,80.0,0.0,0.0\n")
+	c.Assert(err, qt.IsNil)
+	waitForUp(1)
+
+	cancel()
+	<-done
+}
+
+func TestLivenessHandler(t *testing.T) {
+	c := qt.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := livenessHandler(ctx)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+
+	cancel()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusServiceUnavailable)
+}
+
+func TestReadinessHandler(t *testing.T) {
+	c := qt.New(t)
+
+	igpu, arc := &collectorHealth{}, &collectorHealth{}
+	handler := readinessHandler([]*collectorHealth{igpu, arc})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusServiceUnavailable)
+
+	// One parsed record is enough, even once intel_gpu_top exited, as
+	// stalls are what intel_gpu_exporter_up reports
+	arc.Observe(time.Now())
+	arc.Exited()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+}
+
+func TestGPUTopFailure(t *testing.T) {
+	c := qt.New(t)
+	c.Cleanup(GPUTopFailureGauge.Reset)
+
+	dir := c.TempDir()
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		c.Assert(os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755), qt.IsNil)
+		return path
+	}
+	notExecutable := filepath.Join(dir, "not-executable")
+	c.Assert(os.WriteFile(notExecutable, nil, 0o644), qt.IsNil)
+
+	tests := []struct {
+		name       string
+		binary     string
+		wantReason string
+		wantLog    string
+	}{
+		{
+			name:       "Missing",
+			binary:     filepath.Join(dir, "missing"),
+			wantReason: failureNotFound,
+			wantLog:    "not found, install intel-gpu-tools",
+		},
+		{
+			name:       "NotInPath",
+			binary:     "no-such-intel_gpu_top",
+			wantReason: failureNotFound,
+			wantLog:    "not found, install intel-gpu-tools",
+		},
+		{
+			name:       "NotExecutable",
+			binary:     notExecutable,
+			wantReason: failurePermissionDenied,
+			wantLog:    "CAP_PERFMON",
+		},
+		{
+			// As printed by intel_gpu_top without access to the PMU
+			name:       "NoPerfmon",
+			binary:     script("no-perfmon", "echo 'Failed to initialize PMU! (Permission denied)' >&2\nexit 1\n"),
+			wantReason: failurePermissionDenied,
+			wantLog:    "CAP_PERFMON",
+		},
+		{
+			name:       "Crash",
+			binary:     script("crash", "exit 1\n"),
+			wantReason: failureExited,
+			wantLog:    "exit status 1",
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			GPUTopFailureGauge.Reset()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var logs bytes.Buffer
+			opts := collectOptions{interval: time.Second, device: "card0", logger: slog.New(slog.NewTextHandler(&logs, nil))}
+			runGPUTop(ctx, cancel, newExecRunner(tt.binary), discardSink{}, opts)
+
+			err := testutil.CollectAndCompare(GPUTopFailureGauge, strings.NewReader(`
+# HELP top_failure 1 labelled with why intel_gpu_top last failed for a device: not_found, permission_denied or exited; absent while records are flowing
+# TYPE top_failure gauge
+top_failure{device="card0",reason="`+tt.wantReason+`"} 1
+`))
+			c.Assert(err, qt.IsNil)
+			c.Assert(logs.String(), qt.Contains, tt.wantLog)
+		})
+	}
+
+	// The failure is cleared once records flow again
+	GPUTopFailureGauge.Reset()
+	setGPUTopFailure("card0", failureExited)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner, w := newStreamingRunner()
+	opts := collectOptions{interval: time.Second, device: "card0", logger: slog.New(slog.DiscardHandler)}
+	done := make(chan struct{})
+	go func() {
+		runGPUTop(ctx, cancel, runner, discardSink{}, opts)
+		close(done)
+	}()
+
+	_, err := io.WriteString(w, "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa\n1200.0,1150.0,500.0,10.0,80.0,0.0,0.0\n")
+	c.Assert(err, qt.IsNil)
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.CollectAndCount(GPUTopFailureGauge) != 0 {
+		if time.Now().After(deadline) {
+			c.Fatal("intel_gpu_top_failure was never cleared")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
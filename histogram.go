@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rc6Buckets are the RC6 residency histogram bounds in percent. They are
+// densest near 100 to separate deep idle from a GPU hovering just awake.
+var rc6Buckets = []float64{1, 5, 10, 25, 50, 75, 90, 95, 99, 100}
+
+// newRC6Histogram returns a histogram of per-sample RC6 residency named
+// under namespace. scale multiplies the bucket bounds, so 0.01 matches
+// samples published as fractions with -fraction.
+func newRC6Histogram(namespace string, scale float64) prometheus.Histogram {
+	buckets := make([]float64, len(rc6Buckets))
+	for i, b := range rc6Buckets {
+		buckets[i] = b * scale
+	}
+
+	return prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rc6_residency",
+		Help:      "Distribution of Intel GPU RC6 power state residency per sample",
+		Buckets:   buckets,
+	})
+}
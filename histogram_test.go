@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPrometheusSinkRC6Histogram(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name    string
+		scale   float64
+		samples []float64
+	}{
+		{name: "Percent", scale: 1, samples: []float64{0, 3, 50, 97, 99.5, 100}},
+		{name: "Fraction", scale: 0.01, samples: []float64{0, 0.03, 0.5, 0.97, 0.995, 1}},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			histogram := newRC6Histogram(defaultNamespace, tt.scale)
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(histogram)
+
+			sink := prometheusSink{collector: newCollector(collectorOptions{}), rc6Histogram: histogram}
+			for _, rc6 := range tt.samples {
+				sink.Update(IntelTopStats{Rc6Percent: rc6}, nil)
+			}
+
+			families, err := reg.Gather()
+			c.Assert(err, qt.IsNil)
+			c.Assert(families, qt.HasLen, 1)
+
+			h := families[0].GetMetric()[0].GetHistogram()
+			c.Assert(h.GetSampleCount(), qt.Equals, uint64(len(tt.samples)))
+
+			cumulative := make(map[float64]uint64)
+			for _, b := range h.GetBucket() {
+				cumulative[b.GetUpperBound()/tt.scale] = b.GetCumulativeCount()
+			}
+			c.Assert(cumulative[1], qt.Equals, uint64(1))
+			c.Assert(cumulative[5], qt.Equals, uint64(2))
+			c.Assert(cumulative[50], qt.Equals, uint64(3))
+			c.Assert(cumulative[95], qt.Equals, uint64(3))
+			c.Assert(cumulative[99], qt.Equals, uint64(4))
+			c.Assert(cumulative[100], qt.Equals, uint64(6))
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// idleDetector tracks whether the GPU has been idle long enough to sample it
+// less often. The slower intel_gpu_top run doubles as the probe that notices
+// when activity resumes.
+type idleDetector struct {
+	// threshold is the busy percentage at or above which an engine counts
+	// as active.
+	threshold float64
+	// after is how long every engine must stay below threshold before
+	// switching to idle.
+	after time.Duration
+	// interval is the sampling interval used while idle.
+	interval time.Duration
+
+	idleSince time.Time
+	idle      bool
+}
+
+// Idle reports whether the detector is in the idle state. A nil detector is
+// never idle.
+func (d *idleDetector) Idle() bool {
+	return d != nil && d.idle
+}
+
+// Observe feeds a sample taken at now and reports whether the idle state
+// changed as a result.
+func (d *idleDetector) Observe(stats IntelTopStats, now time.Time) bool {
+	active := false
+	for _, engine := range stats.Engine {
+		if engine.BusyPercent >= d.threshold {
+			active = true
+			break
+		}
+	}
+
+	if active {
+		d.idleSince = time.Time{}
+		if d.idle {
+			d.idle = false
+			slog.Info("GPU active, resuming full-rate sampling")
+			return true
+		}
+		return false
+	}
+
+	if d.idleSince.IsZero() {
+		d.idleSince = now
+	}
+	if !d.idle && now.Sub(d.idleSince) >= d.after {
+		d.idle = true
+		slog.Info("GPU idle, reducing sampling rate", "idle_for", d.after)
+		return true
+	}
+
+	return false
+}
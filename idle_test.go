@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestIdleDetectorObserve(t *testing.T) {
+	c := qt.New(t)
+
+	idle := IntelTopStats{Engine: map[string]IntelEngine{"RCS": {BusyPercent: 0.2}, "VCS": {BusyPercent: 0.9}}}
+	busy := IntelTopStats{Engine: map[string]IntelEngine{"RCS": {BusyPercent: 0.2}, "VCS": {BusyPercent: 35}}}
+
+	steps := []struct {
+		stats      IntelTopStats
+		at         time.Duration
+		changed    bool
+		expectIdle bool
+	}{
+		{stats: idle, at: 0, changed: false, expectIdle: false},
+		{stats: idle, at: 20 * time.Second, changed: false, expectIdle: false},
+		// A burst of activity restarts the idle timer
+		{stats: busy, at: 25 * time.Second, changed: false, expectIdle: false},
+		{stats: idle, at: 26 * time.Second, changed: false, expectIdle: false},
+		{stats: idle, at: 55 * time.Second, changed: false, expectIdle: false},
+		// idle -> after 30s below threshold
+		{stats: idle, at: 56 * time.Second, changed: true, expectIdle: true},
+		{stats: idle, at: 90 * time.Second, changed: false, expectIdle: true},
+		// active as soon as any engine crosses the threshold
+		{stats: busy, at: 91 * time.Second, changed: true, expectIdle: false},
+		{stats: busy, at: 92 * time.Second, changed: false, expectIdle: false},
+	}
+
+	d := &idleDetector{threshold: 1, after: 30 * time.Second, interval: 10 * time.Second}
+	start := time.Unix(0, 0)
+	for i, step := range steps {
+		c.Assert(d.Observe(step.stats, start.Add(step.at)), qt.Equals, step.changed, qt.Commentf("step %d", i))
+		c.Assert(d.Idle(), qt.Equals, step.expectIdle, qt.Commentf("step %d", i))
+	}
+}
+
+func TestIdleDetectorNil(t *testing.T) {
+	c := qt.New(t)
+
+	var d *idleDetector
+	c.Assert(d.Idle(), qt.IsFalse)
+}
+
+func TestRunGPUTopIdleRestarts(t *testing.T) {
+	c := qt.New(t)
+
+	header := "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa\n"
+	idle := "300.0,300.0,0.0,99.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0\n"
+	busy := "1200.0,1150.0,500.0,10.0,80.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0\n"
+
+	runner := &fakeRunner{outputs: []string{
+		header + busy + idle,
+		header + idle + busy,
+		header + busy,
+	}}
+	opts := collectOptions{
+		interval: time.Second,
+		idle:     &idleDetector{threshold: 1, after: 0, interval: 10 * time.Second},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runGPUTop(ctx, cancel, runner, discardSink{}, opts)
+
+	// Full rate, then slowed down once idle, then full rate once busy again
+	c.Assert(runner.intervals, qt.DeepEquals, []time.Duration{time.Second, 10 * time.Second, time.Second})
+}
@@ -2,288 +2,1108 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"iter"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
-	"os/exec"
-	"slices"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/mikeodr/intel-gpu-exporter-go/pkg/igtparse"
 )
 
 var (
-	FreqMhzRequested = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "intel_gpu_freq_mhz_requested",
-		Help: "Intel GPU requested frequency in MHz",
-	})
-	FreqMhzActual = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "intel_gpu_freq_mhz_actual",
-		Help: "Intel GPU actual frequency in MHz",
+	HeaderReparsedCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "header_reparsed_total",
+		Help: "Times the CSV column layout was derived from a header line",
 	})
-	IRQPerSecGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "intel_gpu_irq_per_sec",
-		Help: "Intel GPU IRQs per second",
+	HeartbeatCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "exporter_heartbeat_total",
+		Help: "Incremented on a fixed schedule while the exporter process is alive",
 	})
-	Rc6PercentGauge = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "intel_gpu_rc6_percent",
-		Help: "Intel GPU RC6 power state percentage",
+	GPUTopRestartsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "top_restarts_total",
+		Help: "Times intel_gpu_top was relaunched after exiting",
 	})
-	EngineGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "intel_gpu_engine_percent",
-		Help: "Intel GPU engine busy percentage",
-	}, []string{"engine", "type"})
 )
 
-func init() {
-	// Register metrics with Prometheus
-	prometheus.MustRegister(FreqMhzRequested)
-	prometheus.MustRegister(FreqMhzActual)
-	prometheus.MustRegister(IRQPerSecGauge)
-	prometheus.MustRegister(Rc6PercentGauge)
-	prometheus.MustRegister(EngineGauge)
-}
+// sampleLimits are the bounds readMetrics enforces, set from -max-freq-mhz,
+// -max-irq-per-sec and -strict.
+var sampleLimits = igtparse.DefaultLimits
 
-type IntelTopStats struct {
-	FreqMhzRequested float64
-	FreqMhzActual    float64
-	IRQPerSec        float64
-	Rc6Percent       float64
-	Engine           map[string]IntelEngine
-}
+// gpuEngines are the engine classes present on the GPU, or nil to accept
+// every engine. Engines missing from it are dropped from samples so they
+// don't publish spurious zero series.
+var gpuEngines []string
 
-type IntelEngine struct {
-	BusyPercent float64
-	SemaPercent float64
-	WaitPercent float64
-}
+// heartbeatInterval is how often HeartbeatCounter is incremented. It is
+// independent of intel_gpu_top so a flat heartbeat means the process itself
+// is wedged rather than GPU collection being down.
+const heartbeatInterval = 5 * time.Second
 
-type IntelEngineType int
-
-// Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
-const (
-	FreqMHzReq IntelEngineType = iota
-	FreqMHzAct
-	IRQPerSec
-	Rc6Percent
-	RCSPercentBusy
-	RCSPercentSema
-	RCSPercentWait
-	BCSPercentBusy
-	BCSPercentSema
-	BCSPercentWait
-	VCSPercentBusy
-	VCSPercentSema
-	VCSPercentWait
-	VECSPercentBusy
-	VECSPercentSema
-	VECSPercentWait
-)
+// IntelTopStats is one intel_gpu_top sample.
+type IntelTopStats = igtparse.IntelTopStats
+
+// IntelEngine is the utilisation of one engine.
+type IntelEngine = igtparse.IntelEngine
 
 func main() {
-	port := flag.Int("port", 8080, "Port to expose metrics on")
-	flag.Parse()
+	if err := run(os.Args[1:]); err != nil {
+		slog.Error("Exporter failed", "err", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// run starts the exporter with the command line arguments args and serves
+// until collection stops. Startup failures are returned wrapped in one of
+// errConfig, errListen, errTLS or errAlreadyRunning.
+func run(args []string) error {
+	start := time.Now()
+
+	var cfg config
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	cfg.registerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return fmt.Errorf("%w: %w", errConfig, err)
+	}
+
+	// Set up logging first so everything after is logged as configured
+	logger, err := newLogger(os.Stderr, cfg.logFormat, cfg.logLevel)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errConfig, err)
+	}
+	slog.SetDefault(logger)
+
+	// A Unix socket replaces the TCP listener, so asking for both is a
+	// mistake rather than something to pick between
+	var addr string
+	if cfg.unixSocket != "" {
+		var tcp bool
+		fs.Visit(func(f *flag.Flag) {
+			tcp = tcp || f.Name == "listen" || f.Name == "port"
+		})
+		if tcp {
+			return fmt.Errorf("%w: -unix-socket can't be combined with -listen or -port", errConfig)
+		}
+		addr = cfg.unixSocket
+	} else if addr, err = cfg.listenAddress(); err != nil {
+		return fmt.Errorf("%w: %w", errConfig, err)
+	}
+	if (cfg.authUser == "") != (cfg.authPass == "") {
+		return fmt.Errorf("%w: -auth-user and -auth-pass must be set together", errConfig)
+	}
+
+	if cfg.interval < time.Millisecond {
+		return fmt.Errorf("%w: invalid interval %s", errConfig, cfg.interval)
+	}
+
+	switch cfg.format {
+	case formatCSV:
+		if cfg.enableClients {
+			return fmt.Errorf("%w: -enable-clients needs -format=json", errConfig)
+		}
+	case formatJSON:
+		if cfg.columnMap != "" {
+			slog.Warn("-column-map has no effect with -format=json")
+		}
+	default:
+		return fmt.Errorf("%w: invalid format %q", errConfig, cfg.format)
+	}
+
+	if cfg.namespace != "" && !namespacePattern.MatchString(cfg.namespace) {
+		return fmt.Errorf("%w: invalid -namespace %q: must be letters, digits and underscores, not starting with a digit", errConfig, cfg.namespace)
+	}
+	if err := checkMetricsPath(cfg.metricsPath); err != nil {
+		return fmt.Errorf("%w: %w", errConfig, err)
+	}
+
+	switch cfg.scrapeAggregate {
+	case aggregateLatest, aggregateMean, aggregateMax:
+	default:
+		return fmt.Errorf("%w: invalid -scrape-aggregate %q: must be %s, %s or %s", errConfig, cfg.scrapeAggregate, aggregateLatest, aggregateMean, aggregateMax)
+	}
+	var window time.Duration
+	if cfg.aggregate != "" {
+		if window, err = parseAggregateSpec(cfg.aggregate); err != nil {
+			return fmt.Errorf("%w: %w", errConfig, err)
+		}
+	}
+
+	if cfg.maxFreqMhz < 0 || cfg.maxIRQPerSec < 0 {
+		return fmt.Errorf("%w: -max-freq-mhz and -max-irq-per-sec must not be negative", errConfig)
+	}
+	sampleLimits = igtparse.Limits{FreqMhz: cfg.maxFreqMhz, IRQPerSec: cfg.maxIRQPerSec, Strict: cfg.strict}
+
+	// A device node, as mounted into a container, is monitored through its
+	// drm: filter
+	for _, path := range cfg.devicePaths {
+		filter, err := deviceFilterForPath(path)
+		if err != nil {
+			return fmt.Errorf("%w: -device-path: %w", errConfig, err)
+		}
+		cfg.devices = append(cfg.devices, filter)
+	}
+
+	if flags := cfg.singleDeviceFlags(); len(cfg.devices) > 1 && len(flags) > 0 {
+		return fmt.Errorf("%w: %s can't be combined with several -device flags", errConfig, strings.Join(flags, ", "))
+	}
+
+	// Refuse to compete with another exporter for the GPU
+	if cfg.singleInstance {
+		lock, err := acquireInstanceLock(cfg.lockFile)
+		if err != nil {
+			if errors.Is(err, errAlreadyRunning) {
+				return err
+			}
+			return fmt.Errorf("%w: lock file: %w", errConfig, err)
+		}
+		defer func() {
+			if err := lock.Release(); err != nil {
+				slog.Error("Error releasing lock file", "path", cfg.lockFile, "err", err)
+			}
+		}()
+	}
+
+	// Check what the exporter depends on before registering anything, so a
+	// failed start leaves no state behind
+	switch cfg.source {
+	case "exec":
+		// A missing or unusable intel_gpu_top doesn't stop the exporter. It
+		// keeps serving with intel_gpu_exporter_up at 0 and retries, so the
+		// reason shows up in Prometheus.
+	case "stdin":
+	default:
+		return fmt.Errorf("%w: invalid source %q", errConfig, cfg.source)
+	}
+
+	// -input replays captured output in place of -source, with - standing
+	// for stdin
+	source, from := cfg.source, "-source="+cfg.source
+	if cfg.input != "" {
+		var explicit bool
+		fs.Visit(func(f *flag.Flag) {
+			explicit = explicit || f.Name == "source"
+		})
+		if explicit {
+			return fmt.Errorf("%w: -input can't be combined with -source", errConfig)
+		}
+		source, from = "stdin", "-input"
+		if cfg.input != "-" {
+			source = "file"
+			if _, err := os.Stat(cfg.input); err != nil {
+				return fmt.Errorf("%w: input: %w", errConfig, err)
+			}
+		}
+	}
+	if source != "file" && cfg.inputLoop {
+		slog.Warn("-input-loop only has an effect with an -input file")
+	}
+	if source != "exec" && len(cfg.devices) > 1 {
+		return fmt.Errorf("%w: %s reads a single device", errConfig, from)
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.tlsCert != "" || cfg.tlsKey != "" {
+		if cfg.tlsCert == "" || cfg.tlsKey == "" {
+			return fmt.Errorf("%w: -tls-cert and -tls-key must be set together", errTLS)
+		}
+		reloader, err := newCertReloader(cfg.tlsCert, cfg.tlsKey)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errTLS, err)
+		}
+		tlsConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+	}
+
+	// Bind up front so an address in use is reported as a startup failure
+	server := &http.Server{Addr: addr, TLSConfig: tlsConfig}
+	var listener net.Listener
+	if cfg.unixSocket != "" {
+		listener, err = listenUnix(cfg.unixSocket)
+	} else {
+		listener, err = net.Listen("tcp", server.Addr)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", errListen, err)
+	}
+	defer listener.Close()
+
+	// Debug routes get their own listener so they can be firewalled off
+	var debugServer *http.Server
+	var debugListener net.Listener
+	if cfg.debugListenAddress != "" {
+		debugServer = &http.Server{Addr: cfg.debugListenAddress, Handler: newDebugMux()}
+		if debugListener, err = net.Listen("tcp", debugServer.Addr); err != nil {
+			return fmt.Errorf("%w: %w", errListen, err)
+		}
+		defer debugListener.Close()
+	}
+
+	// -ratio renames the Prometheus exporter's percentage metrics, so it
+	// scales them as -fraction does
+	ratio := cfg.ratio && cfg.exporter == "prometheus"
+	fraction := cfg.fraction || ratio
+
+	// Every metric is served from the collector's registry
+	collector := newCollector(collectorOptions{
+		namespace:           cfg.namespace,
+		compact:             cfg.compact,
+		fraction:            fraction,
+		ratio:               ratio,
+		splitEngineInstance: cfg.splitEngineInstance,
+		engineOccupancy:     cfg.engineOccupancy,
+		clients:             cfg.enableClients,
+		scrapeAggregate:     cfg.scrapeAggregate,
+		window:              window,
+		disabled:            cfg.disabled,
+	})
+	// Metrics constructed per run are named under -namespace directly, while
+	// package-level ones get it from metrics as they are registered
+	reg := collector.registry
+	metrics := namespaced(reg, cfg.namespace)
+	registerExporterMetrics(reg, cfg.namespace)
+
+	// Select where samples are published
+	var backend MetricsSink
+	switch cfg.exporter {
+	case "prometheus":
+		if cfg.compact && cfg.enableClients {
+			slog.Warn("-enable-clients has no effect with -compact")
+		}
+		ps := prometheusSink{collector: collector}
+		if cfg.summaries {
+			objectives, err := parseObjectives(cfg.summaryObjectives)
+			if err != nil {
+				return fmt.Errorf("%w: summary objectives: %w", errConfig, err)
+			}
+			ps.freqSummary = newFreqSummary(cfg.namespace, objectives, cfg.summaryMaxAge)
+			ps.powerSummary = newPowerSummary(cfg.namespace, objectives, cfg.summaryMaxAge)
+			reg.MustRegister(ps.freqSummary, ps.powerSummary)
+		}
+		if cfg.histograms {
+			// Samples reach the backend already scaled by -fraction
+			scale := 1.0
+			if fraction {
+				scale = 0.01
+			}
+			ps.rc6Histogram = newRC6Histogram(cfg.namespace, scale)
+			reg.MustRegister(ps.rc6Histogram)
+		}
+		backend = ps
+	case "remote-write":
+		if cfg.compact {
+			slog.Warn("-compact has no effect with -exporter=remote-write")
+		}
+		if cfg.enableClients {
+			slog.Warn("-enable-clients has no effect with -exporter=remote-write")
+		}
+		if cfg.scrapeAggregate != aggregateLatest {
+			slog.Warn("-scrape-aggregate has no effect with -exporter=remote-write")
+		}
+		if cfg.aggregate != "" {
+			slog.Warn("-aggregate has no effect with -exporter=remote-write")
+		}
+		if cfg.ratio {
+			slog.Warn("-ratio has no effect with -exporter=remote-write")
+		}
+		if cfg.disabled != (disabledFamilies{}) {
+			slog.Warn("-disable-sema, -disable-wait, -disable-irq and -disable-rc6 have no effect with -exporter=remote-write")
+		}
+		if cfg.remoteWriteURL == "" {
+			return fmt.Errorf("%w: -remote-write-url is required with -exporter=remote-write", errConfig)
+		}
+		if cfg.remoteWriteBuffer <= 0 {
+			return fmt.Errorf("%w: invalid remote write buffer size %d", errConfig, cfg.remoteWriteBuffer)
+		}
+		rw := newRemoteWriteSink(cfg.remoteWriteURL, cfg.remoteWriteUsername, cfg.remoteWritePassword, cfg.remoteWriteBuffer)
+		rw.splitEngineInstance = cfg.splitEngineInstance
+		rw.namespace = cfg.namespace
+		rw.engineOccupancy = cfg.engineOccupancy
+		if fraction {
+			// Samples reach the backend already scaled by -fraction
+			rw.fullScale = 1
+		}
+		backend = rw
+	default:
+		return fmt.Errorf("%w: invalid exporter %q", errConfig, cfg.exporter)
+	}
+
+	// Wrap the backend with optional sample processing. Push backends are
+	// driven by goroutines started once the context exists.
+	var background []func(context.Context)
+	if rw, ok := backend.(*remoteWriteSink); ok {
+		background = append(background, rw.Run)
+	}
+
+	sink := backend
+	if fraction {
+		sink = fractionSink{next: sink}
+	}
+	if cfg.sinkAsync {
+		if cfg.exporter == "prometheus" {
+			slog.Warn("-sink-async has no effect with -exporter=prometheus")
+		} else {
+			if cfg.sinkAsyncBuffer <= 0 {
+				return fmt.Errorf("%w: invalid async sink buffer size %d", errConfig, cfg.sinkAsyncBuffer)
+			}
+			async := newAsyncSink(sink, cfg.sinkAsyncBuffer)
+			background = append(background, async.Run)
+			sink = async
+		}
+	}
+	if cfg.onlyOnChange {
+		if cfg.exporter == "prometheus" {
+			slog.Warn("-only-on-change has no effect with -exporter=prometheus")
+		} else {
+			sink = &changeFilterSink{next: sink, epsilon: cfg.changeEpsilon}
+		}
+	}
+	if cfg.aggregateWindow != 0 {
+		agg, err := newAggregatingSink(sink, cfg.aggregateWindow, cfg.aggregateMethod)
+		if err != nil {
+			return fmt.Errorf("%w: aggregation settings: %w", errConfig, err)
+		}
+		sink = agg
+	}
+	if cfg.syslog {
+		severity, err := parseSyslogSeverity(cfg.syslogPriority)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errConfig, err)
+		}
+		if cfg.syslogInterval <= 0 {
+			return fmt.Errorf("%w: invalid syslog interval %s", errConfig, cfg.syslogInterval)
+		}
+		// Syslog is a side channel, so carry on without it when unavailable
+		if w, err := openSyslog(severity); err != nil {
+			slog.Warn("Unable to open syslog, not writing summaries", "err", err)
+		} else {
+			summaries := &syslogSink{w: w, interval: cfg.syslogInterval}
+			background = append(background, summaries.Run)
+			sink = multiSink{summaries, sink}
+		}
+	}
+	if cfg.csvOutput != "" {
+		// Re-emit samples as parsed, before any scaling or aggregation
+		out := os.Stdout
+		if cfg.csvOutput != "-" {
+			f, err := os.Create(cfg.csvOutput)
+			if err != nil {
+				return fmt.Errorf("%w: CSV output: %w", errConfig, err)
+			}
+			defer f.Close()
+			out = f
+		}
+		sink = multiSink{newCSVSink(out), sink}
+	}
+
+	var mapping igtparse.ColumnMapping
+	if cfg.columnMap != "" {
+		if mapping, err = igtparse.LoadColumnMapping(cfg.columnMap); err != nil {
+			return fmt.Errorf("%w: column mapping: %w", errConfig, err)
+		}
+	}
+
+	opts := collectOptions{interval: cfg.interval, format: cfg.format, mapping: mapping}
+	if cfg.staleAfter < 0 {
+		return fmt.Errorf("%w: invalid stale-after %s", errConfig, cfg.staleAfter)
+	}
+	if cfg.readTimeout < 0 {
+		return fmt.Errorf("%w: invalid read-timeout %s", errConfig, cfg.readTimeout)
+	}
+	if cfg.termTimeout < 0 {
+		return fmt.Errorf("%w: invalid term-timeout %s", errConfig, cfg.termTimeout)
+	}
+	if cfg.idleAfter > 0 {
+		if cfg.idleInterval < time.Millisecond {
+			return fmt.Errorf("%w: invalid idle interval %s", errConfig, cfg.idleInterval)
+		}
+		opts.idle = &idleDetector{
+			threshold: cfg.idleThreshold,
+			after:     cfg.idleAfter,
+			interval:  cfg.idleInterval,
+		}
+	}
+
+	if cfg.adaptive {
+		if opts.idle != nil {
+			return fmt.Errorf("%w: -adaptive-sampling and -idle-after can't be combined", errConfig)
+		}
+		if cfg.adaptiveBusy < time.Millisecond || cfg.adaptiveIdle < time.Millisecond {
+			return fmt.Errorf("%w: invalid adaptive sampling intervals %s and %s", errConfig, cfg.adaptiveBusy, cfg.adaptiveIdle)
+		}
+		if cfg.adaptiveLow > cfg.adaptiveHigh {
+			return fmt.Errorf("%w: -adaptive-low %v must not exceed -adaptive-high %v", errConfig, cfg.adaptiveLow, cfg.adaptiveHigh)
+		}
+		opts.adaptive = &adaptiveSampler{
+			high:         cfg.adaptiveHigh,
+			low:          cfg.adaptiveLow,
+			busyInterval: cfg.adaptiveBusy,
+			idleInterval: cfg.adaptiveIdle,
+		}
+	}
+
+	if cfg.busyWeightedWindow < 0 {
+		return fmt.Errorf("%w: invalid busy-weighted window %s", errConfig, cfg.busyWeightedWindow)
+	} else if cfg.busyWeightedWindow > 0 {
+		opts.weighted = &busyWeightedFreq{window: cfg.busyWeightedWindow}
+		metrics.MustRegister(FreqBusyWeightedGauge)
+	}
+
+	if !cfg.disabled.irq {
+		metrics.MustRegister(IRQCounter)
+	}
+
+	if cfg.freqBins != "" {
+		bins, err := parseFreqBins(cfg.freqBins)
+		if err != nil {
+			return fmt.Errorf("%w: frequency bins: %w", errConfig, err)
+		}
+		// Publish every band from the start so rates work before the GPU
+		// first reaches it
+		for _, label := range bins.Labels() {
+			FreqResidencyCounter.WithLabelValues(label)
+		}
+		opts.freqBins = bins
+		metrics.MustRegister(FreqResidencyCounter)
+	}
+
+	if cfg.collectInternal {
+		registerInternalMetrics(metrics, start)
+	}
+
+	// Without -device, intel_gpu_top picks the GPU and series get an empty
+	// device label. Otherwise they are labelled with the GPU's PCI address.
+	monitored := []string(cfg.devices)
+	if len(monitored) == 0 {
+		monitored = []string{""}
+	}
+
+	var newRunner func(device string) gpuTopRunner
+	var devices []gpuDevice
+	switch source {
+	case "exec":
+		newRunner = func(device string) gpuTopRunner {
+			args := cfg.gpuTopArgs(device)
+			r := newExecRunner(cfg.binary, args...)
+			if cfg.sudo {
+				r = newSudoRunner(cfg.binary, args...)
+			}
+			r.termTimeout = cfg.termTimeout
+			return r
+		}
+		opts.backoff = &restartBackoff{min: minRestartBackoff, max: maxRestartBackoff}
+		opts.readTimeout = cfg.readTimeout
+
+		// Record the version, as the output format varies between releases
+		igtVersion := unknownGPUTopVersion
+		if v, err := gpuTopVersion(context.Background(), cfg.binary); err != nil {
+			slog.Warn("Unable to determine intel_gpu_top version", "err", err)
+		} else {
+			igtVersion = v
+		}
+		setBuildInfo(igtVersion)
+
+		// Identify GPUs by PCI address, which survives reboots
+		devices, err = listDevices(context.Background(), cfg.binary, drmSysfsPath)
+		if err != nil {
+			slog.Warn("Unable to list GPU devices", "err", err)
+		}
+		setDeviceInfo(devices)
+
+		// Only expect the engines this GPU actually has. That is the first
+		// GPU, so engines can't be narrowed down once GPUs are chosen.
+		if len(cfg.devices) == 0 {
+			engines, err := detectEngines(drmSysfsPath)
+			if err != nil {
+				slog.Warn("Unable to detect GPU engines, accepting all", "err", err)
+			} else {
+				gpuEngines = engines
+			}
+		}
+	case "stdin", "file":
+		// intel_gpu_top's lifecycle is managed by whoever feeds stdin, and
+		// a capture is replayed as it was recorded
+		newRunner = func(string) gpuTopRunner { return readerRunner{r: os.Stdin} }
+		if source == "file" {
+			newRunner = func(string) gpuTopRunner { return fileRunner{path: cfg.input} }
+			if cfg.inputLoop {
+				opts.backoff = &restartBackoff{min: minRestartBackoff, max: maxRestartBackoff}
+			}
+		}
+		if opts.idle != nil {
+			slog.Warn("-idle-after has no effect with " + from)
+			opts.idle = nil
+		}
+		if opts.adaptive != nil {
+			slog.Warn("-adaptive-sampling has no effect with " + from)
+			opts.adaptive = nil
+		}
+	}
 
-	if port == nil || *port <= 0 || *port > 65535 {
-		log.Fatalf("Invalid port number: %v", port)
+	// Collection counts as stalled after a few of the slowest sampling
+	// intervals in use without a record, unless set explicitly
+	staleAfter := cfg.staleAfter
+	if staleAfter == 0 {
+		staleAfter = staleIntervals * opts.longestInterval()
 	}
 
-	// Create a context that can be cancelled
-	ctx, cancel := context.WithCancel(context.Background())
+	// Each GPU gets its own intel_gpu_top
+	manager := &deviceManager{}
+	labelled := make(map[string]string, len(monitored))
+	for _, device := range monitored {
+		label := deviceLabelValue(device, devices, drmSysfsPath)
+		if other, ok := labelled[label]; ok {
+			return fmt.Errorf("%w: -device %s and %s select the same GPU %s", errConfig, other, device, label)
+		}
+		labelled[label] = device
+		manager.collectors = append(manager.collectors, deviceCollector{
+			runner: newRunner(device),
+			opts:   opts.forDevice(label),
+		})
+	}
+
+	// Report what the process actually ended up using
+	setConfigInfo(fs)
+
+	// Describe the target for OTel-style metadata joins
+	if cfg.openMetrics {
+		hostname, err := os.Hostname()
+		if err != nil {
+			slog.Warn("Unable to determine hostname for target_info", "err", err)
+		}
+		var device string
+		if len(devices) > 0 {
+			device = devices[0].pciAddress
+		}
+		reg.MustRegister(newTargetInfo(hostname, device, version))
+	}
+
+	// Memory changes constantly, so it is read from sysfs on each scrape
+	if cfg.memoryTotalFile != "" || cfg.memoryUsedFile != "" {
+		metrics.MustRegister(memoryCollector{totalPath: cfg.memoryTotalFile, usedPath: cfg.memoryUsedFile})
+	}
+
+	// GPU type is fixed, so read it once; omit the metric when unknown
+	if err := registerGPUInfo(metrics, drmSysfsPath); err != nil {
+		slog.Warn("Unable to determine GPU type, omitting its metric", "metric", prometheus.BuildFQName(cfg.namespace, "", "is_discrete"), "err", err)
+	}
+
+	// Cancelled on SIGINT or SIGTERM, so stopping the service shuts down
+	// gracefully instead of intel_gpu_top being relaunched
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	// Push backends drain their buffers in the background, and flush them
+	// once ctx is cancelled
+	var drains sync.WaitGroup
+	for _, drain := range background {
+		drains.Go(func() { drain(ctx) })
+	}
+
 	// Start continuous metrics collection with context
-	go runGPUTop(ctx, cancel)
+	go manager.Run(ctx, cancel, sink)
 
-	// Expose metrics endpoint
-	http.Handle("/metrics", promhttp.Handler())
+	// Tick the heartbeat independently of GPU data
+	go runHeartbeat(ctx, heartbeatInterval)
 
-	// Start HTTP server in a goroutine
-	server := &http.Server{Addr: fmt.Sprintf(":%d", *port)}
+	// Flag stalled collection, which the GPU gauges don't show
+	go runHealthCheck(ctx, manager.health(), staleAfter, healthCheckInterval)
+
+	// Watch for goroutine leaks from restarts and push backends
+	if cfg.collectInternal {
+		watchdog := &goroutineWatchdog{threshold: cfg.goroutineWarn}
+		go watchdog.Run(ctx, goroutineSampleInterval)
+	}
+
+	// Dump a snapshot on SIGUSR1 for grabbing state without HTTP
+	if cfg.dumpPath != "" {
+		go handleDumpSignal(ctx, reg, cfg.dumpPath)
+	}
+
+	// Serving errors stop the exporter and are returned once it has shut
+	// down
+	serveErr := make(chan error, 2)
+
+	// Start HTTP servers in goroutines
+	mux := newMetricsMux(reg, cfg.metricsPath, cfg.ui)
+	mux.Handle("/healthz", livenessHandler(ctx))
+	mux.Handle("/readyz", readinessHandler(manager.health()))
+	mux.Handle("/{$}", landingHandler(collector, cfg.metricsPath, cfg.ui))
+	if cfg.pprof {
+		handlePprof(mux)
+	}
+	server.Handler = mux
+	if cfg.authUser != "" {
+		// Metrics, /metadata, /ui and the landing page need credentials,
+		// while health probes stay open
+		server.Handler = basicAuthExcept(server.Handler, cfg.authUser, cfg.authPass, "/healthz", "/readyz")
+	}
 	go func() {
-		log.Printf("Intel GPU Exporter starting on %s/metrics\n", server.Addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("HTTP server error: %v", err)
+		if cfg.unixSocket != "" {
+			slog.Info("Intel GPU Exporter serving metrics", "path", cfg.metricsPath, "socket", cfg.unixSocket)
+		} else {
+			slog.Info("Intel GPU Exporter serving metrics", "path", cfg.metricsPath, "address", server.Addr)
+		}
+		var err error
+		if tlsConfig != nil {
+			// Certificates come from TLSConfig.GetCertificate
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			serveErr <- fmt.Errorf("%w: %w", errListen, err)
 			cancel()
 		}
 	}()
 
+	if debugServer != nil {
+		go func() {
+			slog.Info("Serving /debug/pprof/", "address", debugServer.Addr)
+			if err := debugServer.Serve(debugListener); err != nil && err != http.ErrServerClosed {
+				serveErr <- fmt.Errorf("%w: debug server: %w", errListen, err)
+				cancel()
+			}
+		}()
+	}
+
 	// Wait for context cancellation
 	<-ctx.Done()
-	log.Println("Context cancelled, shutting down...")
+	slog.Info("Context cancelled, shutting down", "event", "shutdown")
 
-	// Gracefully shutdown the HTTP server
+	// Gracefully shutdown the HTTP servers
 	if err := server.Shutdown(context.Background()); err != nil {
-		log.Printf("Error shutting down server: %v", err)
+		slog.Error("Error shutting down server", "err", err)
+	}
+	if debugServer != nil {
+		if err := debugServer.Shutdown(context.Background()); err != nil {
+			slog.Error("Error shutting down debug server", "err", err)
+		}
 	}
 
-	log.Println("Intel GPU Exporter stopped")
-}
+	drains.Wait()
+	slog.Info("Intel GPU Exporter stopped", "event", "stopped")
 
-func runGPUTop(ctx context.Context, cancel context.CancelFunc) {
-	cmd := exec.CommandContext(ctx, "intel_gpu_top", "-c")
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Printf("Error creating stdout pipe: %v", err)
-		cancel() // Cancel context on failure
-		return
+	select {
+	case err := <-serveErr:
+		return err
+	default:
+		return nil
 	}
+}
+
+// collectOptions configures how runGPUTop collects samples.
+type collectOptions struct {
+	// device is the device label samples are tagged with, as returned by
+	// deviceLabelValue.
+	device string
+	// interval is the intel_gpu_top sampling interval.
+	interval time.Duration
+	// format is the intel_gpu_top output format, formatCSV or formatJSON.
+	// The zero value reads CSV.
+	format string
+	// mapping overrides CSV header auto-detection.
+	mapping igtparse.ColumnMapping
+	// idle slows sampling down while the GPU is idle; nil disables it.
+	idle *idleDetector
+	// adaptive speeds sampling up under load and slows it down otherwise,
+	// replacing interval; nil disables it.
+	adaptive *adaptiveSampler
+	// weighted tracks the busy-weighted average frequency published as
+	// FreqBusyWeightedGauge; nil disables it.
+	weighted *busyWeightedFreq
+	// freqBins are the bands FreqResidencyCounter accumulates time in; nil
+	// disables it.
+	freqBins freqBins
+	// health records when records were parsed; nil disables it.
+	health *collectorHealth
+	// backoff paces relaunching intel_gpu_top when it exits; nil stops
+	// collection instead, as when reading a stream that can't be reopened.
+	backoff *restartBackoff
+	// readTimeout is how long intel_gpu_top may go without writing a
+	// record before it is killed, at least twice the sampling interval; 0
+	// waits forever, as for a stream that can't be reopened.
+	readTimeout time.Duration
+	// logger receives lifecycle events, each carrying an "event" key so
+	// log-based dashboards can follow collector health; nil uses
+	// slog.Default().
+	logger *slog.Logger
+}
 
-	if err := cmd.Start(); err != nil {
-		log.Printf("Error starting intel_gpu_top: %v", err)
-		cancel() // Cancel context on failure
-		return
+// events returns the logger lifecycle events are emitted to.
+func (o collectOptions) events() *slog.Logger {
+	if o.logger != nil {
+		return o.logger
 	}
+	return slog.Default()
+}
 
-	// Monitor context cancellation in a separate goroutine
-	go func() {
-		<-ctx.Done()
-		if cmd.Process != nil {
-			log.Println("Terminating intel_gpu_top process due to context cancellation")
-			cmd.Process.Kill()
+// runGPUTop collects metrics until ctx is cancelled, relaunching
+// intel_gpu_top whenever the sampling interval changes or, with
+// opts.backoff, when it exits. Otherwise its exit cancels ctx, stopping the
+// exporter.
+func runGPUTop(ctx context.Context, cancel context.CancelFunc, runner gpuTopRunner, sink MetricsSink, opts collectOptions) {
+	defer cancel() // Cancel context on command failure
+
+	// Previous sample, used for metrics derived from consecutive samples
+	var prev *IntelTopStats
+
+	for {
+		restart, sampled := collect(ctx, runner, sink, opts, opts.samplingInterval(), &prev)
+		if ctx.Err() != nil {
+			opts.events().Info("Stopping metrics collection", "event", "shutdown")
+			return
 		}
-	}()
 
-	for stats := range readMetrics(stdout) {
+		if restart {
+			reason := "idle"
+			if opts.adaptive != nil {
+				reason = "load"
+			}
+			opts.events().Info("Restarting intel_gpu_top", "event", "restart", "reason", reason, "interval", opts.samplingInterval())
+			continue
+		}
+		if opts.backoff == nil {
+			return
+		}
+
+		delay := opts.backoff.Delay(sampled)
+		opts.events().Info("Restarting intel_gpu_top", "event", "restart", "reason", "exit", "delay", delay)
 		select {
 		case <-ctx.Done():
-			log.Println("Context cancelled, stopping metrics collection")
+			opts.events().Info("Stopping metrics collection", "event", "shutdown")
 			return
-		default:
-			updatePrometheusMetrics(stats)
+		case <-time.After(delay):
 		}
+		GPUTopRestartsCounter.Inc()
 	}
+}
 
-	cancel() // Cancel context on command failure
+// forDevice returns a copy of o collecting from device, with its own
+// sampling, restart and health state. It must be called before collection
+// starts.
+func (o collectOptions) forDevice(device string) collectOptions {
+	o.device = device
+	if o.idle != nil {
+		idle := *o.idle
+		o.idle = &idle
+	}
+	if o.adaptive != nil {
+		adaptive := *o.adaptive
+		o.adaptive = &adaptive
+	}
+	if o.backoff != nil {
+		backoff := *o.backoff
+		o.backoff = &backoff
+	}
+	o.health = &collectorHealth{}
+	if device != "" {
+		o.logger = o.events().With("device", device)
+	}
+	return o
 }
 
-func readMetrics(output io.Reader) iter.Seq[IntelTopStats] {
-	return func(yield func(IntelTopStats) bool) {
-		r := csv.NewReader(output)
-
-		for {
-			record, err := r.Read()
-			if err != nil && errors.Is(err, io.EOF) {
-				break
-			} else if err != nil {
-				log.Printf("Error reading CSV: %v", err)
-				break
-			}
+// longestInterval returns the slowest sampling interval intel_gpu_top may
+// run at.
+func (o collectOptions) longestInterval() time.Duration {
+	switch {
+	case o.adaptive != nil:
+		return max(o.adaptive.busyInterval, o.adaptive.idleInterval)
+	case o.idle != nil:
+		return max(o.interval, o.idle.interval)
+	default:
+		return o.interval
+	}
+}
 
-			if slices.Contains(record, "Freq MHz req") {
-				// Skip header row
-				continue
-			}
+// samplingInterval returns the interval intel_gpu_top should currently run
+// at.
+func (o collectOptions) samplingInterval() time.Duration {
+	switch {
+	case o.adaptive != nil:
+		return o.adaptive.Interval()
+	case o.idle.Idle():
+		return o.idle.interval
+	default:
+		return o.interval
+	}
+}
+
+// collect runs intel_gpu_top once at interval, publishing samples until it
+// exits or the sampling interval should change with the idle or load state.
+// It reports whether the interval changed and whether any sample was
+// published.
+func collect(ctx context.Context, runner gpuTopRunner, sink MetricsSink, opts collectOptions, interval time.Duration, prev **IntelTopStats) (restart, sampled bool) {
+	events := opts.events()
+
+	// Cancelled to stop this run early without affecting ctx
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
 
-			stats, err := parseMetric(record)
+	stdout, err := runner.Start(runCtx, interval)
+	if err != nil {
+		// A start interrupted by shutdown isn't a failure
+		if ctx.Err() != nil {
+			return false, false
+		}
+		reason := gpuTopFailure(err)
+		setGPUTopFailure(opts.device, reason)
+		logGPUTopFailure(events, "Error starting intel_gpu_top", reason, err)
+		return false, false
+	}
+	events.Info("Started intel_gpu_top", "event", "start", "interval", interval)
+
+	first := true
+	samples := readMetrics(runCtx, stdout, opts.mapping)
+	if opts.format == formatJSON {
+		samples = readMetricsJSON(runCtx, stdout)
+	}
+
+	// Read in the background so a quiet intel_gpu_top can be timed out
+	records := make(chan IntelTopStats)
+	go func() {
+		defer close(records)
+		for stats, err := range samples {
 			if err != nil {
-				if errors.Is(err, io.ErrUnexpectedEOF) {
-					// Incomplete record, skip
-					log.Printf("Incomplete record, skipping: %v", record)
-					continue
-				} else {
-					log.Printf("Error parsing metrics: %v", err)
-					return
-				}
+				logRecordError(events, err)
+				continue
 			}
-
-			if !yield(stats) {
+			recordParseOutcome(true)
+			select {
+			case records <- stats:
+			case <-runCtx.Done():
 				return
 			}
 		}
+	}()
+
+	// An intel_gpu_top that stops writing without exiting, as seen after
+	// suspend and resume, is killed so it gets relaunched
+	var watchdog *time.Timer
+	var quiet <-chan time.Time
+	timeout := max(opts.readTimeout, 2*interval)
+	if opts.readTimeout > 0 {
+		watchdog = time.NewTimer(timeout)
+		defer watchdog.Stop()
+		quiet = watchdog.C
+	}
+
+read:
+	for {
+		var stats IntelTopStats
+		select {
+		case s, ok := <-records:
+			if !ok {
+				break read
+			}
+			stats = s
+		case <-quiet:
+			events.Warn("No record from intel_gpu_top within the read timeout, killing it", "event", "stall", "timeout", timeout)
+			stop()
+			break read
+		case <-ctx.Done():
+			break read
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if watchdog != nil {
+			watchdog.Reset(timeout)
+		}
+		if first {
+			events.Info("Received first sample", "event", "first_sample")
+			setGPUTopFailure(opts.device, "")
+			first = false
+		}
+		stats.Device = opts.device
+		if opts.health != nil {
+			opts.health.Observe(time.Now())
+		}
+		sink.Update(stats, *prev)
+		*prev = &stats
+		if opts.weighted != nil {
+			FreqBusyWeightedGauge.Set(opts.weighted.Observe(stats, time.Now()))
+		}
+		observeIRQ(stats, interval)
+		if opts.freqBins != nil {
+			opts.freqBins.Observe(stats, interval)
+		}
+
+		if opts.idle != nil && opts.idle.Observe(stats, time.Now()) {
+			restart = true
+			stop()
+			break
+		}
+		if opts.adaptive != nil && opts.adaptive.Observe(stats) {
+			restart = true
+			stop()
+			break
+		}
+	}
+
+	// The stream may end while intel_gpu_top is still running. Closing our
+	// end makes its next write fail so it exits, then reap it so it doesn't
+	// linger as a zombie.
+	stdout.Close()
+	err = runner.Wait()
+	if opts.health != nil {
+		opts.health.Exited()
+	}
+	switch {
+	case restart:
+	case err != nil && ctx.Err() == nil:
+		reason := gpuTopFailure(err)
+		setGPUTopFailure(opts.device, reason)
+		logGPUTopFailure(events, "intel_gpu_top exited", reason, err)
+	case ctx.Err() == nil:
+		setGPUTopFailure(opts.device, failureExited)
+		events.Info("intel_gpu_top exited", "event", "exit")
+	default:
+		events.Info("intel_gpu_top exited", "event", "exit")
+	}
+
+	return restart, !first
+}
+
+func runHeartbeat(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			HeartbeatCounter.Inc()
+		}
+	}
+}
+
+// readMetrics parses intel_gpu_top CSV output with igtparse until ctx is
+// done, with mapping overriding header auto-detection. Records that can't be
+// parsed are yielded as errors; parsing carries on after those wrapping
+// igtparse.ErrTruncated or igtparse.ErrImplausibleValue.
+func readMetrics(ctx context.Context, output io.Reader, mapping igtparse.ColumnMapping) iter.Seq2[IntelTopStats, error] {
+	p := &igtparse.Parser{
+		Mapping: mapping,
+		Engines: gpuEngines,
+		Limits:  sampleLimits,
+	}
+	p.OnHeader = func(header []string) {
+		HeaderReparsedCounter.Inc()
+		warnUnmappedColumns(slog.Default(), p, header)
 	}
+	return parseUntilDone(ctx, output, p.Parse)
 }
 
-func updateEngineMetric(stats *IntelTopStats, engineName, metricType string, value float64) {
-	engine, ok := stats.Engine[engineName]
-	if !ok {
-		engine = IntelEngine{}
+// unmappedWarned holds the names of the columns warned about as unmapped,
+// so a header intel_gpu_top reprints, or prints again once restarted, doesn't
+// repeat the warning.
+var unmappedWarned sync.Map
+
+// warnUnmappedColumns warns logger about each column of header that p
+// ignores as unrecognised, the first time it is seen.
+func warnUnmappedColumns(logger *slog.Logger, p *igtparse.Parser, header []string) {
+	for _, name := range p.UnmappedColumns(header) {
+		if _, seen := unmappedWarned.LoadOrStore(name, true); !seen {
+			logger.Warn("Ignoring unrecognised column, map it with -column-map", "name", name)
+		}
 	}
+}
+
+// parseUntilDone returns the samples parse reads from output, ending once ctx
+// is done. Each read checks ctx first, and a read already blocked is
+// interrupted by closing output when it is an io.Closer. The error parse
+// sees as a result isn't yielded.
+func parseUntilDone(ctx context.Context, output io.Reader, parse func(io.Reader) iter.Seq2[IntelTopStats, error]) iter.Seq2[IntelTopStats, error] {
+	return func(yield func(IntelTopStats, error) bool) {
+		if closer, ok := output.(io.Closer); ok {
+			stop := context.AfterFunc(ctx, func() { closer.Close() })
+			defer stop()
+		}
 
-	switch metricType {
-	case "busy":
-		engine.BusyPercent = value
-	case "sema":
-		engine.SemaPercent = value
-	case "wait":
-		engine.WaitPercent = value
+		for stats, err := range parse(contextReader{ctx: ctx, r: output}) {
+			if ctx.Err() != nil || !yield(stats, err) {
+				return
+			}
+		}
 	}
+}
 
-	stats.Engine[engineName] = engine
+// contextReader is an io.Reader failing with ctx's error once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
 }
 
-func parseMetric(record []string) (IntelTopStats, error) {
-	if len(record) != 16 {
-		log.Printf("Unexpected number of fields: got %d, want 16", len(record))
-		return IntelTopStats{}, io.ErrUnexpectedEOF
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
 	}
+	return r.r.Read(p)
+}
 
-	var stats IntelTopStats
-	stats.Engine = make(map[string]IntelEngine)
+// logRecordError logs err, yielded by readMetrics or readMetricsJSON for a
+// record that couldn't be parsed, to logger and counts the failed record by
+// reason.
+func logRecordError(logger *slog.Logger, err error) {
+	recordParseOutcome(false)
 
-	for i, field := range record {
-		var value float64
-		_, err := fmt.Sscanf(field, "%f", &value)
-		if err != nil {
-			return IntelTopStats{}, fmt.Errorf("error parsing field %d (%s): %v", i, field, err)
-		}
-
-		// ,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
-		switch IntelEngineType(i) {
-		case FreqMHzReq:
-			stats.FreqMhzRequested = value
-		case FreqMHzAct:
-			stats.FreqMhzActual = value
-		case IRQPerSec:
-			stats.IRQPerSec = value
-		case Rc6Percent:
-			stats.Rc6Percent = value
-		case RCSPercentBusy:
-			updateEngineMetric(&stats, "RCS", "busy", value)
-		case RCSPercentSema:
-			updateEngineMetric(&stats, "RCS", "sema", value)
-		case RCSPercentWait:
-			updateEngineMetric(&stats, "RCS", "wait", value)
-		case BCSPercentBusy:
-			updateEngineMetric(&stats, "BCS", "busy", value)
-		case BCSPercentSema:
-			updateEngineMetric(&stats, "BCS", "sema", value)
-		case BCSPercentWait:
-			updateEngineMetric(&stats, "BCS", "wait", value)
-		case VCSPercentBusy:
-			updateEngineMetric(&stats, "VCS", "busy", value)
-		case VCSPercentSema:
-			updateEngineMetric(&stats, "VCS", "sema", value)
-		case VCSPercentWait:
-			updateEngineMetric(&stats, "VCS", "wait", value)
-		case VECSPercentBusy:
-			updateEngineMetric(&stats, "VECS", "busy", value)
-		case VECSPercentSema:
-			updateEngineMetric(&stats, "VECS", "sema", value)
-		case VECSPercentWait:
-			updateEngineMetric(&stats, "VECS", "wait", value)
-		default:
-			return IntelTopStats{}, fmt.Errorf("unexpected field index: %d", i)
-		}
-	}
-
-	return stats, nil
+	args := []any{"err", err}
+	var recordErr *igtparse.RecordError
+	if errors.As(err, &recordErr) {
+		args = []any{"line", recordErr.Line, "record", recordErr.Record, "err", recordErr.Err}
+	}
+	switch {
+	case errors.Is(err, igtparse.ErrTruncated):
+		logger.Warn("Incomplete record, skipping", args...)
+		ParseErrorsCounter.WithLabelValues("truncated").Inc()
+	case errors.Is(err, igtparse.ErrImplausibleValue):
+		logger.Warn("Skipping record", args...)
+		ParseErrorsCounter.WithLabelValues("out_of_bounds").Inc()
+	default:
+		logger.Error("Error parsing metrics", args...)
+		ParseErrorsCounter.WithLabelValues("invalid").Inc()
+	}
 }
 
-func updatePrometheusMetrics(stats IntelTopStats) {
-	FreqMhzRequested.Set(stats.FreqMhzRequested)
-	FreqMhzActual.Set(stats.FreqMhzActual)
-	IRQPerSecGauge.Set(stats.IRQPerSec)
-	Rc6PercentGauge.Set(stats.Rc6Percent)
+// gpuBusy returns the busy percentage of the busiest engine, as the GPU is
+// busy whenever any of its engines is.
+func gpuBusy(stats IntelTopStats) float64 {
+	var busy float64
+	for _, engine := range stats.Engine {
+		busy = max(busy, engine.BusyPercent)
+	}
+	return busy
+}
 
-	for name, engine := range stats.Engine {
-		EngineGauge.WithLabelValues(name, "busy").Set(engine.BusyPercent)
-		EngineGauge.WithLabelValues(name, "sema").Set(engine.SemaPercent)
-		EngineGauge.WithLabelValues(name, "wait").Set(engine.WaitPercent)
+// semaWaitRatio returns the fraction of engine's stall time spent on
+// semaphores, or 0 when the engine isn't stalled at all.
+func semaWaitRatio(engine IntelEngine) float64 {
+	stalled := engine.SemaPercent + engine.WaitPercent
+	if stalled <= 0 {
+		return 0
 	}
+	return engine.SemaPercent / stalled
+}
+
+// occupancy returns how much of the time engine was busy or stalled. Some
+// hardware excludes semaphore and wait time from busy, so the sum can
+// exceed a fully occupied engine and is capped at fullScale, the value of a
+// fully occupied engine.
+func occupancy(engine IntelEngine, fullScale float64) float64 {
+	return min(fullScale, engine.BusyPercent+engine.SemaPercent+engine.WaitPercent)
 }
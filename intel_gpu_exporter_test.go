@@ -1,79 +1,33 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"log/slog"
+	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
-)
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
-func TestParseMetric(t *testing.T) {
-	tests := []struct {
-		name      string
-		record    []string
-		expected  IntelTopStats
-		expectErr bool
-		errMsg    string
-	}{
-		{
-			name: "valid input",
-			// RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
-			record: []string{"1000.123", "95.1230", "500.23", "80.5", "3.2", "12.3", "13.2", "23.5", "23.3", "12.2", "10.3", "6.3", "5.5", "90.1", "12.3", "10.2"},
-			expected: IntelTopStats{
-				FreqMhzRequested: 1000.123,
-				FreqMhzActual:    95.1230,
-				IRQPerSec:        500.23,
-				Rc6Percent:       80.5,
-				Engine: map[string]IntelEngine{
-					"RCS":  {BusyPercent: 3.2, SemaPercent: 12.3, WaitPercent: 13.2},
-					"BCS":  {BusyPercent: 23.5, SemaPercent: 23.3, WaitPercent: 12.2},
-					"VCS":  {BusyPercent: 10.3, SemaPercent: 6.3, WaitPercent: 5.5},
-					"VECS": {BusyPercent: 90.1, SemaPercent: 12.3, WaitPercent: 10.2},
-				},
-			},
-			expectErr: false,
-		},
-		{
-			name:      "InvalidNumberOfFields",
-			record:    []string{"1000", "950"}, // too few fields
-			expectErr: true,
-			errMsg:    "unexpected EOF",
-		},
-		{
-			name:      "NonNumericField",
-			record:    []string{"1000", "abc", "500", "80.5", "3.2", "0.0", "0.0", "0.0", "0.0", "0.0", "0.0", "0.0", "0.0", "0.0", "0.0", "0.0"},
-			expectErr: true,
-			errMsg:    `error parsing field 1 \(abc\): .*`,
-		},
-		{
-			name:      "EmptyInput",
-			record:    []string{},
-			expectErr: true,
-			errMsg:    "unexpected EOF",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			c := qt.New(t)
-			s, err := parseMetric(tt.record)
-			if tt.expectErr {
-				c.Assert(err, qt.ErrorMatches, tt.errMsg)
-			} else {
-				c.Assert(err, qt.IsNil)
-				c.Assert(s, qt.DeepEquals, tt.expected)
-			}
-		})
-	}
-}
+	"github.com/mikeodr/intel-gpu-exporter-go/pkg/igtparse"
+)
 
 func TestReadMetrics(t *testing.T) {
 	c := qt.New(t)
 
 	tests := []struct {
-		name        string
-		input       string
-		expected    []IntelTopStats
+		name     string
+		input    string
+		expected []IntelTopStats
+		// errs is the number of records yielded as errors.
+		errs        int
 		description string
 	}{
 		// Valid data tests
@@ -96,6 +50,29 @@ func TestReadMetrics(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "ArcEightEngines",
+			input: `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS/0 %,RCS/0 se,RCS/0 wa,BCS/0 %,BCS/0 se,BCS/0 wa,VCS/0 %,VCS/0 se,VCS/0 wa,VCS/1 %,VCS/1 se,VCS/1 wa,VECS/0 %,VECS/0 se,VECS/0 wa,VECS/1 %,VECS/1 se,VECS/1 wa,CCS/0 %,CCS/0 se,CCS/0 wa,CCS/1 %,CCS/1 se,CCS/1 wa
+2050.0,2000.0,1830.0,12.3,64.0,0.0,1.5,0.0,0.0,0.0,42.0,0.0,0.0,38.5,0.0,0.0,3.0,0.0,0.0,0.0,0.0,0.0,20.0,0.2,0.0,5.5,0.0,0.1`,
+			expected: []IntelTopStats{
+				{
+					FreqMhzRequested: 2050.0,
+					FreqMhzActual:    2000.0,
+					IRQPerSec:        1830.0,
+					Rc6Percent:       12.3,
+					Engine: map[string]IntelEngine{
+						"RCS":    {BusyPercent: 64.0, WaitPercent: 1.5},
+						"BCS":    {},
+						"VCS/0":  {BusyPercent: 42.0},
+						"VCS/1":  {BusyPercent: 38.5},
+						"VECS/0": {BusyPercent: 3.0},
+						"VECS/1": {},
+						"CCS/0":  {BusyPercent: 20.0, SemaPercent: 0.2},
+						"CCS/1":  {BusyPercent: 5.5, WaitPercent: 0.1},
+					},
+				},
+			},
+		},
 		{
 			name: "MultipleRecords",
 			input: `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
@@ -128,6 +105,25 @@ func TestReadMetrics(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:  "CRLFLineEndings",
+			input: "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa\r\n1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9\r\n",
+			expected: []IntelTopStats{
+				{
+					FreqMhzRequested: 1200.0,
+					FreqMhzActual:    1150.0,
+					IRQPerSec:        500.0,
+					Rc6Percent:       85.5,
+					Engine: map[string]IntelEngine{
+						"RCS":  {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+						"BCS":  {BusyPercent: 15.4, SemaPercent: 7.8, WaitPercent: 3.2},
+						"VCS":  {BusyPercent: 8.9, SemaPercent: 4.5, WaitPercent: 1.8},
+						"VECS": {BusyPercent: 12.7, SemaPercent: 6.3, WaitPercent: 2.9},
+					},
+				},
+			},
+			description: "Should parse the last engine's wait value despite CRLF line endings",
+		},
 		// Edge case tests
 		{
 			name:        "HeaderOnly",
@@ -147,6 +143,7 @@ func TestReadMetrics(t *testing.T) {
 			input: `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
 1200.0,1150.0,500.0,85.5,10.2`,
 			expected:    []IntelTopStats{},
+			errs:        1,
 			description: "Should skip incomplete records",
 		},
 		{
@@ -154,6 +151,7 @@ func TestReadMetrics(t *testing.T) {
 			input: `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
 abc,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9`,
 			expected:    []IntelTopStats{},
+			errs:        1,
 			description: "Should skip records with invalid number format",
 		},
 		{
@@ -176,13 +174,173 @@ abc,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9
 					},
 				},
 			},
+			errs:        1,
 			description: "Should process valid records and skip invalid ones",
 		},
+		{
+			name: "FinalRecordWithoutNewline",
+			input: `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9
+1300.0,1250.0,600.0,90.0,20.5,10.2,4.6,25.8,15.6,6.4,18.8,9.0,3.6,25.4,12.6,5.8`,
+			expected: []IntelTopStats{
+				{
+					FreqMhzRequested: 1200.0,
+					FreqMhzActual:    1150.0,
+					IRQPerSec:        500.0,
+					Rc6Percent:       85.5,
+					Engine: map[string]IntelEngine{
+						"RCS":  {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+						"BCS":  {BusyPercent: 15.4, SemaPercent: 7.8, WaitPercent: 3.2},
+						"VCS":  {BusyPercent: 8.9, SemaPercent: 4.5, WaitPercent: 1.8},
+						"VECS": {BusyPercent: 12.7, SemaPercent: 6.3, WaitPercent: 2.9},
+					},
+				},
+				{
+					FreqMhzRequested: 1300.0,
+					FreqMhzActual:    1250.0,
+					IRQPerSec:        600.0,
+					Rc6Percent:       90.0,
+					Engine: map[string]IntelEngine{
+						"RCS":  {BusyPercent: 20.5, SemaPercent: 10.2, WaitPercent: 4.6},
+						"BCS":  {BusyPercent: 25.8, SemaPercent: 15.6, WaitPercent: 6.4},
+						"VCS":  {BusyPercent: 18.8, SemaPercent: 9.0, WaitPercent: 3.6},
+						"VECS": {BusyPercent: 25.4, SemaPercent: 12.6, WaitPercent: 5.8},
+					},
+				},
+			},
+			description: "Should emit a complete final record lacking a trailing newline",
+		},
+		{
+			name: "TruncatedFinalRecord",
+			input: `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9
+1300.0,1250.0,600.0,90.0,20.5,10.2`,
+			expected: []IntelTopStats{
+				{
+					FreqMhzRequested: 1200.0,
+					FreqMhzActual:    1150.0,
+					IRQPerSec:        500.0,
+					Rc6Percent:       85.5,
+					Engine: map[string]IntelEngine{
+						"RCS":  {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+						"BCS":  {BusyPercent: 15.4, SemaPercent: 7.8, WaitPercent: 3.2},
+						"VCS":  {BusyPercent: 8.9, SemaPercent: 4.5, WaitPercent: 1.8},
+						"VECS": {BusyPercent: 12.7, SemaPercent: 6.3, WaitPercent: 2.9},
+					},
+				},
+			},
+			errs:        1,
+			description: "Should skip a final record cut short mid-line",
+		},
+		{
+			name: "TruncatedAfterSeparator",
+			input: `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9
+1300.0,1250.0,600.0,90.0,20.5,10.2,4.6,25.8,15.6,6.4,18.8,9.0,3.6,25.4,12.6,`,
+			expected: []IntelTopStats{
+				{
+					FreqMhzRequested: 1200.0,
+					FreqMhzActual:    1150.0,
+					IRQPerSec:        500.0,
+					Rc6Percent:       85.5,
+					Engine: map[string]IntelEngine{
+						"RCS":  {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+						"BCS":  {BusyPercent: 15.4, SemaPercent: 7.8, WaitPercent: 3.2},
+						"VCS":  {BusyPercent: 8.9, SemaPercent: 4.5, WaitPercent: 1.8},
+						"VECS": {BusyPercent: 12.7, SemaPercent: 6.3, WaitPercent: 2.9},
+					},
+				},
+			},
+			errs:        1,
+			description: "Should skip a final record cut short right after a separator",
+		},
+		{
+			name: "TruncatedRecordMidStream",
+			input: `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9
+1300.0,1250.0
+1300.0,1250.0,600.0,90.0,20.5,10.2,4.6,25.8,15.6,6.4,18.8,9.0,3.6,25.4,12.6,5.8
+`,
+			expected: []IntelTopStats{
+				{
+					FreqMhzRequested: 1200.0,
+					FreqMhzActual:    1150.0,
+					IRQPerSec:        500.0,
+					Rc6Percent:       85.5,
+					Engine: map[string]IntelEngine{
+						"RCS":  {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+						"BCS":  {BusyPercent: 15.4, SemaPercent: 7.8, WaitPercent: 3.2},
+						"VCS":  {BusyPercent: 8.9, SemaPercent: 4.5, WaitPercent: 1.8},
+						"VECS": {BusyPercent: 12.7, SemaPercent: 6.3, WaitPercent: 2.9},
+					},
+				},
+				{
+					FreqMhzRequested: 1300.0,
+					FreqMhzActual:    1250.0,
+					IRQPerSec:        600.0,
+					Rc6Percent:       90.0,
+					Engine: map[string]IntelEngine{
+						"RCS":  {BusyPercent: 20.5, SemaPercent: 10.2, WaitPercent: 4.6},
+						"BCS":  {BusyPercent: 25.8, SemaPercent: 15.6, WaitPercent: 6.4},
+						"VCS":  {BusyPercent: 18.8, SemaPercent: 9.0, WaitPercent: 3.6},
+						"VECS": {BusyPercent: 25.4, SemaPercent: 12.6, WaitPercent: 5.8},
+					},
+				},
+			},
+			errs:        1,
+			description: "Should skip a short record without ending the stream",
+		},
+		{
+			name: "TruncatedInQuotedField",
+			input: `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9
+1300.0,1250.0,"600`,
+			expected: []IntelTopStats{
+				{
+					FreqMhzRequested: 1200.0,
+					FreqMhzActual:    1150.0,
+					IRQPerSec:        500.0,
+					Rc6Percent:       85.5,
+					Engine: map[string]IntelEngine{
+						"RCS":  {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+						"BCS":  {BusyPercent: 15.4, SemaPercent: 7.8, WaitPercent: 3.2},
+						"VCS":  {BusyPercent: 8.9, SemaPercent: 4.5, WaitPercent: 1.8},
+						"VECS": {BusyPercent: 12.7, SemaPercent: 6.3, WaitPercent: 2.9},
+					},
+				},
+			},
+			errs:        1,
+			description: "Should skip a final record that is malformed CSV",
+		},
+		{
+			name: "MalformedRecordMidStream",
+			input: `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
+1300.0,12"50.0,600.0
+1300.0,1250.0,600.0,90.0,20.5,10.2,4.6,25.8,15.6,6.4,18.8,9.0,3.6,25.4,12.6,5.8
+`,
+			expected: []IntelTopStats{
+				{
+					FreqMhzRequested: 1300.0,
+					FreqMhzActual:    1250.0,
+					IRQPerSec:        600.0,
+					Rc6Percent:       90.0,
+					Engine: map[string]IntelEngine{
+						"RCS":  {BusyPercent: 20.5, SemaPercent: 10.2, WaitPercent: 4.6},
+						"BCS":  {BusyPercent: 25.8, SemaPercent: 15.6, WaitPercent: 6.4},
+						"VCS":  {BusyPercent: 18.8, SemaPercent: 9.0, WaitPercent: 3.6},
+						"VECS": {BusyPercent: 25.4, SemaPercent: 12.6, WaitPercent: 5.8},
+					},
+				},
+			},
+			errs:        1,
+			description: "Should skip malformed CSV without ending the stream",
+		},
 		{
 			name: "IncompleteRecords",
 			input: `
 			1200.0,1150.0,500.0,85.5,10.2`,
 			expected:    []IntelTopStats{},
+			errs:        1,
 			description: "Should skip incomplete records and records with leading newline",
 		},
 	}
@@ -192,9 +350,15 @@ abc,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9
 			reader := strings.NewReader(tt.input)
 			results := make([]IntelTopStats, 0)
 
-			for stats := range readMetrics(reader) {
+			var errs int
+			for stats, err := range readMetrics(context.Background(), reader, nil) {
+				if err != nil {
+					errs++
+					continue
+				}
 				results = append(results, stats)
 			}
+			c.Assert(errs, qt.Equals, tt.errs, qt.Commentf(tt.description))
 
 			// Determine expected count
 			expectedCount := len(tt.expected)
@@ -207,6 +371,29 @@ abc,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9
 	}
 }
 
+func TestWarnUnmappedColumns(t *testing.T) {
+	c := qt.New(t)
+	unmappedWarned.Clear()
+	c.Cleanup(unmappedWarned.Clear)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	p := &igtparse.Parser{}
+	header := []string{"Freq MHz req", "RC6 %", "Mystery", "Render busy"}
+
+	// A reprinted header, or one from a restarted intel_gpu_top, isn't
+	// warned about again
+	for range 3 {
+		warnUnmappedColumns(logger, p, header)
+	}
+	warnUnmappedColumns(logger, p, append(header, "Other"))
+
+	c.Assert(strings.Count(buf.String(), "Ignoring unrecognised column"), qt.Equals, 3)
+	for _, name := range []string{"Mystery", `"Render busy"`, "Other"} {
+		c.Assert(buf.String(), qt.Contains, "name="+name)
+	}
+}
+
 func TestReadMetricsEarlyBreak(t *testing.T) {
 	c := qt.New(t)
 
@@ -219,7 +406,8 @@ func TestReadMetricsEarlyBreak(t *testing.T) {
 	results := make([]IntelTopStats, 0)
 	count := 0
 
-	for stats := range readMetrics(reader) {
+	for stats, err := range readMetrics(context.Background(), reader, nil) {
+		c.Assert(err, qt.IsNil)
 		results = append(results, stats)
 		count++
 		if count >= 2 {
@@ -232,6 +420,537 @@ func TestReadMetricsEarlyBreak(t *testing.T) {
 	c.Assert(results[1].FreqMhzRequested, qt.Equals, 1300.0)
 }
 
+func TestReadMetricsCancel(t *testing.T) {
+	header := "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa\n"
+	record := "1200.0,1150.0,500.0,85.5,10.2,5.1,2.3\n"
+
+	tests := []struct {
+		name string
+		read func(ctx context.Context, output io.Reader) iter.Seq2[IntelTopStats, error]
+		// sample is written before the stream blocks.
+		sample string
+	}{
+		{
+			name: "CSV",
+			read: func(ctx context.Context, output io.Reader) iter.Seq2[IntelTopStats, error] {
+				return readMetrics(ctx, output, nil)
+			},
+			sample: header + record,
+		},
+		{
+			name:   "JSON",
+			read:   readMetricsJSON,
+			sample: `[{"frequency": {"actual": 1150}},`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			// A stream that stays open without writing, as a wedged
+			// intel_gpu_top does
+			pr, pw := io.Pipe()
+			defer pw.Close()
+			go pw.Write([]byte(tt.sample))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan int)
+			go func() {
+				var samples int
+				for _, err := range tt.read(ctx, pr) {
+					c.Check(err, qt.IsNil)
+					samples++
+					// The next read blocks until cancelled
+					cancel()
+				}
+				done <- samples
+			}()
+
+			select {
+			case samples := <-done:
+				c.Assert(samples, qt.Equals, 1)
+			case <-time.After(5 * time.Second):
+				c.Fatal("blocked read not interrupted by cancellation")
+			}
+		})
+	}
+
+	t.Run("AlreadyCancelled", func(t *testing.T) {
+		c := qt.New(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		for range readMetrics(ctx, strings.NewReader(header+record), nil) {
+			c.Fatal("sample read after cancellation")
+		}
+	})
+}
+
+func TestRunGPUTop(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name         string
+		runner       *fakeRunner
+		expectWaited bool
+	}{
+		{
+			name: "WaitsAfterStreamEnds",
+			runner: &fakeRunner{outputs: []string{`Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9`}},
+			expectWaited: true,
+		},
+		{
+			name:         "WaitsOnExitError",
+			runner:       &fakeRunner{waitErr: errors.New("exit status 1")},
+			expectWaited: true,
+		},
+		{
+			name:         "StartFailure",
+			runner:       &fakeRunner{startErr: errors.New("not found")},
+			expectWaited: false,
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			runGPUTop(ctx, cancel, tt.runner, prometheusSink{collector: newCollector(collectorOptions{})}, collectOptions{interval: time.Second})
+
+			c.Assert(tt.runner.waited, qt.Equals, tt.expectWaited)
+			c.Assert(ctx.Err(), qt.Equals, context.Canceled)
+		})
+	}
+}
+
+// eventRecorder is a slog.Handler collecting the "event" of each record.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *eventRecorder) Enabled(context.Context, slog.Level) bool { return true }
+func (r *eventRecorder) WithAttrs([]slog.Attr) slog.Handler       { return r }
+func (r *eventRecorder) WithGroup(string) slog.Handler            { return r }
+
+func (r *eventRecorder) Handle(_ context.Context, record slog.Record) error {
+	record.Attrs(func(a slog.Attr) bool {
+		if a.Key == "event" {
+			r.mu.Lock()
+			r.events = append(r.events, a.Value.String())
+			r.mu.Unlock()
+			return false
+		}
+		return true
+	})
+	return nil
+}
+
+func TestRunGPUTopEvents(t *testing.T) {
+	c := qt.New(t)
+
+	header := "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa\n"
+	busy := "1200.0,1150.0,500.0,10.0,80.0,0.0,0.0\n"
+	idle := "300.0,300.0,10.0,99.5,0.0,0.0,0.0\n"
+
+	tests := []struct {
+		name      string
+		runner    gpuTopRunner
+		idle      *idleDetector
+		cancelled bool
+		expected  []string
+	}{
+		{
+			name:     "StreamEnds",
+			runner:   &fakeRunner{outputs: []string{header + busy + busy}},
+			expected: []string{"start", "first_sample", "exit"},
+		},
+		{
+			name:     "Crash",
+			runner:   &fakeRunner{outputs: []string{header + busy}, waitErr: errors.New("exit status 1")},
+			expected: []string{"start", "first_sample", "crash"},
+		},
+		{
+			name:     "StartFailure",
+			runner:   &fakeRunner{startErr: errors.New("not found")},
+			expected: []string{"crash"},
+		},
+		{
+			name:     "IdleRestart",
+			runner:   &fakeRunner{outputs: []string{header + busy + idle, header + busy}},
+			idle:     &idleDetector{threshold: 1, after: 0, interval: 10 * time.Second},
+			expected: []string{"start", "first_sample", "restart", "start", "first_sample", "restart", "start", "exit"},
+		},
+		{
+			name:      "Shutdown",
+			runner:    &fakeRunner{outputs: []string{header + busy}, waitErr: errors.New("signal: killed")},
+			cancelled: true,
+			expected:  []string{"start", "exit", "shutdown"},
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelled {
+				cancel()
+			}
+
+			recorder := &eventRecorder{}
+			opts := collectOptions{interval: time.Second, idle: tt.idle, logger: slog.New(recorder)}
+			runGPUTop(ctx, cancel, tt.runner, discardSink{}, opts)
+
+			c.Assert(recorder.events, qt.DeepEquals, tt.expected)
+		})
+	}
+}
+
+func TestReadMetricsSanityBounds(t *testing.T) {
+	c := qt.New(t)
+
+	header := "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa\n"
+	valid := "1200.0,1150.0,500.0,85.5,10.2,5.1,2.3\n"
+	idle := "300.0,300.0,10.0,99.5,0.0,0.0,0.0\n"
+
+	tests := []struct {
+		name    string
+		limits  igtparse.Limits
+		record  string
+		skipped bool
+	}{
+		{name: "Plausible", limits: sampleLimits, record: valid},
+		{name: "AbsurdFrequency", limits: sampleLimits, record: "1200.0,9e15,500.0,85.5,10.2,5.1,2.3\n", skipped: true},
+		{name: "AbsurdIRQ", limits: sampleLimits, record: "1200.0,1150.0,1e300,85.5,10.2,5.1,2.3\n", skipped: true},
+		{name: "NegativeFrequency", limits: sampleLimits, record: "-5,1150.0,500.0,85.5,10.2,5.1,2.3\n", skipped: true},
+		{name: "TightLimit", limits: igtparse.Limits{FreqMhz: 1000}, record: valid, skipped: true},
+		{name: "Unbounded", limits: igtparse.Limits{}, record: "1200.0,9e15,1e300,85.5,10.2,5.1,2.3\n"},
+		{name: "ClampedBusy", limits: sampleLimits, record: "1200.0,1150.0,500.0,85.5,9999,5.1,2.3\n"},
+		{name: "StrictBusy", limits: igtparse.Limits{Strict: true}, record: "1200.0,1150.0,500.0,85.5,9999,5.1,2.3\n", skipped: true},
+		{name: "StrictNegativeRC6", limits: igtparse.Limits{Strict: true}, record: "1200.0,1150.0,500.0,-3,10.2,5.1,2.3\n", skipped: true},
+		{name: "StrictPlausible", limits: igtparse.Limits{Strict: true}, record: valid},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			defaults := sampleLimits
+			sampleLimits = tt.limits
+			c.Cleanup(func() { sampleLimits = defaults })
+
+			var results []IntelTopStats
+			var errs []error
+			for stats, err := range readMetrics(context.Background(), strings.NewReader(header+tt.record+idle), nil) {
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				results = append(results, stats)
+			}
+
+			// A rejected record never stops the following ones
+			want, skipped := 2, 0
+			if tt.skipped {
+				want, skipped = 1, 1
+			}
+			c.Assert(results, qt.HasLen, want)
+			c.Assert(errs, qt.HasLen, skipped)
+			for _, err := range errs {
+				c.Assert(err, qt.ErrorIs, igtparse.ErrImplausibleValue)
+			}
+		})
+	}
+}
+
+func TestReadMetricsPower(t *testing.T) {
+	c := qt.New(t)
+
+	// Power columns come and go with the header, e.g. on a machine
+	// without energy counters
+	input := `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,Power W gpu,Power W pkg,RCS %,RCS se,RCS wa
+1200.0,1150.0,500.0,85.5,4.25,11.5,10.2,5.1,2.3
+Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa
+300.0,300.0,10.0,99.5,0.0,0.0,0.0`
+
+	var results []IntelTopStats
+	for stats, err := range readMetrics(context.Background(), strings.NewReader(input), nil) {
+		c.Assert(err, qt.IsNil)
+		results = append(results, stats)
+	}
+
+	watts := func(w float64) *float64 { return &w }
+	c.Assert(results, qt.DeepEquals, []IntelTopStats{
+		{
+			FreqMhzRequested:  1200,
+			FreqMhzActual:     1150,
+			IRQPerSec:         500,
+			Rc6Percent:        85.5,
+			PowerGPUWatts:     watts(4.25),
+			PowerPackageWatts: watts(11.5),
+			Engine: map[string]IntelEngine{
+				"RCS": {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+			},
+		},
+		{
+			FreqMhzRequested: 300,
+			FreqMhzActual:    300,
+			IRQPerSec:        10,
+			Rc6Percent:       99.5,
+			Engine: map[string]IntelEngine{
+				"RCS": {},
+			},
+		},
+	})
+
+	// The gauges are only set while power is reported
+	col := newCollector(collectorOptions{namespace: defaultNamespace})
+	col.Update(results[0], nil)
+	c.Assert(collected(c, col, "intel_gpu_power_gpu_watts"), qt.DeepEquals, map[string]float64{`device=""`: 4.25})
+	c.Assert(collected(c, col, "intel_gpu_power_package_watts"), qt.DeepEquals, map[string]float64{`device=""`: 11.5})
+	col.Update(results[1], nil)
+	c.Assert(collected(c, col, "intel_gpu_power_gpu_watts"), qt.HasLen, 0)
+	c.Assert(collected(c, col, "intel_gpu_power_package_watts"), qt.HasLen, 0)
+}
+
+func TestReadMetricsHeaderReparsed(t *testing.T) {
+	c := qt.New(t)
+
+	input := `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3
+Freq MHz req,Freq MHz act,IRQ /s,RC6 %,VCS %,VCS se,VCS wa
+1300.0,1250.0,600.0,90.0,20.5,10.2,4.6`
+
+	before := testutil.ToFloat64(HeaderReparsedCounter)
+	var results []IntelTopStats
+	for stats, err := range readMetrics(context.Background(), strings.NewReader(input), nil) {
+		c.Assert(err, qt.IsNil)
+		results = append(results, stats)
+	}
+
+	c.Assert(testutil.ToFloat64(HeaderReparsedCounter)-before, qt.Equals, 2.0)
+	c.Assert(results, qt.HasLen, 2)
+	c.Assert(results[1].Engine, qt.DeepEquals, map[string]IntelEngine{
+		"VCS": {BusyPercent: 20.5, SemaPercent: 10.2, WaitPercent: 4.6},
+	})
+}
+
+func TestReadMetricsRepeatedHeader(t *testing.T) {
+	c := qt.New(t)
+
+	// A header block reprinted mid-stream with padding around its fields
+	input := "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa\n" +
+		"1200.0,1150.0,500.0,85.5,10.2,5.1,2.3\n" +
+		"Freq MHz req ,Freq MHz act ,IRQ /s ,RC6 % ,RCS % ,RCS se ,RCS wa  \n" +
+		"  Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa\t\n" +
+		"1300.0,1250.0,600.0,90.0,20.5,10.2,4.6\n"
+
+	before := parseErrorTotal()
+	var results []IntelTopStats
+	for stats, err := range readMetrics(context.Background(), strings.NewReader(input), nil) {
+		c.Assert(err, qt.IsNil)
+		results = append(results, stats)
+	}
+
+	c.Assert(parseErrorTotal(), qt.Equals, before)
+	c.Assert(results, qt.HasLen, 2)
+	c.Assert(results[1].FreqMhzActual, qt.Equals, 1250.0)
+	c.Assert(results[1].Engine, qt.DeepEquals, map[string]IntelEngine{
+		"RCS": {BusyPercent: 20.5, SemaPercent: 10.2, WaitPercent: 4.6},
+	})
+}
+
+func TestIRQDelta(t *testing.T) {
+	c := qt.New(t)
+
+	first := IntelTopStats{IRQPerSec: 500.0}
+	second := IntelTopStats{IRQPerSec: 1250.5}
+	col := newCollector(collectorOptions{namespace: defaultNamespace})
+
+	// There is no delta without a previous sample
+	col.Update(first, nil)
+	c.Assert(collected(c, col, "intel_gpu_irq_delta"), qt.HasLen, 0)
+
+	col.Update(second, &first)
+	c.Assert(collected(c, col, "intel_gpu_irq_delta"), qt.DeepEquals, map[string]float64{`device=""`: 750.5})
+
+	col.Update(first, &second)
+	c.Assert(collected(c, col, "intel_gpu_irq_delta"), qt.DeepEquals, map[string]float64{`device=""`: -750.5})
+}
+
+func TestFreqThrottleGap(t *testing.T) {
+	c := qt.New(t)
+
+	input := `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3
+1300.0,1300.0,600.0,90.0,20.5,10.2,4.6
+300.0,350.0,10.0,99.5,0.0,0.0,0.0
+1500.0,900.5,800.0,2.0,99.0,0.0,0.0`
+
+	// Positive while throttled, negative when running above the request
+	want := []float64{50, 0, -50, 599.5}
+
+	col := newCollector(collectorOptions{namespace: defaultNamespace})
+	var gaps []float64
+	for stats, err := range readMetrics(context.Background(), strings.NewReader(input), nil) {
+		c.Assert(err, qt.IsNil)
+		col.Update(stats, nil)
+		gaps = append(gaps, collected(c, col, "intel_gpu_freq_mhz_throttle_gap")[`device=""`])
+	}
+	c.Assert(gaps, qt.DeepEquals, want)
+}
+
+func TestSemaWaitRatio(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name   string
+		engine IntelEngine
+		want   float64
+	}{
+		{name: "SemaOnly", engine: IntelEngine{SemaPercent: 4}, want: 1},
+		{name: "WaitOnly", engine: IntelEngine{WaitPercent: 4}, want: 0},
+		{name: "Mixed", engine: IntelEngine{SemaPercent: 3, WaitPercent: 1}, want: 0.75},
+		{name: "NotStalled", engine: IntelEngine{BusyPercent: 50}, want: 0},
+	}
+
+	col := newCollector(collectorOptions{namespace: defaultNamespace})
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			stats := IntelTopStats{Engine: map[string]IntelEngine{"RatioTest": tt.engine}}
+			col.Update(stats, nil)
+			c.Assert(collected(c, col, "intel_gpu_engine_sema_wait_ratio"), qt.DeepEquals, map[string]float64{`device="",engine="RatioTest"`: tt.want})
+		})
+	}
+}
+
+func TestEngineOccupancy(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name     string
+		fraction bool
+		engine   IntelEngine
+		want     float64
+	}{
+		{name: "BusyOnly", engine: IntelEngine{BusyPercent: 40}, want: 40},
+		{name: "Sum", engine: IntelEngine{BusyPercent: 40, SemaPercent: 10, WaitPercent: 5}, want: 55},
+		{name: "CappedOverFull", engine: IntelEngine{BusyPercent: 70, SemaPercent: 25, WaitPercent: 20}, want: 100},
+		{name: "FractionCapped", fraction: true, engine: IntelEngine{BusyPercent: 0.7, SemaPercent: 0.25, WaitPercent: 0.2}, want: 1},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			col := newCollector(collectorOptions{namespace: defaultNamespace, fraction: tt.fraction, engineOccupancy: true})
+
+			stats := IntelTopStats{Engine: map[string]IntelEngine{"RCS": tt.engine}}
+			col.Update(stats, nil)
+
+			c.Assert(collected(c, col, "intel_gpu_engine_occupancy_percent"), qt.DeepEquals, map[string]float64{`device="",engine="RCS"`: tt.want})
+			// The raw busy gauge is left as reported
+			c.Assert(collected(c, col, "intel_gpu_engine_percent")[`device="",engine="RCS",type="busy"`], qt.Equals, tt.engine.BusyPercent)
+		})
+	}
+}
+
+func TestEngineInstanceLabels(t *testing.T) {
+	c := qt.New(t)
+
+	stats := IntelTopStats{Engine: map[string]IntelEngine{
+		"VCS/1": {BusyPercent: 40, SemaPercent: 3, WaitPercent: 1},
+		"RCS":   {BusyPercent: 60},
+	}}
+
+	c.Run("Combined", func(c *qt.C) {
+		col := newCollector(collectorOptions{namespace: defaultNamespace})
+		col.Update(stats, nil)
+		c.Assert(collected(c, col, "intel_gpu_engine_percent")[`device="",engine="VCS/1",type="busy"`], qt.Equals, 40.0)
+		c.Assert(collected(c, col, "intel_gpu_engine_sema_wait_ratio")[`device="",engine="VCS/1"`], qt.Equals, 0.75)
+	})
+
+	c.Run("Split", func(c *qt.C) {
+		col := newCollector(collectorOptions{namespace: defaultNamespace, splitEngineInstance: true})
+
+		col.Update(stats, nil)
+		engines := collected(c, col, "intel_gpu_engine_percent")
+		c.Assert(engines[`device="",engine="VCS",engine_instance="1",type="busy"`], qt.Equals, 40.0)
+		c.Assert(engines[`device="",engine="RCS",engine_instance="0",type="busy"`], qt.Equals, 60.0)
+		c.Assert(engines, qt.HasLen, 6)
+		c.Assert(collected(c, col, "intel_gpu_engine_sema_wait_ratio")[`device="",engine="VCS",engine_instance="1"`], qt.Equals, 0.75)
+	})
+
+	// intel_gpu_top -J numbers every engine of a multi-media GPU
+	c.Run("VideoInstances", func(c *qt.C) {
+		input := `{"engines": {"Video/0": {"busy": 30}, "Video/1": {"busy": 12.5}}}`
+		col := newCollector(collectorOptions{namespace: defaultNamespace, splitEngineInstance: true})
+		for stats, err := range readMetricsJSON(context.Background(), strings.NewReader(input)) {
+			c.Assert(err, qt.IsNil)
+			col.Update(stats, nil)
+		}
+
+		engines := collected(c, col, "intel_gpu_engine_percent")
+		c.Assert(engines[`device="",engine="VCS",engine_instance="0",type="busy"`], qt.Equals, 30.0)
+		c.Assert(engines[`device="",engine="VCS",engine_instance="1",type="busy"`], qt.Equals, 12.5)
+		c.Assert(engines, qt.HasLen, 6)
+	})
+}
+
+func TestStaleEngineSeriesRemoved(t *testing.T) {
+	c := qt.New(t)
+
+	col := newCollector(collectorOptions{namespace: defaultNamespace})
+
+	// engines returns the engine label of every gathered series, by
+	// metric name.
+	engines := func() map[string][]string {
+		families, err := col.registry.Gather()
+		c.Assert(err, qt.IsNil)
+		found := make(map[string][]string)
+		for _, family := range families {
+			for _, m := range family.GetMetric() {
+				for _, label := range m.GetLabel() {
+					if label.GetName() == "engine" {
+						found[family.GetName()] = append(found[family.GetName()], label.GetValue())
+					}
+				}
+			}
+		}
+		return found
+	}
+
+	col.Update(IntelTopStats{Engine: map[string]IntelEngine{"RCS": {}, "VCS": {}}}, nil)
+	c.Assert(engines(), qt.DeepEquals, map[string][]string{
+		"intel_gpu_engine_percent":         {"RCS", "RCS", "RCS", "VCS", "VCS", "VCS"},
+		"intel_gpu_engine_sema_wait_ratio": {"RCS", "VCS"},
+	})
+
+	// VCS is no longer reported, e.g. after a header change
+	col.Update(IntelTopStats{Engine: map[string]IntelEngine{"RCS": {}, "CCS": {}}}, nil)
+	c.Assert(engines(), qt.DeepEquals, map[string][]string{
+		"intel_gpu_engine_percent":         {"CCS", "CCS", "CCS", "RCS", "RCS", "RCS"},
+		"intel_gpu_engine_sema_wait_ratio": {"CCS", "RCS"},
+	})
+}
+
+func TestRunHeartbeat(t *testing.T) {
+	c := qt.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	before := testutil.ToFloat64(HeartbeatCounter)
+	go runHeartbeat(ctx, time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(HeartbeatCounter) < before+3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	c.Assert(testutil.ToFloat64(HeartbeatCounter) >= before+3, qt.IsTrue, qt.Commentf("heartbeat did not advance"))
+}
+
 func BenchmarkReadMetrics(b *testing.B) {
 	input := `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
 1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9
@@ -240,8 +959,104 @@ func BenchmarkReadMetrics(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		reader := strings.NewReader(input)
-		for range readMetrics(reader) {
-			// Consume all records
+		for _, err := range readMetrics(context.Background(), reader, nil) {
+			if err != nil {
+				b.Fatal(err)
+			}
 		}
 	}
 }
+
+// discardSink is a MetricsSink doing nothing, isolating collection cost.
+type discardSink struct{}
+
+func (discardSink) Update(IntelTopStats, *IntelTopStats) {}
+
+func BenchmarkRunGPUTop(b *testing.B) {
+	sinks := []struct {
+		name string
+		sink MetricsSink
+	}{
+		{"Prometheus", prometheusSink{collector: newCollector(collectorOptions{})}},
+		{"Discard", discardSink{}},
+	}
+
+	for _, s := range sinks {
+		b.Run(s.name, func(b *testing.B) {
+			// Streamed through a pipe, exercising the same blocking reads
+			// as a real process
+			runner, w := newStreamingRunner()
+			go func() {
+				io.WriteString(w, "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa\n")
+				for range b.N {
+					if _, err := io.WriteString(w, "1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9\n"); err != nil {
+						return
+					}
+				}
+				w.Close()
+			}()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// One iteration is one sample through the whole pipeline
+			b.ReportAllocs()
+			b.ResetTimer()
+			runGPUTop(ctx, cancel, runner, s.sink, collectOptions{interval: time.Second})
+		})
+	}
+}
+
+func TestReadMetricsIMC(t *testing.T) {
+	c := qt.New(t)
+
+	mib := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name    string
+		fixture string
+		reads   []*float64
+		writes  []*float64
+	}{
+		{
+			name:    "WithIMC",
+			fixture: "testdata/intel_gpu_top_imc.csv",
+			reads:   []*float64{mib(2310.5), mib(410)},
+			writes:  []*float64{mib(1120.25), mib(95.75)},
+		},
+		{
+			name:    "WithoutIMC",
+			fixture: "testdata/intel_gpu_top_no_imc.csv",
+			reads:   []*float64{nil},
+			writes:  []*float64{nil},
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			f, err := os.Open(tt.fixture)
+			c.Assert(err, qt.IsNil)
+			defer f.Close()
+
+			var reads, writes []*float64
+			var last IntelTopStats
+			for stats, err := range readMetrics(context.Background(), f, nil) {
+				c.Assert(err, qt.IsNil)
+				reads = append(reads, stats.IMCReadsMiBs)
+				writes = append(writes, stats.IMCWritesMiBs)
+				last = stats
+			}
+			c.Assert(reads, qt.DeepEquals, tt.reads)
+			c.Assert(writes, qt.DeepEquals, tt.writes)
+
+			// The gauges only have a series while bandwidth is reported
+			col := newCollector(collectorOptions{namespace: defaultNamespace})
+			col.Update(last, nil)
+			wantReads, wantWrites := map[string]float64{}, map[string]float64{}
+			if last.IMCReadsMiBs != nil {
+				wantReads[`device=""`] = *last.IMCReadsMiBs
+				wantWrites[`device=""`] = *last.IMCWritesMiBs
+			}
+			c.Assert(collected(c, col, "intel_gpu_imc_reads_mib_per_sec"), qt.DeepEquals, wantReads)
+			c.Assert(collected(c, col, "intel_gpu_imc_writes_mib_per_sec"), qt.DeepEquals, wantWrites)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Internal metrics describe the exporter itself rather than the GPU. They
+// are registered by registerInternalMetrics when -collect-internal is set.
+var (
+	StartTimeGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "exporter_start_time_seconds",
+		Help: "Unix time the exporter started",
+	})
+	GoroutinesGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "exporter_goroutines",
+		Help: "Goroutines running in the exporter, sampled periodically",
+	})
+	// SampleSequenceCounter lets scrape alignment be checked: its delta
+	// between scrapes should match the sampling rate.
+	SampleSequenceCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sample_sequence_total",
+		Help: "Incremented once per published intel_gpu_top sample",
+	})
+	// SeriesCountGauge warns of cardinality growth, e.g. on GPUs with many
+	// engine instances or busy hosts with many clients, before Prometheus
+	// ingestion limits are hit.
+	SeriesCountGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "exporter_series_count",
+		Help: "Label combinations currently published across the per-engine and per-client metrics",
+	})
+)
+
+const (
+	// goroutineSampleInterval is how often the goroutine watchdog samples.
+	goroutineSampleInterval = 30 * time.Second
+
+	// goroutineGrowthSamples is how many consecutive increases the watchdog
+	// treats as a likely leak.
+	goroutineGrowthSamples = 10
+)
+
+// registerInternalMetrics registers the internal metrics on reg, recording
+// start as the exporter start time.
+func registerInternalMetrics(reg prometheus.Registerer, start time.Time) {
+	StartTimeGauge.Set(float64(start.UnixNano()) / 1e9)
+	reg.MustRegister(StartTimeGauge)
+	reg.MustRegister(GoroutinesGauge)
+	reg.MustRegister(SampleSequenceCounter)
+	reg.MustRegister(SeriesCountGauge)
+}
+
+// goroutineWatchdog publishes the goroutine count and warns when it keeps
+// growing, which usually means a restart path is leaking goroutines.
+type goroutineWatchdog struct {
+	// threshold is the count a growing goroutine population must exceed
+	// before a warning is logged; zero disables warnings.
+	threshold int
+
+	last   int
+	growth int
+	warned bool
+}
+
+// Run samples runtime.NumGoroutine every interval until ctx is done.
+func (w *goroutineWatchdog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	w.sample(runtime.NumGoroutine())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if w.sample(runtime.NumGoroutine()) {
+				slog.Warn("Goroutine count keeps growing, possible leak", "samples", w.growth, "goroutines", w.last)
+			}
+		}
+	}
+}
+
+// sample records n goroutines and reports whether a leak warning is due.
+// Only the first sample of each growth streak past the threshold warns.
+func (w *goroutineWatchdog) sample(n int) bool {
+	GoroutinesGauge.Set(float64(n))
+
+	if n > w.last && w.last != 0 {
+		w.growth++
+	} else if n < w.last {
+		w.growth = 0
+		w.warned = false
+	}
+	w.last = n
+
+	if w.threshold <= 0 || w.warned || n <= w.threshold || w.growth < goroutineGrowthSamples {
+		return false
+	}
+	w.warned = true
+	return true
+}
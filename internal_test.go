@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRegisterInternalMetrics(t *testing.T) {
+	c := qt.New(t)
+
+	reg := prometheus.NewRegistry()
+	registerInternalMetrics(namespaced(reg, defaultNamespace), time.Unix(1700000000, 500000000))
+
+	c.Assert(testutil.ToFloat64(StartTimeGauge), qt.Equals, 1700000000.5)
+	count, err := testutil.GatherAndCount(reg, "intel_gpu_exporter_start_time_seconds", "intel_gpu_exporter_goroutines", "intel_gpu_sample_sequence_total")
+	c.Assert(err, qt.IsNil)
+	c.Assert(count, qt.Equals, 3)
+}
+
+func TestGoroutineWatchdogSample(t *testing.T) {
+	c := qt.New(t)
+
+	w := &goroutineWatchdog{threshold: 20}
+
+	// Growth below the threshold is published but never warned about
+	for n := 10; n < 20; n++ {
+		c.Assert(w.sample(n), qt.IsFalse)
+	}
+	c.Assert(testutil.ToFloat64(GoroutinesGauge), qt.Equals, 19.0)
+
+	// Crossing the threshold while still growing warns exactly once
+	c.Assert(w.sample(21), qt.IsTrue)
+	c.Assert(w.sample(22), qt.IsFalse)
+	c.Assert(w.sample(22), qt.IsFalse)
+
+	// A drop resets the streak, so renewed growth must be sustained again
+	c.Assert(w.sample(15), qt.IsFalse)
+	for n := 16; n < 25; n++ {
+		c.Assert(w.sample(n), qt.IsFalse)
+	}
+	c.Assert(w.sample(25), qt.IsTrue)
+	c.Assert(testutil.ToFloat64(GoroutinesGauge), qt.Equals, 25.0)
+}
+
+func TestGoroutineWatchdogDisabled(t *testing.T) {
+	c := qt.New(t)
+
+	w := &goroutineWatchdog{}
+	for n := 1; n < 100; n++ {
+		c.Assert(w.sample(n), qt.IsFalse)
+	}
+}
+
+func TestSampleSequence(t *testing.T) {
+	c := qt.New(t)
+
+	col := newCollector(collectorOptions{})
+	before := testutil.ToFloat64(SampleSequenceCounter)
+	for i := 1; i <= 3; i++ {
+		col.Update(IntelTopStats{}, nil)
+		c.Assert(testutil.ToFloat64(SampleSequenceCounter), qt.Equals, before+float64(i))
+	}
+
+	prometheusSink{collector: newCollector(collectorOptions{compact: true})}.Update(IntelTopStats{}, nil)
+	c.Assert(testutil.ToFloat64(SampleSequenceCounter), qt.Equals, before+4)
+}
+
+func TestSeriesCount(t *testing.T) {
+	c := qt.New(t)
+
+	col := newCollector(collectorOptions{})
+
+	twoEngines := IntelTopStats{Engine: map[string]IntelEngine{"RCS": {}, "BCS": {}}}
+	col.Update(twoEngines, nil)
+	c.Assert(testutil.ToFloat64(SeriesCountGauge), qt.Equals, 8.0)
+
+	// The same engines again add no series
+	col.Update(twoEngines, nil)
+	c.Assert(testutil.ToFloat64(SeriesCountGauge), qt.Equals, 8.0)
+
+	// A new engine instance appears while the others are no longer
+	// reported, so only its series remain
+	col.Update(IntelTopStats{Engine: map[string]IntelEngine{"VCS/1": {}}}, nil)
+	c.Assert(testutil.ToFloat64(SeriesCountGauge), qt.Equals, 4.0)
+}
+
+func TestSeriesCountClients(t *testing.T) {
+	c := qt.New(t)
+
+	// Two processes on two engines, one of them holding two DRM clients
+	// that share a series
+	stats := IntelTopStats{
+		Engine: map[string]IntelEngine{"RCS": {}},
+		Clients: []IntelClient{
+			{PID: 100, Name: "ffmpeg", EngineBusyPercent: map[string]float64{"Render/3D": 10, "Video": 20}},
+			{PID: 100, Name: "ffmpeg", EngineBusyPercent: map[string]float64{"Video": 5}},
+			{PID: 200, Name: "plex", EngineBusyPercent: map[string]float64{"Video": 30}},
+		},
+	}
+
+	col := newCollector(collectorOptions{clients: true})
+	col.Update(stats, nil)
+	c.Assert(testutil.ToFloat64(SeriesCountGauge), qt.Equals, 7.0)
+
+	// Without -enable-clients only the engine series count
+	col = newCollector(collectorOptions{})
+	col.Update(stats, nil)
+	c.Assert(testutil.ToFloat64(SeriesCountGauge), qt.Equals, 4.0)
+
+	// and -compact publishes neither
+	col = newCollector(collectorOptions{compact: true, clients: true})
+	col.Update(stats, nil)
+	c.Assert(testutil.ToFloat64(SeriesCountGauge), qt.Equals, 0.0)
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// IRQCounter accumulates the GPU interrupts intel_gpu_top reports as a
+// rate, so PromQL can take rate() and increase() of a counter rather than
+// averaging the irq_per_sec gauge between scrapes.
+var IRQCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "irq_total",
+	Help: "Intel GPU IRQs, integrated from IRQs per second over each sampling interval",
+}, []string{deviceLabel})
+
+// observeIRQ credits the interrupts of stats, which covers interval, to
+// IRQCounter.
+func observeIRQ(stats IntelTopStats, interval time.Duration) {
+	IRQCounter.WithLabelValues(stats.Device).Add(stats.IRQPerSec * interval.Seconds())
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIRQCounter(t *testing.T) {
+	c := qt.New(t)
+	IRQCounter.Reset()
+	c.Cleanup(IRQCounter.Reset)
+
+	header := "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa\n"
+	runner := &fakeRunner{outputs: []string{header +
+		"1200.0,1150.0,500.0,85.5,10.2,5.1,2.3\n" +
+		"1200.0,1150.0,100.0,85.5,10.2,5.1,2.3\n" +
+		"300.0,300.0,0.0,99.5,0.0,0.0,0.0\n"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runGPUTop(ctx, cancel, runner, discardSink{}, collectOptions{interval: 500 * time.Millisecond, device: "card1"})
+
+	// Each rate covers half a second: (500 + 100 + 0) / 2
+	c.Assert(testutil.ToFloat64(IRQCounter.WithLabelValues("card1")), qt.Equals, 300.0)
+
+	// The counter keeps accumulating across runs
+	runner.outputs = []string{header + "1200.0,1150.0,50.0,85.5,10.2,5.1,2.3\n"}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	runGPUTop(ctx, cancel, runner, discardSink{}, collectOptions{interval: time.Second, device: "card1"})
+	c.Assert(testutil.ToFloat64(IRQCounter.WithLabelValues("card1")), qt.Equals, 350.0)
+}
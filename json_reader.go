@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/mikeodr/intel-gpu-exporter-go/pkg/igtparse"
+)
+
+// Output formats accepted by -format.
+const (
+	formatCSV  = "csv"
+	formatJSON = "json"
+)
+
+// gpuTopJSONSample is one sample object of intel_gpu_top -J output. Sections
+// missing from a sample decode as zero.
+type gpuTopJSONSample struct {
+	Frequency struct {
+		Requested float64 `json:"requested"`
+		Actual    float64 `json:"actual"`
+	} `json:"frequency"`
+	Interrupts struct {
+		Count float64 `json:"count"`
+	} `json:"interrupts"`
+	RC6 struct {
+		Value float64 `json:"value"`
+	} `json:"rc6"`
+	// RC6States holds the value of each deeper RC6 state, such as rc6p and
+	// rc6pp, reported alongside rc6 on some platforms.
+	RC6States map[string]float64 `json:"-"`
+	// Power is missing without GPU energy counters.
+	Power *struct {
+		GPU     *float64 `json:"GPU"`
+		Package *float64 `json:"Package"`
+	} `json:"power"`
+	// IMCBandwidth is only present on client platforms.
+	IMCBandwidth *struct {
+		Reads  float64 `json:"reads"`
+		Writes float64 `json:"writes"`
+	} `json:"imc-bandwidth"`
+	// Fan and Voltage are only present on some discrete cards.
+	Fan *struct {
+		Speed float64 `json:"speed"`
+	} `json:"fan"`
+	Voltage *struct {
+		Value float64 `json:"value"`
+	} `json:"voltage"`
+	Engines map[string]struct {
+		Busy float64 `json:"busy"`
+		Sema float64 `json:"sema"`
+		Wait float64 `json:"wait"`
+	} `json:"engines"`
+	Clients map[string]gpuTopJSONClient `json:"clients"`
+}
+
+// UnmarshalJSON decodes a sample, collecting the sections of deeper RC6
+// states, named after rc6 with a suffix, into RC6States.
+func (s *gpuTopJSONSample) UnmarshalJSON(data []byte) error {
+	type plain gpuTopJSONSample
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return err
+	}
+	for name, section := range sections {
+		if name == "rc6" || !strings.HasPrefix(name, "rc6") {
+			continue
+		}
+		var state struct {
+			Value float64 `json:"value"`
+		}
+		if err := json.Unmarshal(section, &state); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if s.RC6States == nil {
+			s.RC6States = make(map[string]float64)
+		}
+		s.RC6States[name] = state.Value
+	}
+	return nil
+}
+
+// jsonEngineClasses map the engine class names of intel_gpu_top's JSON
+// output to the short names used in its CSV header, so both formats
+// publish the same engine labels.
+var jsonEngineClasses = map[string]string{
+	"Render/3D":    "RCS",
+	"Blitter":      "BCS",
+	"Video":        "VCS",
+	"VideoEnhance": "VECS",
+	"Compute":      "CCS",
+}
+
+// jsonEngineName converts a JSON engine name such as "Video/1" to its CSV
+// form, "VCS/1", keeping any instance suffix for igtparse.EngineNames to
+// settle. Unknown classes are kept as reported.
+func jsonEngineName(name string) string {
+	class, instance := name, ""
+	if i := strings.LastIndex(name, "/"); i >= 0 && isDigits(name[i+1:]) {
+		class, instance = name[:i], name[i+1:]
+	}
+
+	short, ok := jsonEngineClasses[class]
+	if !ok {
+		return name
+	}
+	if instance == "" {
+		return short
+	}
+	return short + "/" + instance
+}
+
+// isDigits reports whether s is a non-empty run of decimal digits.
+func isDigits(s string) bool {
+	return s != "" && strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) }) < 0
+}
+
+// readMetricsJSON parses intel_gpu_top -J output until ctx is done. Newer
+// versions wrap the samples in a JSON array that is never closed while the
+// process runs, and older ones print bare objects back to back, so samples
+// are decoded one object at a time as they arrive. Samples that can't be
+// parsed are yielded as errors. Reading carries on after a sample holding
+// bad values, but ends after one that can't be decoded as the decoder can't
+// resynchronise.
+func readMetricsJSON(ctx context.Context, output io.Reader) iter.Seq2[IntelTopStats, error] {
+	return parseUntilDone(ctx, output, decodeJSONSamples)
+}
+
+// decodeJSONSamples parses intel_gpu_top -J output for readMetricsJSON.
+func decodeJSONSamples(output io.Reader) iter.Seq2[IntelTopStats, error] {
+	return func(yield func(IntelTopStats, error) bool) {
+		br := bufio.NewReader(output)
+		dec := json.NewDecoder(br)
+		if first, err := peekNonSpace(br); err == nil && first == '[' {
+			// Step into the array so its elements decode one by one
+			if _, err := dec.Token(); err != nil {
+				yield(IntelTopStats{}, err)
+				return
+			}
+		}
+
+		for dec.More() {
+			var sample gpuTopJSONSample
+			if err := dec.Decode(&sample); err != nil {
+				// A stream ending on the separator after the last sample
+				// of the array fails like this rather than with io.EOF
+				var syntaxErr *json.SyntaxError
+				if errors.As(err, &syntaxErr) && syntaxErr.Error() == "unexpected end of JSON input" {
+					return
+				}
+				if errors.Is(err, io.ErrUnexpectedEOF) {
+					err = fmt.Errorf("%w: %w", igtparse.ErrTruncated, err)
+				}
+				yield(IntelTopStats{}, err)
+				return
+			}
+
+			stats, err := parseJSONSample(sample)
+			if !yield(stats, err) {
+				return
+			}
+		}
+	}
+}
+
+// peekNonSpace returns the first byte of r that isn't whitespace without
+// consuming it.
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(rune(b[0])) {
+			return b[0], nil
+		}
+		r.ReadByte()
+	}
+}
+
+// parseJSONSample converts sample to IntelTopStats, checking it against
+// sampleLimits. Engines missing from gpuEngines are dropped.
+func parseJSONSample(sample gpuTopJSONSample) (IntelTopStats, error) {
+	checks := []struct {
+		field string
+		value float64
+		check func(field string, value float64) error
+	}{
+		{field: "frequency.requested", value: sample.Frequency.Requested, check: sampleLimits.CheckFreq},
+		{field: "frequency.actual", value: sample.Frequency.Actual, check: sampleLimits.CheckFreq},
+		{field: "interrupts.count", value: sample.Interrupts.Count, check: sampleLimits.CheckIRQ},
+	}
+	for _, check := range checks {
+		if err := check.check(check.field, check.value); err != nil {
+			return IntelTopStats{}, err
+		}
+	}
+
+	rc6, err := sampleLimits.CheckPercent("rc6.value", sample.RC6.Value)
+	if err != nil {
+		return IntelTopStats{}, err
+	}
+	var rc6States map[string]float64
+	for name, value := range sample.RC6States {
+		value, err := sampleLimits.CheckPercent(name+".value", value)
+		if err != nil {
+			return IntelTopStats{}, err
+		}
+		if rc6States == nil {
+			rc6States = make(map[string]float64, len(sample.RC6States))
+		}
+		rc6States[name] = value
+	}
+
+	stats := IntelTopStats{
+		FreqMhzRequested: sample.Frequency.Requested,
+		FreqMhzActual:    sample.Frequency.Actual,
+		IRQPerSec:        sample.Interrupts.Count,
+		Rc6Percent:       rc6,
+		Rc6StatePercent:  rc6States,
+		Engine:           make(map[string]IntelEngine, len(sample.Engines)),
+	}
+	if sample.Power != nil {
+		stats.PowerGPUWatts = sample.Power.GPU
+		stats.PowerPackageWatts = sample.Power.Package
+	}
+	if sample.IMCBandwidth != nil {
+		stats.IMCReadsMiBs = &sample.IMCBandwidth.Reads
+		stats.IMCWritesMiBs = &sample.IMCBandwidth.Writes
+	}
+	if sample.Fan != nil {
+		stats.FanRPM = &sample.Fan.Speed
+	}
+	if sample.Voltage != nil {
+		stats.VoltageVolts = &sample.Voltage.Value
+	}
+	if len(sample.Clients) > 0 {
+		clients, err := parseJSONClients(sample.Clients)
+		if err != nil {
+			return IntelTopStats{}, err
+		}
+		stats.Clients = clients
+	}
+	// Name engines as the CSV parser does, so labels don't change with
+	// -format
+	var shortNames []string
+	for name := range sample.Engines {
+		shortNames = append(shortNames, jsonEngineName(name))
+	}
+	canonical := igtparse.EngineNames(shortNames)
+	for name, engine := range sample.Engines {
+		short := canonical[jsonEngineName(name)]
+		if class, _, _ := strings.Cut(short, "/"); gpuEngines != nil && !slices.Contains(gpuEngines, class) {
+			continue
+		}
+		busy, err := sampleLimits.CheckPercent("engines."+name+".busy", engine.Busy)
+		if err != nil {
+			return IntelTopStats{}, err
+		}
+		sema, err := sampleLimits.CheckPercent("engines."+name+".sema", engine.Sema)
+		if err != nil {
+			return IntelTopStats{}, err
+		}
+		wait, err := sampleLimits.CheckPercent("engines."+name+".wait", engine.Wait)
+		if err != nil {
+			return IntelTopStats{}, err
+		}
+		stats.Engine[short] = IntelEngine{BusyPercent: busy, SemaPercent: sema, WaitPercent: wait}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mikeodr/intel-gpu-exporter-go/pkg/igtparse"
+)
+
+func TestReadMetricsJSON(t *testing.T) {
+	watts := func(w float64) *float64 { return &w }
+
+	tests := []struct {
+		name    string
+		fixture string
+		want    []IntelTopStats
+	}{
+		{
+			// Bare objects with the first engine instance suffixed /0
+			name:    "IGT1.25",
+			fixture: "testdata/intel_gpu_top_1.25.json",
+			want: []IntelTopStats{
+				{
+					FreqMhzRequested: 1200,
+					FreqMhzActual:    1150,
+					IRQPerSec:        500,
+					Rc6Percent:       85.5,
+					Engine: map[string]IntelEngine{
+						"RCS":  {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+						"BCS":  {},
+						"VCS":  {BusyPercent: 7},
+						"VECS": {},
+					},
+				},
+				{
+					FreqMhzRequested: 300,
+					FreqMhzActual:    300,
+					IRQPerSec:        12,
+					Rc6Percent:       99.1,
+					Engine: map[string]IntelEngine{
+						"RCS":  {BusyPercent: 0.5},
+						"BCS":  {},
+						"VCS":  {},
+						"VECS": {},
+					},
+				},
+			},
+		},
+		{
+			// An array left open, with power, clients and a second video
+			// engine
+			name:    "IGT1.28",
+			fixture: "testdata/intel_gpu_top_1.28.json",
+			want: []IntelTopStats{
+				{
+					FreqMhzRequested: 2050,
+					FreqMhzActual:    2000,
+					IRQPerSec:        1830,
+					Rc6Percent:       12.3,
+					Engine: map[string]IntelEngine{
+						"RCS":   {BusyPercent: 64, WaitPercent: 1.5},
+						"BCS":   {},
+						"VCS/0": {BusyPercent: 42},
+						"VCS/1": {BusyPercent: 38.5},
+						"VECS":  {BusyPercent: 3},
+						"CCS":   {BusyPercent: 20, SemaPercent: 0.2},
+					},
+					PowerGPUWatts:     watts(38.25),
+					PowerPackageWatts: watts(0),
+					Clients: []IntelClient{
+						{PID: 4293, Name: "ffmpeg", EngineBusyPercent: map[string]float64{"RCS": 64, "VCS": 80.5}},
+					},
+				},
+				{
+					FreqMhzRequested: 2050,
+					FreqMhzActual:    1950,
+					IRQPerSec:        1790,
+					Rc6Percent:       15,
+					Engine: map[string]IntelEngine{
+						"RCS":   {BusyPercent: 60, WaitPercent: 1},
+						"BCS":   {},
+						"VCS/0": {BusyPercent: 40},
+						"VCS/1": {BusyPercent: 36},
+						"VECS":  {BusyPercent: 2},
+						"CCS":   {BusyPercent: 18},
+					},
+					PowerGPUWatts:     watts(36.9),
+					PowerPackageWatts: watts(0),
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			f, err := os.Open(tt.fixture)
+			c.Assert(err, qt.IsNil)
+			defer f.Close()
+
+			var results []IntelTopStats
+			for stats, err := range readMetricsJSON(context.Background(), f) {
+				// Stopping at the separator after the last sample isn't a
+				// truncated sample
+				c.Assert(err, qt.IsNil)
+				results = append(results, stats)
+			}
+
+			c.Assert(results, qt.DeepEquals, tt.want)
+		})
+	}
+}
+
+func TestReadMetricsJSONSkipped(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []float64
+		err   error
+	}{
+		{
+			name:  "TruncatedFinalSample",
+			input: `[{"frequency": {"actual": 1150}}, {"frequency": {"act`,
+			want:  []float64{1150},
+			err:   igtparse.ErrTruncated,
+		},
+		{
+			name:  "ImplausibleSample",
+			input: `[{"frequency": {"actual": 9e15}}, {"frequency": {"actual": 300}}]`,
+			want:  []float64{300},
+			err:   igtparse.ErrImplausibleValue,
+		},
+		{
+			name:  "ClosedArray",
+			input: "[\n{\"frequency\": {\"actual\": 1150}}\n]\n",
+			want:  []float64{1150},
+		},
+		{
+			name:  "EndsOnSeparator",
+			input: "[\n{\"frequency\": {\"actual\": 1150}},\n",
+			want:  []float64{1150},
+		},
+		{
+			name:  "Empty",
+			input: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			var freqs []float64
+			var errs []error
+			for stats, err := range readMetricsJSON(context.Background(), strings.NewReader(tt.input)) {
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				freqs = append(freqs, stats.FreqMhzActual)
+			}
+
+			c.Assert(freqs, qt.DeepEquals, tt.want)
+			if tt.err == nil {
+				c.Assert(errs, qt.HasLen, 0)
+				return
+			}
+			c.Assert(errs, qt.HasLen, 1)
+			c.Assert(errs[0], qt.ErrorIs, tt.err)
+		})
+	}
+}
+
+func TestReadMetricsJSONPresentEngines(t *testing.T) {
+	c := qt.New(t)
+
+	c.Cleanup(func() { gpuEngines = nil })
+	gpuEngines = []string{"RCS", "VCS"}
+
+	input := `{"engines": {"Render/3D/0": {"busy": 10}, "Video/1": {"busy": 5}, "VideoEnhance/0": {"busy": 0}}}`
+	var results []IntelTopStats
+	for stats, err := range readMetricsJSON(context.Background(), strings.NewReader(input)) {
+		c.Assert(err, qt.IsNil)
+		results = append(results, stats)
+	}
+
+	c.Assert(results, qt.DeepEquals, []IntelTopStats{
+		{Engine: map[string]IntelEngine{"RCS": {BusyPercent: 10}, "VCS/1": {BusyPercent: 5}}},
+	})
+}
+
+func TestReadMetricsJSONRC6States(t *testing.T) {
+	c := qt.New(t)
+
+	// Platforms with deeper RC6 states report each alongside rc6
+	input := `[{"rc6": {"value": 60, "unit": "%"}, "rc6p": {"value": 25.5, "unit": "%"}, "rc6pp": {"value": 10, "unit": "%"}},
+{"rc6": {"value": 90, "unit": "%"}}]`
+
+	var results []IntelTopStats
+	for stats, err := range readMetricsJSON(context.Background(), strings.NewReader(input)) {
+		c.Assert(err, qt.IsNil)
+		results = append(results, stats)
+	}
+	c.Assert(results, qt.HasLen, 2)
+	c.Assert(results[0].Rc6Percent, qt.Equals, 60.0)
+	c.Assert(results[0].Rc6StatePercent, qt.DeepEquals, map[string]float64{"rc6p": 25.5, "rc6pp": 10})
+	c.Assert(results[1].Rc6StatePercent, qt.IsNil)
+
+	// The overall residency keeps its state="rc6" series
+	col := newCollector(collectorOptions{namespace: defaultNamespace})
+	col.Update(results[0], nil)
+	c.Assert(collected(c, col, "intel_gpu_rc6_percent"), qt.DeepEquals, map[string]float64{
+		`device="",state="rc6"`:   60,
+		`device="",state="rc6p"`:  25.5,
+		`device="",state="rc6pp"`: 10,
+	})
+	col.Update(results[1], nil)
+	c.Assert(collected(c, col, "intel_gpu_rc6_percent"), qt.DeepEquals, map[string]float64{`device="",state="rc6"`: 90})
+
+	// Sub-states are held to the same bounds as rc6
+	defaults := sampleLimits
+	sampleLimits.Strict = true
+	c.Cleanup(func() { sampleLimits = defaults })
+	for _, err := range readMetricsJSON(context.Background(), strings.NewReader(`{"rc6": {"value": 60}, "rc6p": {"value": 250}}`)) {
+		c.Assert(err, qt.ErrorMatches, `rc6p.value \(250\): implausible value`)
+	}
+}
+
+func TestJSONEngineName(t *testing.T) {
+	c := qt.New(t)
+
+	tests := map[string]string{
+		"Render/3D":      "RCS",
+		"Render/3D/0":    "RCS/0",
+		"Blitter/0":      "BCS/0",
+		"Video":          "VCS",
+		"Video/1":        "VCS/1",
+		"VideoEnhance/0": "VECS/0",
+		"Compute/2":      "CCS/2",
+		"Mystery/1":      "Mystery/1",
+		"Render/3D/x":    "Render/3D/x",
+	}
+	for name, want := range tests {
+		c.Check(jsonEngineName(name), qt.Equals, want, qt.Commentf("engine %s", name))
+	}
+}
+
+func TestCSVAndJSONEngineNames(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		json string
+	}{
+		{
+			name: "OneInstanceEach",
+			csv: `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
+300.0,300.0,0.0,100.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0`,
+			json: `{"engines": {"Render/3D": {}, "Blitter": {}, "Video": {}, "VideoEnhance": {}}}`,
+		},
+		{
+			// As intel_gpu_top prints an Arc GPU
+			name: "SeveralInstances",
+			csv: `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS/0 %,RCS/0 se,RCS/0 wa,BCS/0 %,BCS/0 se,BCS/0 wa,VCS/0 %,VCS/0 se,VCS/0 wa,VCS/1 %,VCS/1 se,VCS/1 wa,VECS/0 %,VECS/0 se,VECS/0 wa,VECS/1 %,VECS/1 se,VECS/1 wa,CCS/0 %,CCS/0 se,CCS/0 wa,CCS/1 %,CCS/1 se,CCS/1 wa
+2050.0,2000.0,1830.0,12.3,64.0,0.0,1.5,0.0,0.0,0.0,42.0,0.0,0.0,38.5,0.0,0.0,3.0,0.0,0.0,0.0,0.0,0.0,20.0,0.2,0.0,5.5,0.0,0.1`,
+			json: `{"engines": {"Render/3D/0": {}, "Blitter/0": {}, "Video/0": {}, "Video/1": {}, "VideoEnhance/0": {}, "VideoEnhance/1": {}, "Compute/0": {}, "Compute/1": {}}}`,
+		},
+	}
+
+	engines := func(c *qt.C, samples iter.Seq2[IntelTopStats, error]) []string {
+		var names []string
+		for stats, err := range samples {
+			c.Assert(err, qt.IsNil)
+			names = append(names, slices.Sorted(maps.Keys(stats.Engine))...)
+		}
+		return names
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			csv := engines(c, readMetrics(context.Background(), strings.NewReader(tt.csv), nil))
+			json := engines(c, readMetricsJSON(context.Background(), strings.NewReader(tt.json)))
+			c.Assert(csv, qt.Not(qt.HasLen), 0)
+			c.Assert(json, qt.DeepEquals, csv)
+		})
+	}
+}
+
+func TestReadMetricsJSONFanVoltage(t *testing.T) {
+	c := qt.New(t)
+
+	f, err := os.Open("testdata/intel_gpu_top_arc.json")
+	c.Assert(err, qt.IsNil)
+	defer f.Close()
+
+	var results []IntelTopStats
+	for stats, err := range readMetricsJSON(context.Background(), f) {
+		c.Assert(err, qt.IsNil)
+		results = append(results, stats)
+	}
+	c.Assert(results, qt.HasLen, 2)
+
+	// Published while reported
+	col := newCollector(collectorOptions{namespace: defaultNamespace})
+	c.Assert(*results[0].FanRPM, qt.Equals, 1450.0)
+	c.Assert(*results[0].VoltageVolts, qt.Equals, 0.935)
+	col.Update(results[0], nil)
+	c.Assert(collected(c, col, "intel_gpu_fan_rpm"), qt.DeepEquals, map[string]float64{`device=""`: 1450})
+	c.Assert(collected(c, col, "intel_gpu_voltage_volts"), qt.DeepEquals, map[string]float64{`device=""`: 0.935})
+
+	// and removed once a sample no longer reports them
+	c.Assert(results[1].FanRPM, qt.IsNil)
+	c.Assert(results[1].VoltageVolts, qt.IsNil)
+	col.Update(results[1], nil)
+	c.Assert(collected(c, col, "intel_gpu_fan_rpm"), qt.HasLen, 0)
+	c.Assert(collected(c, col, "intel_gpu_voltage_volts"), qt.HasLen, 0)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"cmp"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// landingTemplate renders the landing page served at /, showing the latest
+// sample of each device so operators can check the exporter works without
+// a scrape.
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Intel GPU Exporter</title></head>
+<body>
+<h1>Intel GPU Exporter</h1>
+<p><a href="{{.MetricsPath}}">Metrics</a> · <a href="metadata">Metadata</a>{{if .UI}} · <a href="ui">Live charts</a>{{end}}</p>
+{{range .Devices}}
+<h2>{{if .Name}}{{.Name}}{{else}}GPU{{end}}</h2>
+<table>
+<tr><th>Actual frequency</th><td>{{printf "%.0f" .FreqActual}} MHz</td></tr>
+<tr><th>Requested frequency</th><td>{{printf "%.0f" .FreqRequested}} MHz</td></tr>
+<tr><th>RC6</th><td>{{printf "%.1f" .RC6}}%</td></tr>
+{{range .Engines}}<tr><th>{{.Name}}</th><td>{{printf "%.1f" .Busy}}% busy</td></tr>
+{{end}}</table>
+{{else}}
+<p>No sample received from intel_gpu_top yet.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// landingDevice is the latest sample of a device as the landing page shows
+// it, with percentages on a 0-100 scale whatever -fraction is set to.
+type landingDevice struct {
+	Name          string
+	FreqActual    float64
+	FreqRequested float64
+	RC6           float64
+	Engines       []landingEngine
+}
+
+type landingEngine struct {
+	Name string
+	Busy float64
+}
+
+// landingHandler serves the landing page from the samples of col, linking
+// to metricsPath, and to /ui when ui is set.
+func landingHandler(col *Collector, metricsPath string, ui bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		percent := 100 / col.fullScale
+		var devices []landingDevice
+		for _, stats := range col.Snapshot() {
+			device := landingDevice{
+				Name:          stats.Device,
+				FreqActual:    stats.FreqMhzActual,
+				FreqRequested: stats.FreqMhzRequested,
+				RC6:           stats.Rc6Percent * percent,
+			}
+			for name, engine := range stats.Engine {
+				device.Engines = append(device.Engines, landingEngine{Name: name, Busy: engine.BusyPercent * percent})
+			}
+			slices.SortFunc(device.Engines, func(a, b landingEngine) int { return cmp.Compare(a.Name, b.Name) })
+			devices = append(devices, device)
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := landingTemplate.Execute(w, struct {
+			MetricsPath string
+			UI          bool
+			Devices     []landingDevice
+		}{relativeMetricsPath(metricsPath), ui, devices})
+		if err != nil {
+			slog.Error("Error writing landing page", "err", err)
+		}
+	})
+}
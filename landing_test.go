@@ -0,0 +1,98 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestLandingHandler(t *testing.T) {
+	stats := IntelTopStats{
+		Device:           "card1",
+		FreqMhzRequested: 1200,
+		FreqMhzActual:    1150,
+		Rc6Percent:       12.5,
+		Engine: map[string]IntelEngine{
+			"RCS":   {BusyPercent: 60},
+			"VCS/1": {BusyPercent: 40},
+		},
+	}
+	fraction := stats
+	fraction.Rc6Percent = 0.125
+	fraction.Engine = map[string]IntelEngine{"RCS": {BusyPercent: 0.6}, "VCS/1": {BusyPercent: 0.4}}
+
+	tests := []struct {
+		name        string
+		opts        collectorOptions
+		stats       []IntelTopStats
+		metricsPath string
+		ui          bool
+		want        []string
+		dontWant    []string
+	}{
+		{
+			name:  "Sample",
+			stats: []IntelTopStats{stats},
+			want: []string{
+				"<h2>card1</h2>",
+				"<td>1150 MHz</td>",
+				"<th>RC6</th><td>12.5%</td>",
+				"<th>RCS</th><td>60.0% busy</td>",
+				"<th>VCS/1</th><td>40.0% busy</td>",
+				`href="metrics"`,
+			},
+			dontWant: []string{`href="ui"`},
+		},
+		{
+			name:  "Fraction",
+			opts:  collectorOptions{fraction: true},
+			stats: []IntelTopStats{fraction},
+			want:  []string{"<th>RC6</th><td>12.5%</td>", "<th>RCS</th><td>60.0% busy</td>"},
+		},
+		{
+			// The latest sample, not the aggregate the next scrape reports
+			name:  "ScrapeAggregate",
+			opts:  collectorOptions{scrapeAggregate: aggregateMax},
+			stats: []IntelTopStats{stats, {Device: "card1", FreqMhzActual: 300}},
+			want:  []string{"<td>300 MHz</td>"},
+		},
+		{
+			// Linked relative to / in case a reverse proxy adds a prefix
+			name:        "MetricsPath",
+			metricsPath: "/gpu/metrics",
+			want:        []string{`href="gpu/metrics"`},
+			dontWant:    []string{`href="metrics"`},
+		},
+		{
+			name: "NoSample",
+			ui:   true,
+			want: []string{"No sample received from intel_gpu_top yet.", `href="ui"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			metricsPath := cmp.Or(tt.metricsPath, defaultMetricsPath)
+			col := newCollector(tt.opts)
+			for _, stats := range tt.stats {
+				col.Update(stats, nil)
+			}
+
+			rec := httptest.NewRecorder()
+			landingHandler(col, metricsPath, tt.ui).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			c.Assert(rec.Code, qt.Equals, http.StatusOK)
+			c.Assert(rec.Header().Get("Content-Type"), qt.Equals, "text/html; charset=utf-8")
+			for _, want := range tt.want {
+				c.Assert(rec.Body.String(), qt.Contains, want)
+			}
+			for _, dontWant := range tt.dontWant {
+				c.Assert(rec.Body.String(), qt.Not(qt.Contains), dontWant)
+			}
+		})
+	}
+}
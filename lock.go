@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// errAlreadyRunning is returned by acquireInstanceLock when another
+// exporter holds the lock.
+var errAlreadyRunning = errors.New("another exporter instance is already running")
+
+// defaultLockFile is where -single-instance keeps its lock.
+const defaultLockFile = "/run/lock/intel-gpu-exporter.lock"
+
+// instanceLock is an exclusive flock on a lock file, held for the life of
+// the exporter. Running two intel_gpu_top instances against the same GPU
+// can interfere on some kernels, and two exporters double-report it
+// anyway. The kernel releases a flock when its holder exits, so a crash
+// never leaves a stale lock behind.
+type instanceLock struct {
+	f *os.File
+}
+
+// acquireInstanceLock takes the lock at path without blocking, recording
+// the holder's PID in the file to help find it.
+func acquireInstanceLock(path string) (*instanceLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tryLock(f); err != nil {
+		holder, _ := os.ReadFile(path)
+		f.Close()
+		if errors.Is(err, errAlreadyRunning) {
+			return nil, fmt.Errorf("%w: %s is held by PID %s", errAlreadyRunning, path, holder)
+		}
+		return nil, err
+	}
+
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	return &instanceLock{f: f}, nil
+}
+
+// Release drops the lock. The file is left in place: removing it would let
+// a waiting instance lock a file a new one can no longer see.
+func (l *instanceLock) Release() error {
+	if err := unlock(l.f); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
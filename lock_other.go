@@ -0,0 +1,18 @@
+//go:build windows || plan9 || solaris || aix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// tryLock reports that flock isn't available on this platform.
+func tryLock(f *os.File) error {
+	return errors.New("-single-instance is not supported on this platform")
+}
+
+// unlock is never reached, as tryLock never locks.
+func unlock(f *os.File) error {
+	return nil
+}
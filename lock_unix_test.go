@@ -0,0 +1,43 @@
+//go:build !windows && !plan9 && !solaris && !aix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestInstanceLock(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(c.TempDir(), "exporter.lock")
+
+	lock, err := acquireInstanceLock(path)
+	c.Assert(err, qt.IsNil)
+
+	pid, err := os.ReadFile(path)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(pid), qt.Equals, strconv.Itoa(os.Getpid()))
+
+	// A second instance is refused while the lock is held
+	_, err = acquireInstanceLock(path)
+	c.Assert(err, qt.ErrorIs, errAlreadyRunning)
+	c.Assert(err, qt.ErrorMatches, "another exporter instance is already running: .*exporter.lock is held by PID "+string(pid))
+
+	// and can take over once it is released
+	c.Assert(lock.Release(), qt.IsNil)
+	lock, err = acquireInstanceLock(path)
+	c.Assert(err, qt.IsNil)
+	c.Assert(lock.Release(), qt.IsNil)
+}
+
+func TestInstanceLockUnwritable(t *testing.T) {
+	c := qt.New(t)
+
+	_, err := acquireInstanceLock(filepath.Join(c.TempDir(), "missing", "exporter.lock"))
+	c.Assert(err, qt.ErrorMatches, "open .*: no such file or directory")
+	c.Assert(err, qt.Not(qt.ErrorIs), errAlreadyRunning)
+}
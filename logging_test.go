@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/mikeodr/intel-gpu-exporter-go/pkg/igtparse"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		level   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "Text",
+			format: logFormatText,
+			level:  "info",
+			want:   ` level=WARN msg="Skipping record" err="implausible value"` + "\n",
+		},
+		{
+			name:   "JSON",
+			format: logFormatJSON,
+			level:  "INFO",
+			want:   `,"level":"WARN","msg":"Skipping record","err":"implausible value"}` + "\n",
+		},
+		{
+			name:   "LevelFilters",
+			format: logFormatText,
+			level:  "error",
+		},
+		{
+			name:    "BadFormat",
+			format:  "logfmt",
+			level:   "info",
+			wantErr: `invalid -log-format "logfmt": must be text or json`,
+		},
+		{
+			name:    "BadLevel",
+			format:  logFormatText,
+			level:   "verbose",
+			wantErr: `invalid -log-level "verbose": must be debug, info, warn or error`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			var buf bytes.Buffer
+			logger, err := newLogger(&buf, tt.format, tt.level)
+			if tt.wantErr != "" {
+				c.Assert(err, qt.ErrorMatches, tt.wantErr)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+
+			logger.Debug("Not written at any level tested")
+			logger.Warn("Skipping record", "err", igtparse.ErrImplausibleValue)
+			if tt.want == "" {
+				c.Assert(buf.String(), qt.Equals, "")
+				return
+			}
+			// Everything but the leading timestamp is stable
+			c.Assert(strings.HasSuffix(buf.String(), tt.want), qt.IsTrue, qt.Commentf("%s", buf.String()))
+		})
+	}
+}
+
+func TestLogRecordError(t *testing.T) {
+	c := qt.New(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	input := "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa\n" +
+		"1200.0,9e15,500.0,85.5,10.2,5.1,2.3\n" +
+		"1200.0,1150.0,500.0\n"
+	for _, err := range readMetrics(context.Background(), strings.NewReader(input), nil) {
+		if err != nil {
+			logRecordError(logger, err)
+		}
+	}
+
+	// Skipped records are logged with stable keys, whatever their message
+	var records []map[string]any
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record map[string]any
+		c.Assert(json.Unmarshal(scanner.Bytes(), &record), qt.IsNil)
+		delete(record, "time")
+		records = append(records, record)
+	}
+	c.Assert(records, qt.DeepEquals, []map[string]any{
+		{
+			"level":  "WARN",
+			"msg":    "Skipping record",
+			"line":   2.0,
+			"record": []any{"1200.0", "9e15", "500.0", "85.5", "10.2", "5.1", "2.3"},
+			"err":    "field 1 (9e+15): implausible value",
+		},
+		{
+			"level":  "WARN",
+			"msg":    "Incomplete record, skipping",
+			"line":   3.0,
+			"record": []any{"1200.0", "1150.0", "500.0"},
+			"err":    "got 3 fields, want 7: truncated record",
+		},
+	})
+}
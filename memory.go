@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	memoryTotalDesc = prometheus.NewDesc(
+		"memory_total_bytes",
+		"Intel GPU memory available in total, in bytes",
+		nil, nil,
+	)
+	memoryUsedDesc = prometheus.NewDesc(
+		"memory_used_bytes",
+		"Intel GPU memory in use, in bytes",
+		nil, nil,
+	)
+)
+
+// memoryCollector reads GPU memory from sysfs style files on each scrape.
+// Drivers differ in which files they provide, so the paths are configured
+// with -memory-total-file and -memory-used-file. A metric whose file is
+// unset, missing or unreadable is left out of the scrape.
+type memoryCollector struct {
+	totalPath string
+	usedPath  string
+}
+
+func (m memoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- memoryTotalDesc
+	ch <- memoryUsedDesc
+}
+
+func (m memoryCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, f := range []struct {
+		desc *prometheus.Desc
+		path string
+	}{
+		{memoryTotalDesc, m.totalPath},
+		{memoryUsedDesc, m.usedPath},
+	} {
+		if f.path == "" {
+			continue
+		}
+		bytes, err := readBytesFile(f.path)
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(f.desc, prometheus.GaugeValue, bytes)
+	}
+}
+
+// readBytesFile reads a file holding a single byte count, as sysfs does.
+func readBytesFile(path string) (float64, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(content)), 64)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMemoryCollector(t *testing.T) {
+	c := qt.New(t)
+
+	root := c.TempDir()
+	total := filepath.Join(root, "lmem_total_bytes")
+	used := filepath.Join(root, "lmem_used_bytes")
+	garbage := filepath.Join(root, "garbage")
+	c.Assert(os.WriteFile(total, []byte("17179869184\n"), 0o644), qt.IsNil)
+	c.Assert(os.WriteFile(used, []byte("4294967296\n"), 0o644), qt.IsNil)
+	c.Assert(os.WriteFile(garbage, []byte("n/a\n"), 0o644), qt.IsNil)
+
+	tests := []struct {
+		name      string
+		collector memoryCollector
+		expected  string
+	}{
+		{
+			name:      "Both",
+			collector: memoryCollector{totalPath: total, usedPath: used},
+			expected: `
+# HELP intel_gpu_memory_total_bytes Intel GPU memory available in total, in bytes
+# TYPE intel_gpu_memory_total_bytes gauge
+intel_gpu_memory_total_bytes 1.7179869184e+10
+# HELP intel_gpu_memory_used_bytes Intel GPU memory in use, in bytes
+# TYPE intel_gpu_memory_used_bytes gauge
+intel_gpu_memory_used_bytes 4.294967296e+09
+`,
+		},
+		{
+			name:      "MissingUsedFile",
+			collector: memoryCollector{totalPath: total, usedPath: filepath.Join(root, "missing")},
+			expected: `
+# HELP intel_gpu_memory_total_bytes Intel GPU memory available in total, in bytes
+# TYPE intel_gpu_memory_total_bytes gauge
+intel_gpu_memory_total_bytes 1.7179869184e+10
+`,
+		},
+		{
+			name:      "UnparsableTotal",
+			collector: memoryCollector{totalPath: garbage, usedPath: used},
+			expected: `
+# HELP intel_gpu_memory_used_bytes Intel GPU memory in use, in bytes
+# TYPE intel_gpu_memory_used_bytes gauge
+intel_gpu_memory_used_bytes 4.294967296e+09
+`,
+		},
+		{
+			name:      "Unset",
+			collector: memoryCollector{},
+			expected:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			// The exporter registers it under -namespace
+			reg := prometheus.NewRegistry()
+			namespaced(reg, defaultNamespace).MustRegister(tt.collector)
+			err := testutil.GatherAndCompare(reg, strings.NewReader(tt.expected))
+			c.Assert(err, qt.IsNil)
+		})
+	}
+}
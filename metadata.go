@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricMetadata describes one metric family served on /metadata.
+type metricMetadata struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Help string `json:"help"`
+}
+
+// metadataHandler serves the name, type and help text of every metric
+// family g gathers as a JSON array, sorted by name.
+func metadataHandler(g prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Gather returns families sorted by name alongside any partial error
+		families, err := g.Gather()
+		if err != nil {
+			slog.Error("Error gathering metrics for metadata", "err", err)
+		}
+
+		metadata := make([]metricMetadata, 0, len(families))
+		for _, mf := range families {
+			metadata = append(metadata, metricMetadata{
+				Name: mf.GetName(),
+				Type: strings.ToLower(mf.GetType().String()),
+				Help: mf.GetHelp(),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(metadata); err != nil {
+			slog.Error("Error writing metadata", "err", err)
+		}
+	})
+}
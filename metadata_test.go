@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetadataHandler(t *testing.T) {
+	c := qt.New(t)
+
+	col := newCollector(collectorOptions{namespace: defaultNamespace, compact: true})
+	col.Update(IntelTopStats{FreqMhzActual: 1150}, nil)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(col)
+	namespaced(reg, defaultNamespace).MustRegister(HeartbeatCounter)
+
+	server := httptest.NewServer(metadataHandler(reg))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
+
+	var metadata []metricMetadata
+	c.Assert(json.NewDecoder(resp.Body).Decode(&metadata), qt.IsNil)
+
+	c.Assert(metadata, qt.DeepEquals, []metricMetadata{
+		{Name: "intel_gpu_busy_percent", Type: "gauge", Help: "Intel GPU busy percentage of its busiest engine"},
+		{Name: "intel_gpu_exporter_heartbeat_total", Type: "counter", Help: "Incremented on a fixed schedule while the exporter process is alive"},
+		{Name: "intel_gpu_exporter_last_sample_timestamp_seconds", Type: "gauge", Help: "Unix time the latest intel_gpu_top record was parsed"},
+		{Name: "intel_gpu_freq_mhz_actual", Type: "gauge", Help: "Intel GPU actual frequency in MHz"},
+		{Name: "intel_gpu_rc6_percent", Type: "gauge", Help: "Intel GPU RC6 power state percentage"},
+	})
+}
+
+func TestMetadataHandlerReadOnly(t *testing.T) {
+	c := qt.New(t)
+
+	server := httptest.NewServer(metadataHandler(prometheus.NewRegistry()))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json", nil)
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusMethodNotAllowed)
+	c.Assert(resp.Header.Get("Allow"), qt.Equals, "GET, HEAD")
+}
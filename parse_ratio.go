@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// parseWindowSize is how many recent records the parse success ratio
+// covers.
+const parseWindowSize = 100
+
+// ParseSuccessRatioGauge normalises the skipped record counters into a
+// single recent health figure that alerts can threshold directly.
+var ParseSuccessRatioGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "parse_success_ratio",
+	Help: "Share of the last 100 intel_gpu_top records that parsed successfully",
+})
+
+// RecordsCounter and ParseErrorsCounter count every record since start, so
+// losses outside the ratio's window show up in rate() too.
+var (
+	RecordsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "exporter_records_total",
+		Help: "intel_gpu_top records parsed successfully",
+	})
+	ParseErrorsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "exporter_parse_errors_total",
+		Help: "intel_gpu_top records skipped instead of published, by reason: invalid, truncated or out_of_bounds",
+	}, []string{"reason"})
+)
+
+// outcomeWindow is a ring buffer of the most recent parse outcomes.
+type outcomeWindow struct {
+	outcomes []bool
+	next     int
+	filled   int
+	ok       int
+}
+
+func newOutcomeWindow(size int) *outcomeWindow {
+	return &outcomeWindow{outcomes: make([]bool, size)}
+}
+
+// Record adds an outcome, evicting the oldest once the window is full, and
+// returns the share of successes in the window.
+func (w *outcomeWindow) Record(success bool) float64 {
+	if w.filled == len(w.outcomes) {
+		if w.outcomes[w.next] {
+			w.ok--
+		}
+	} else {
+		w.filled++
+	}
+
+	w.outcomes[w.next] = success
+	if success {
+		w.ok++
+	}
+	w.next = (w.next + 1) % len(w.outcomes)
+
+	return float64(w.ok) / float64(w.filled)
+}
+
+// parseOutcomes tracks the records read by readMetrics across intel_gpu_top
+// restarts and devices, guarded by parseOutcomesMu.
+var (
+	parseOutcomes   = newOutcomeWindow(parseWindowSize)
+	parseOutcomesMu sync.Mutex
+)
+
+// recordParseOutcome records whether a record parsed, counting it if it did
+// and updating ParseSuccessRatioGauge. Failures are counted by
+// logRecordError, which knows why the record was skipped.
+func recordParseOutcome(success bool) {
+	if success {
+		RecordsCounter.Inc()
+	}
+
+	parseOutcomesMu.Lock()
+	defer parseOutcomesMu.Unlock()
+	ParseSuccessRatioGauge.Set(parseOutcomes.Record(success))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOutcomeWindow(t *testing.T) {
+	c := qt.New(t)
+
+	w := newOutcomeWindow(4)
+	steps := []struct {
+		success bool
+		want    float64
+	}{
+		{success: true, want: 1},
+		{success: false, want: 0.5},
+		{success: true, want: 2.0 / 3},
+		{success: true, want: 0.75},
+		// Full; the oldest outcome is evicted from here on
+		{success: false, want: 0.5},
+		{success: true, want: 0.75},
+		{success: true, want: 0.75},
+		{success: true, want: 0.75},
+		{success: true, want: 1},
+	}
+
+	for i, step := range steps {
+		c.Assert(w.Record(step.success), qt.Equals, step.want, qt.Commentf("step %d", i))
+	}
+}
+
+func TestParseSuccessRatio(t *testing.T) {
+	c := qt.New(t)
+
+	outcomes := parseOutcomes
+	parseOutcomes = newOutcomeWindow(parseWindowSize)
+	c.Cleanup(func() { parseOutcomes = outcomes })
+	records := testutil.ToFloat64(RecordsCounter)
+	truncated := testutil.ToFloat64(ParseErrorsCounter.WithLabelValues("truncated"))
+	outOfBounds := testutil.ToFloat64(ParseErrorsCounter.WithLabelValues("out_of_bounds"))
+
+	// Three good records, one truncated and one implausible; headers don't
+	// count
+	input := `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3
+1200.0,1150.0,500.0
+1200.0,9e15,500.0,85.5,10.2,5.1,2.3
+Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3
+`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var sink recordingSink
+	runGPUTop(ctx, cancel, &fakeRunner{outputs: []string{input}}, &sink, collectOptions{interval: time.Second})
+
+	c.Assert(sink.updates, qt.HasLen, 3)
+	c.Assert(testutil.ToFloat64(ParseSuccessRatioGauge), qt.Equals, 0.6)
+	c.Assert(testutil.ToFloat64(RecordsCounter)-records, qt.Equals, 3.0)
+	c.Assert(testutil.ToFloat64(ParseErrorsCounter.WithLabelValues("truncated"))-truncated, qt.Equals, 1.0)
+	c.Assert(testutil.ToFloat64(ParseErrorsCounter.WithLabelValues("out_of_bounds"))-outOfBounds, qt.Equals, 1.0)
+}
+
+// parseErrorTotal returns the records skipped for any reason so far.
+func parseErrorTotal() float64 {
+	var total float64
+	for _, reason := range []string{"invalid", "truncated", "out_of_bounds"} {
+		total += testutil.ToFloat64(ParseErrorsCounter.WithLabelValues(reason))
+	}
+	return total
+}
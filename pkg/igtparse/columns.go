@@ -0,0 +1,330 @@
+package igtparse
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// columnKind identifies which IntelTopStats field a CSV column feeds.
+type columnKind int
+
+const (
+	columnIgnore columnKind = iota
+	columnFreqRequested
+	columnFreqActual
+	columnIRQ
+	columnRc6
+	columnPowerGPU
+	columnPowerPackage
+	columnIMCReads
+	columnIMCWrites
+	columnEngine
+)
+
+// ColumnTarget is the destination of a single CSV column, as returned by
+// ParseColumnTarget. The zero value ignores the column. engine and metric
+// are only set for columnEngine.
+type ColumnTarget struct {
+	kind   columnKind
+	engine string
+	metric string
+}
+
+// columnLayout maps each CSV column index to its target.
+type columnLayout []ColumnTarget
+
+// ColumnMapping overrides header auto-detection, as loaded by
+// LoadColumnMapping or built from ParseColumnTarget. Keys are either a
+// column header, e.g. "RCS %", or a zero-based column index, e.g. "4".
+type ColumnMapping map[string]ColumnTarget
+
+// defaultHeader is the header printed by intel_gpu_top -c, used until the
+// stream provides its own.
+var defaultHeader = []string{
+	"Freq MHz req", "Freq MHz act", "IRQ /s", "RC6 %",
+	"RCS %", "RCS se", "RCS wa",
+	"BCS %", "BCS se", "BCS wa",
+	"VCS %", "VCS se", "VCS wa",
+	"VECS %", "VECS se", "VECS wa",
+}
+
+// headerFields returns the header names of record with surrounding
+// whitespace trimmed, and whether record is a header at all. intel_gpu_top
+// reprints its header mid-stream, and a field starting with "Freq MHz" is
+// what tells it apart from data, whatever padding it carries.
+func headerFields(record []string) ([]string, bool) {
+	header := make([]string, len(record))
+	isHeader := false
+	for i, field := range record {
+		header[i] = strings.TrimSpace(field)
+		isHeader = isHeader || strings.HasPrefix(header[i], "Freq MHz")
+	}
+	return header, isHeader
+}
+
+// defaultHeader returns the header assumed until the stream provides its
+// own: intel_gpu_top's, with columns for exactly p.Engines when set.
+func (p *Parser) defaultHeader() []string {
+	if p.Engines == nil {
+		return defaultHeader
+	}
+	header := []string{"Freq MHz req", "Freq MHz act", "IRQ /s", "RC6 %"}
+	for _, engine := range p.Engines {
+		header = append(header, engine+" %", engine+" se", engine+" wa")
+	}
+	return header
+}
+
+// knownColumns are the headers recognised without a mapping.
+var knownColumns = map[string]ColumnTarget{
+	"Freq MHz req": {kind: columnFreqRequested},
+	"Freq MHz act": {kind: columnFreqActual},
+	"IRQ /s":       {kind: columnIRQ},
+	"RC6 %":        {kind: columnRc6},
+	"Power W gpu":  {kind: columnPowerGPU},
+	"Power W pkg":  {kind: columnPowerPackage},
+	"IMC MiB/s rd": {kind: columnIMCReads},
+	"IMC MiB/s wr": {kind: columnIMCWrites},
+	"RCS %":        {kind: columnEngine, engine: "RCS", metric: "busy"},
+	"RCS se":       {kind: columnEngine, engine: "RCS", metric: "sema"},
+	"RCS wa":       {kind: columnEngine, engine: "RCS", metric: "wait"},
+	"BCS %":        {kind: columnEngine, engine: "BCS", metric: "busy"},
+	"BCS se":       {kind: columnEngine, engine: "BCS", metric: "sema"},
+	"BCS wa":       {kind: columnEngine, engine: "BCS", metric: "wait"},
+	"VCS %":        {kind: columnEngine, engine: "VCS", metric: "busy"},
+	"VCS se":       {kind: columnEngine, engine: "VCS", metric: "sema"},
+	"VCS wa":       {kind: columnEngine, engine: "VCS", metric: "wait"},
+	"VECS %":       {kind: columnEngine, engine: "VECS", metric: "busy"},
+	"VECS se":      {kind: columnEngine, engine: "VECS", metric: "sema"},
+	"VECS wa":      {kind: columnEngine, engine: "VECS", metric: "wait"},
+}
+
+// engineColumnSuffixes map the suffix of an engine column header, e.g. the
+// "se" of "CCS se", to the engine metric it carries.
+var engineColumnSuffixes = map[string]string{
+	"%":  "busy",
+	"se": "sema",
+	"wa": "wait",
+}
+
+// layout resolves header into a column layout. Entries in p.Mapping take
+// precedence over knownColumns, then engines are detected from headers of
+// the form "<ENGINE> %", "<ENGINE> se" or "<ENGINE> wa" so engines this
+// package hasn't heard of still appear. Engines are named by EngineNames.
+// Columns matched by none of these are ignored, as reported by
+// UnmappedColumns, without a warning as intel_gpu_top reprints its header.
+// Engine columns not matched by p.Mapping for engines missing from
+// p.Engines are ignored with a warning. A column resolving to the same
+// target as an earlier one is also ignored with a warning, rather than
+// overwriting the earlier column's value in each record.
+func (p *Parser) layout(header []string) columnLayout {
+	layout := make(columnLayout, len(header))
+	seen := make(map[ColumnTarget]int)
+
+	for i, name := range header {
+		if target, ok := p.Mapping[strconv.Itoa(i)]; ok {
+			layout[i] = target
+		} else if target, ok := p.Mapping[name]; ok {
+			layout[i] = target
+		} else if target, ok := knownColumns[name]; ok {
+			layout[i] = p.presentEngine(i, name, target)
+		} else if target, ok := parseEngineColumn(name); ok {
+			if target.engine == "" {
+				p.logger().Warn("Ignoring column with an empty engine name", "column", i, "name", name)
+				continue
+			}
+			layout[i] = p.presentEngine(i, name, target)
+		}
+
+		if layout[i].kind == columnIgnore {
+			continue
+		}
+		if first, ok := seen[layout[i]]; ok {
+			p.logger().Warn("Ignoring duplicate column", "column", i, "name", name, "duplicates", first)
+			layout[i] = ColumnTarget{}
+			continue
+		}
+		seen[layout[i]] = i
+	}
+
+	// Name engines as in JSON output of the same GPU
+	var engines []string
+	for _, target := range layout {
+		if target.kind == columnEngine {
+			engines = append(engines, target.engine)
+		}
+	}
+	canonical := EngineNames(engines)
+	for i, target := range layout {
+		if target.kind == columnEngine {
+			layout[i].engine = canonical[target.engine]
+		}
+	}
+
+	return layout
+}
+
+// UnmappedColumns returns the names of the columns of header that neither
+// p.Mapping nor auto-detection resolve, which Parse ignores. Callers can
+// check a header with it once rather than on every reprint.
+func (p *Parser) UnmappedColumns(header []string) []string {
+	var unmapped []string
+	for i, name := range header {
+		_, byIndex := p.Mapping[strconv.Itoa(i)]
+		_, byName := p.Mapping[name]
+		_, known := knownColumns[name]
+		_, engine := parseEngineColumn(name)
+		if !byIndex && !byName && !known && !engine {
+			unmapped = append(unmapped, name)
+		}
+	}
+	return unmapped
+}
+
+// presentEngine returns target, or an ignored column with a warning when
+// target is an engine missing from p.Engines.
+func (p *Parser) presentEngine(i int, name string, target ColumnTarget) ColumnTarget {
+	if target.kind != columnEngine || p.Engines == nil {
+		return target
+	}
+
+	class, _, _ := strings.Cut(target.engine, "/")
+	if !slices.Contains(p.Engines, class) {
+		p.logger().Warn("Ignoring column of an engine this GPU doesn't have", "column", i, "name", name, "engine", class)
+		return ColumnTarget{}
+	}
+	return target
+}
+
+// EngineNames maps each of names, the engines of one GPU such as "VCS/1",
+// to its canonical name. The "/0" suffix of a class's only instance is
+// dropped, e.g. "RCS/0" becomes "RCS", as intel_gpu_top versions and
+// output formats disagree on whether to print it, while classes with
+// several instances keep their suffixes to tell them apart.
+func EngineNames(names []string) map[string]string {
+	instances := make(map[string]map[string]bool)
+	for _, name := range names {
+		class := engineClass(name)
+		if instances[class] == nil {
+			instances[class] = make(map[string]bool)
+		}
+		instances[class][name] = true
+	}
+
+	canonical := make(map[string]string, len(names))
+	for _, name := range names {
+		canonical[name] = name
+		if class := engineClass(name); len(instances[class]) == 1 && name == class+"/0" {
+			canonical[name] = class
+		}
+	}
+	return canonical
+}
+
+// engineClass returns name without its instance suffix, e.g. "VCS" for
+// "VCS/1". Names without a numeric suffix are their own class.
+func engineClass(name string) string {
+	i := strings.LastIndex(name, "/")
+	if i < 0 || name[i+1:] == "" || strings.TrimLeft(name[i+1:], "0123456789") != "" {
+		return name
+	}
+	return name[:i]
+}
+
+// parseEngineColumn detects an engine column from its header. The returned
+// target has an empty engine when the header is blank apart from the
+// suffix, e.g. " %".
+func parseEngineColumn(name string) (ColumnTarget, bool) {
+	name = strings.TrimSpace(name)
+	for suffix, metric := range engineColumnSuffixes {
+		engine, ok := strings.CutSuffix(name, suffix)
+		if !ok || (engine != "" && !strings.HasSuffix(engine, " ")) {
+			continue
+		}
+		return ColumnTarget{kind: columnEngine, engine: strings.TrimSpace(engine), metric: metric}, true
+	}
+	return ColumnTarget{}, false
+}
+
+// ParseColumnTarget parses a mapping target: freq_requested, freq_actual,
+// irq, rc6, power_gpu, power_package, imc_reads, imc_writes, ignore or
+// engine:<NAME>:<busy|sema|wait>.
+func ParseColumnTarget(s string) (ColumnTarget, error) {
+	switch s {
+	case "freq_requested":
+		return ColumnTarget{kind: columnFreqRequested}, nil
+	case "freq_actual":
+		return ColumnTarget{kind: columnFreqActual}, nil
+	case "irq":
+		return ColumnTarget{kind: columnIRQ}, nil
+	case "rc6":
+		return ColumnTarget{kind: columnRc6}, nil
+	case "power_gpu":
+		return ColumnTarget{kind: columnPowerGPU}, nil
+	case "power_package":
+		return ColumnTarget{kind: columnPowerPackage}, nil
+	case "imc_reads":
+		return ColumnTarget{kind: columnIMCReads}, nil
+	case "imc_writes":
+		return ColumnTarget{kind: columnIMCWrites}, nil
+	case "ignore":
+		return ColumnTarget{kind: columnIgnore}, nil
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || parts[0] != "engine" || strings.TrimSpace(parts[1]) == "" {
+		return ColumnTarget{}, fmt.Errorf("unknown target %q", s)
+	}
+
+	switch parts[2] {
+	case "busy", "sema", "wait":
+	default:
+		return ColumnTarget{}, fmt.Errorf("unknown engine metric %q in target %q", parts[2], s)
+	}
+
+	return ColumnTarget{kind: columnEngine, engine: parts[1], metric: parts[2]}, nil
+}
+
+// LoadColumnMapping reads a mapping file with one "<column> = <target>"
+// entry per line, where target is freq_requested, freq_actual, irq, rc6,
+// power_gpu, power_package, imc_reads, imc_writes, ignore or
+// engine:<NAME>:<busy|sema|wait>. Blank lines and lines starting with # are
+// skipped.
+func LoadColumnMapping(path string) (ColumnMapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mapping := make(ColumnMapping)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		column, target, ok := strings.Cut(text, "=")
+		column, target = strings.TrimSpace(column), strings.TrimSpace(target)
+		if !ok || column == "" {
+			return nil, fmt.Errorf("%s:%d: expected <column> = <target>", path, line)
+		}
+
+		t, err := ParseColumnTarget(target)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		mapping[column] = t
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return mapping, nil
+}
@@ -0,0 +1,256 @@
+package igtparse
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/google/go-cmp/cmp"
+)
+
+// columnTargetEquals compares values containing the unexported ColumnTarget.
+var columnTargetEquals = qt.CmpEquals(cmp.AllowUnexported(ColumnTarget{}))
+
+func TestParserLayout(t *testing.T) {
+	c := qt.New(t)
+
+	header := []string{"Freq MHz req", "Freq MHz act", "IRQ /s", "RC6 %", "Render %", "Render se", "Render wa", "Mystery"}
+	mapping := ColumnMapping{
+		"Render %":  {kind: columnEngine, engine: "RCS", metric: "busy"},
+		"Render se": {kind: columnEngine, engine: "RCS", metric: "sema"},
+		"6":         {kind: columnEngine, engine: "RCS", metric: "wait"},
+		"IRQ /s":    {kind: columnIgnore},
+	}
+
+	p := &Parser{Mapping: mapping}
+	c.Assert(p.layout(header), columnTargetEquals, columnLayout{
+		{kind: columnFreqRequested},
+		{kind: columnFreqActual},
+		{kind: columnIgnore},
+		{kind: columnRc6},
+		{kind: columnEngine, engine: "RCS", metric: "busy"},
+		{kind: columnEngine, engine: "RCS", metric: "sema"},
+		{kind: columnEngine, engine: "RCS", metric: "wait"},
+		{kind: columnIgnore},
+	})
+}
+
+func TestParserLayoutDetectsEngines(t *testing.T) {
+	c := qt.New(t)
+
+	header := []string{"RC6 %", "CCS %", "CCS se", "CCS wa", "VCS/1 %", "Misc", " %", "se", ""}
+
+	c.Assert((&Parser{}).layout(header), columnTargetEquals, columnLayout{
+		{kind: columnRc6},
+		{kind: columnEngine, engine: "CCS", metric: "busy"},
+		{kind: columnEngine, engine: "CCS", metric: "sema"},
+		{kind: columnEngine, engine: "CCS", metric: "wait"},
+		{kind: columnEngine, engine: "VCS/1", metric: "busy"},
+		{kind: columnIgnore},
+		{kind: columnIgnore},
+		{kind: columnIgnore},
+		{kind: columnIgnore},
+	})
+}
+
+func TestUnmappedColumns(t *testing.T) {
+	c := qt.New(t)
+
+	// A mapping built from parsed targets, as a library user would
+	busy, err := ParseColumnTarget("engine:RCS:busy")
+	c.Assert(err, qt.IsNil)
+	ignore, err := ParseColumnTarget("ignore")
+	c.Assert(err, qt.IsNil)
+	p := &Parser{Mapping: ColumnMapping{"Render %": busy, "7": ignore}}
+
+	header := []string{"Freq MHz req", "RC6 %", "Render %", "Render busy", "CCS %", "Mystery", "", "Padding"}
+	c.Assert(p.UnmappedColumns(header), qt.DeepEquals, []string{"Render busy", "Mystery", ""})
+	c.Assert(p.UnmappedColumns(defaultHeader), qt.HasLen, 0)
+}
+
+func TestEngineNames(t *testing.T) {
+	c := qt.New(t)
+
+	names := []string{"RCS/0", "BCS", "VCS/0", "VCS/1", "VECS/1", "Mystery/x", "Mystery/x"}
+	c.Assert(EngineNames(names), qt.DeepEquals, map[string]string{
+		// A class's only instance loses its /0
+		"RCS/0": "RCS",
+		"BCS":   "BCS",
+		// Several instances keep theirs
+		"VCS/0": "VCS/0",
+		"VCS/1": "VCS/1",
+		// As does a lone instance other than the first
+		"VECS/1":    "VECS/1",
+		"Mystery/x": "Mystery/x",
+	})
+}
+
+func TestParserEngines(t *testing.T) {
+	c := qt.New(t)
+
+	// A GPU without a video enhancement engine
+	p := &Parser{Engines: []string{"RCS", "BCS", "VCS"}}
+
+	c.Assert(p.defaultHeader(), qt.DeepEquals, []string{
+		"Freq MHz req", "Freq MHz act", "IRQ /s", "RC6 %",
+		"RCS %", "RCS se", "RCS wa",
+		"BCS %", "BCS se", "BCS wa",
+		"VCS %", "VCS se", "VCS wa",
+	})
+
+	input := `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,VCS/1 %,VECS %,VECS se,VECS wa
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,7.0,0.0,0.0,0.0`
+	p.Mapping = ColumnMapping{"VECS %": {kind: columnEngine, engine: "VECS", metric: "busy"}}
+
+	results := parseAll(c, p, input)
+
+	// Only the explicitly mapped VECS column survives
+	c.Assert(results, qt.DeepEquals, []IntelTopStats{
+		{
+			FreqMhzRequested: 1200.0,
+			FreqMhzActual:    1150.0,
+			IRQPerSec:        500.0,
+			Rc6Percent:       85.5,
+			Engine: map[string]IntelEngine{
+				"RCS":   {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+				"VCS/1": {BusyPercent: 7.0},
+				"VECS":  {},
+			},
+		},
+	})
+}
+
+func TestParseEmptyEngineName(t *testing.T) {
+	c := qt.New(t)
+
+	// A stray column with no engine name before its suffix
+	input := `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa, %
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,42.0`
+
+	results := parseAll(c, &Parser{}, input)
+
+	c.Assert(results, qt.DeepEquals, []IntelTopStats{
+		{
+			FreqMhzRequested: 1200.0,
+			FreqMhzActual:    1150.0,
+			IRQPerSec:        500.0,
+			Rc6Percent:       85.5,
+			Engine: map[string]IntelEngine{
+				"RCS": {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+			},
+		},
+	})
+}
+
+func TestParseDuplicateColumns(t *testing.T) {
+	c := qt.New(t)
+
+	// RCS % and RC6 % each appear twice; the first of each wins
+	input := `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,RCS %,RC6 %
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,99.0,1.0`
+
+	results := parseAll(c, &Parser{}, input)
+
+	c.Assert(results, qt.DeepEquals, []IntelTopStats{
+		{
+			FreqMhzRequested: 1200.0,
+			FreqMhzActual:    1150.0,
+			IRQPerSec:        500.0,
+			Rc6Percent:       85.5,
+			Engine: map[string]IntelEngine{
+				"RCS": {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+			},
+		},
+	})
+}
+
+func TestParseCustomMapping(t *testing.T) {
+	c := qt.New(t)
+
+	input := `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,Render %,Render se,Render wa,Mystery
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,not-a-number`
+
+	mapping := ColumnMapping{
+		"Render %":  {kind: columnEngine, engine: "RCS", metric: "busy"},
+		"Render se": {kind: columnEngine, engine: "RCS", metric: "sema"},
+		"Render wa": {kind: columnEngine, engine: "RCS", metric: "wait"},
+	}
+
+	results := parseAll(c, &Parser{Mapping: mapping}, input)
+
+	c.Assert(results, qt.DeepEquals, []IntelTopStats{
+		{
+			FreqMhzRequested: 1200.0,
+			FreqMhzActual:    1150.0,
+			IRQPerSec:        500.0,
+			Rc6Percent:       85.5,
+			Engine: map[string]IntelEngine{
+				"RCS": {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+			},
+		},
+	})
+}
+
+func TestLoadColumnMapping(t *testing.T) {
+	c := qt.New(t)
+
+	tests := []struct {
+		name      string
+		content   string
+		expected  ColumnMapping
+		expectErr string
+	}{
+		{
+			name: "Valid",
+			content: `# Patched intel_gpu_top build
+Render % = engine:RCS:busy
+4=engine:RCS:sema
+
+IRQ /s = ignore
+Freq MHz act = freq_actual
+`,
+			expected: ColumnMapping{
+				"Render %":     {kind: columnEngine, engine: "RCS", metric: "busy"},
+				"4":            {kind: columnEngine, engine: "RCS", metric: "sema"},
+				"IRQ /s":       {kind: columnIgnore},
+				"Freq MHz act": {kind: columnFreqActual},
+			},
+		},
+		{
+			name:      "MissingSeparator",
+			content:   "Render % engine:RCS:busy\n",
+			expectErr: `.*:1: expected <column> = <target>`,
+		},
+		{
+			name:      "UnknownTarget",
+			content:   "# comment\nRender % = power\n",
+			expectErr: `.*:2: unknown target "power"`,
+		},
+		{
+			name:      "UnknownEngineMetric",
+			content:   "Render % = engine:RCS:idle\n",
+			expectErr: `.*:1: unknown engine metric "idle" in target "engine:RCS:idle"`,
+		},
+		{
+			name:      "BlankEngineName",
+			content:   "Render % = engine: :busy\n",
+			expectErr: `.*:1: unknown target "engine: :busy"`,
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			path := filepath.Join(c.TempDir(), "columns.conf")
+			c.Assert(os.WriteFile(path, []byte(tt.content), 0o644), qt.IsNil)
+
+			mapping, err := LoadColumnMapping(path)
+			if tt.expectErr != "" {
+				c.Assert(err, qt.ErrorMatches, tt.expectErr)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(mapping, columnTargetEquals, tt.expected)
+		})
+	}
+}
@@ -0,0 +1,286 @@
+// Package igtparse parses the CSV output of intel_gpu_top -c into
+// IntelTopStats samples.
+//
+// Columns are resolved from the most recent header line, so a header
+// reprinted mid-stream, e.g. after GPU hotplug, takes effect. Headers this
+// package doesn't recognise can be mapped with a ColumnMapping.
+package igtparse
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"strings"
+)
+
+// ErrImplausibleValue is wrapped by errors for a record holding a value
+// outside the parser's Limits.
+var ErrImplausibleValue = errors.New("implausible value")
+
+// ErrTruncated is wrapped by errors for a record that isn't a complete
+// sample, as written by an intel_gpu_top killed halfway through a line.
+var ErrTruncated = errors.New("truncated record")
+
+// Limits bounds the values a sample may plausibly hold. A zero limit puts no
+// upper bound on its values, though negative ones are always rejected.
+type Limits struct {
+	FreqMhz   float64
+	IRQPerSec float64
+	// Strict rejects records holding a percentage outside 0-100 rather
+	// than clamping it into range.
+	Strict bool
+}
+
+// DefaultLimits are far beyond any real GPU so only garbage from a flaky
+// driver is rejected.
+var DefaultLimits = Limits{FreqMhz: 10000, IRQPerSec: 10000000}
+
+// CheckFreq returns an error wrapping ErrImplausibleValue when value, a
+// frequency read from field, is negative or exceeds l.FreqMhz.
+func (l Limits) CheckFreq(field string, value float64) error {
+	return checkLimit(field, value, l.FreqMhz)
+}
+
+// CheckIRQ returns an error wrapping ErrImplausibleValue when value, an
+// interrupt rate read from field, is negative or exceeds l.IRQPerSec.
+func (l Limits) CheckIRQ(field string, value float64) error {
+	return checkLimit(field, value, l.IRQPerSec)
+}
+
+// CheckPercent returns value, a percentage read from field, clamped to
+// 0-100, as intel_gpu_top rounding can take a saturated engine slightly
+// over 100. With l.Strict a value out of range is an error wrapping
+// ErrImplausibleValue instead.
+func (l Limits) CheckPercent(field string, value float64) (float64, error) {
+	if value >= 0 && value <= 100 {
+		return value, nil
+	}
+	if l.Strict {
+		return 0, fmt.Errorf("%s (%g): %w", field, value, ErrImplausibleValue)
+	}
+	return min(max(value, 0), 100), nil
+}
+
+// checkLimit returns an error wrapping ErrImplausibleValue when value, read
+// from field, is negative or exceeds limit. A zero limit only rejects
+// negative values.
+func checkLimit(field string, value, limit float64) error {
+	if value < 0 || (limit > 0 && value > limit) {
+		return fmt.Errorf("%s (%g): %w", field, value, ErrImplausibleValue)
+	}
+	return nil
+}
+
+// RecordError is yielded by Parse for a record that couldn't be parsed.
+type RecordError struct {
+	// Line is the line of the input the record ended on.
+	Line int
+	// Record holds the record's fields, nil when it couldn't be split
+	// into fields.
+	Record []string
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Parser parses intel_gpu_top CSV output. The zero value accepts every
+// column it recognises and puts no upper bound on frequencies or interrupt
+// rates, but still skips records holding negative ones and clamps
+// percentages into 0-100.
+type Parser struct {
+	// Mapping overrides header auto-detection.
+	Mapping ColumnMapping
+	// Engines are the engine classes present on the GPU. Auto-detected
+	// columns for other engines are ignored so they don't publish spurious
+	// zero series, and the header assumed until the stream provides its
+	// own has columns for exactly these engines. Nil accepts every engine.
+	Engines []string
+	// Limits bounds the values a record may hold.
+	Limits Limits
+	// OnHeader, when set, is called with each header line the column
+	// layout is derived from.
+	OnHeader func(header []string)
+	// Logger receives warnings about columns that are ignored; nil uses
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// Parse parses r with DefaultLimits and no column mapping. See
+// Parser.Parse.
+func Parse(r io.Reader) iter.Seq2[IntelTopStats, error] {
+	p := &Parser{Limits: DefaultLimits}
+	return p.Parse(r)
+}
+
+// Parse returns the samples in r, intel_gpu_top CSV output. A record that
+// can't be parsed is yielded as a *RecordError. Parsing carries on after
+// errors wrapping ErrTruncated or ErrImplausibleValue, and ends after any
+// other error.
+func (p *Parser) Parse(r io.Reader) iter.Seq2[IntelTopStats, error] {
+	return func(yield func(IntelTopStats, error) bool) {
+		cr := csv.NewReader(r)
+		// Tolerate padding after separators, e.g. from wrappers reformatting
+		// the stream. Line endings, including \r\n, are handled by csv.
+		cr.TrimLeadingSpace = true
+		// Field counts are checked against the layout by parseRecord, so a
+		// short record is skipped instead of ending the stream, and a header
+		// reprinted with a different number of columns, e.g. after GPU
+		// hotplug, takes effect. The cost is that a record of the wrong
+		// length is only caught once it has been split into fields.
+		cr.FieldsPerRecord = -1
+		layout := p.layout(p.defaultHeader())
+
+		for {
+			record, err := cr.Read()
+			var parseErr *csv.ParseError
+			if errors.Is(err, io.EOF) {
+				return
+			} else if errors.As(err, &parseErr) {
+				// A line intel_gpu_top was killed halfway through writing
+				// can be malformed CSV, e.g. cut off in a quoted field.
+				// The reader carries on from the next line.
+				err = &RecordError{Line: parseErr.Line, Err: fmt.Errorf("%w: %w", ErrTruncated, parseErr.Err)}
+				if !yield(IntelTopStats{}, err) {
+					return
+				}
+				continue
+			} else if err != nil {
+				yield(IntelTopStats{}, err)
+				return
+			}
+
+			if header, ok := headerFields(record); ok {
+				// Header row, derive the column layout from it
+				layout = p.layout(header)
+				if p.OnHeader != nil {
+					p.OnHeader(header)
+				}
+				continue
+			}
+
+			stats, err := p.parseRecord(record, layout)
+			if err != nil {
+				line, _ := cr.FieldPos(0)
+				err = &RecordError{Line: line, Record: record, Err: err}
+				if !yield(IntelTopStats{}, err) {
+					return
+				}
+				if errors.Is(err, ErrTruncated) || errors.Is(err, ErrImplausibleValue) {
+					continue
+				}
+				return
+			}
+
+			if !yield(stats, nil) {
+				return
+			}
+		}
+	}
+}
+
+// logger returns the logger column warnings are emitted to.
+func (p *Parser) logger() *slog.Logger {
+	if p.Logger != nil {
+		return p.Logger
+	}
+	return slog.Default()
+}
+
+func updateEngineMetric(stats *IntelTopStats, engineName, metricType string, value float64) {
+	engine, ok := stats.Engine[engineName]
+	if !ok {
+		engine = IntelEngine{}
+	}
+
+	switch metricType {
+	case "busy":
+		engine.BusyPercent = value
+	case "sema":
+		engine.SemaPercent = value
+	case "wait":
+		engine.WaitPercent = value
+	}
+
+	stats.Engine[engineName] = engine
+}
+
+func (p *Parser) parseRecord(record []string, layout columnLayout) (IntelTopStats, error) {
+	if len(record) != len(layout) {
+		return IntelTopStats{}, fmt.Errorf("got %d fields, want %d: %w", len(record), len(layout), ErrTruncated)
+	}
+
+	var stats IntelTopStats
+	stats.Engine = make(map[string]IntelEngine)
+
+	for i, field := range record {
+		target := layout[i]
+		if target.kind == columnIgnore {
+			continue
+		}
+
+		// Trailing whitespace such as a stray \r from CRLF line endings
+		// would otherwise stick to the last field
+		field = strings.TrimSpace(field)
+
+		// A record cut off right after its last separator still has the
+		// full field count, but can't be a complete sample
+		if field == "" && i == len(record)-1 {
+			return IntelTopStats{}, fmt.Errorf("empty final field %d: %w", i, ErrTruncated)
+		}
+
+		var value float64
+		_, err := fmt.Sscanf(field, "%f", &value)
+		if err != nil {
+			return IntelTopStats{}, fmt.Errorf("error parsing field %d (%s): %v", i, field, err)
+		}
+
+		switch target.kind {
+		case columnFreqRequested:
+			if err := p.Limits.CheckFreq(fmt.Sprintf("field %d", i), value); err != nil {
+				return IntelTopStats{}, err
+			}
+			stats.FreqMhzRequested = value
+		case columnFreqActual:
+			if err := p.Limits.CheckFreq(fmt.Sprintf("field %d", i), value); err != nil {
+				return IntelTopStats{}, err
+			}
+			stats.FreqMhzActual = value
+		case columnIRQ:
+			if err := p.Limits.CheckIRQ(fmt.Sprintf("field %d", i), value); err != nil {
+				return IntelTopStats{}, err
+			}
+			stats.IRQPerSec = value
+		case columnRc6:
+			if value, err = p.Limits.CheckPercent(fmt.Sprintf("field %d", i), value); err != nil {
+				return IntelTopStats{}, err
+			}
+			stats.Rc6Percent = value
+		case columnPowerGPU:
+			stats.PowerGPUWatts = &value
+		case columnPowerPackage:
+			stats.PowerPackageWatts = &value
+		case columnIMCReads:
+			stats.IMCReadsMiBs = &value
+		case columnIMCWrites:
+			stats.IMCWritesMiBs = &value
+		case columnEngine:
+			if value, err = p.Limits.CheckPercent(fmt.Sprintf("field %d", i), value); err != nil {
+				return IntelTopStats{}, err
+			}
+			updateEngineMetric(&stats, target.engine, target.metric, value)
+		default:
+			return IntelTopStats{}, fmt.Errorf("unexpected target for field %d: %v", i, target.kind)
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,222 @@
+package igtparse
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// parseAll returns the samples p parses from input, which must all parse.
+func parseAll(c *qt.C, p *Parser, input string) []IntelTopStats {
+	c.Helper()
+	var results []IntelTopStats
+	for stats, err := range p.Parse(strings.NewReader(input)) {
+		c.Assert(err, qt.IsNil)
+		results = append(results, stats)
+	}
+	return results
+}
+
+func TestParserParseRecord(t *testing.T) {
+	tests := []struct {
+		name      string
+		record    []string
+		expected  IntelTopStats
+		expectErr bool
+		errMsg    string
+	}{
+		{
+			name: "valid input",
+			// RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
+			record: []string{"1000.123", "95.1230", "500.23", "80.5", "3.2", "12.3", "13.2", "23.5", "23.3", "12.2", "10.3", "6.3", "5.5", "90.1", "12.3", "10.2"},
+			expected: IntelTopStats{
+				FreqMhzRequested: 1000.123,
+				FreqMhzActual:    95.1230,
+				IRQPerSec:        500.23,
+				Rc6Percent:       80.5,
+				Engine: map[string]IntelEngine{
+					"RCS":  {BusyPercent: 3.2, SemaPercent: 12.3, WaitPercent: 13.2},
+					"BCS":  {BusyPercent: 23.5, SemaPercent: 23.3, WaitPercent: 12.2},
+					"VCS":  {BusyPercent: 10.3, SemaPercent: 6.3, WaitPercent: 5.5},
+					"VECS": {BusyPercent: 90.1, SemaPercent: 12.3, WaitPercent: 10.2},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name:   "PaddedFields",
+			record: []string{" 1000.123", "95.1230 ", "500.23", "80.5", "3.2", "12.3", "13.2", "23.5", "23.3", "12.2", "10.3", "6.3", "5.5", "90.1", "12.3", "10.2\r"},
+			expected: IntelTopStats{
+				FreqMhzRequested: 1000.123,
+				FreqMhzActual:    95.1230,
+				IRQPerSec:        500.23,
+				Rc6Percent:       80.5,
+				Engine: map[string]IntelEngine{
+					"RCS":  {BusyPercent: 3.2, SemaPercent: 12.3, WaitPercent: 13.2},
+					"BCS":  {BusyPercent: 23.5, SemaPercent: 23.3, WaitPercent: 12.2},
+					"VCS":  {BusyPercent: 10.3, SemaPercent: 6.3, WaitPercent: 5.5},
+					"VECS": {BusyPercent: 90.1, SemaPercent: 12.3, WaitPercent: 10.2},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name:      "InvalidNumberOfFields",
+			record:    []string{"1000", "950"}, // too few fields
+			expectErr: true,
+			errMsg:    "got 2 fields, want 16: truncated record",
+		},
+		{
+			name:      "NonNumericField",
+			record:    []string{"1000", "abc", "500", "80.5", "3.2", "0.0", "0.0", "0.0", "0.0", "0.0", "0.0", "0.0", "0.0", "0.0", "0.0", "0.0"},
+			expectErr: true,
+			errMsg:    `error parsing field 1 \(abc\): .*`,
+		},
+		{
+			name:      "EmptyInput",
+			record:    []string{},
+			expectErr: true,
+			errMsg:    "got 0 fields, want 16: truncated record",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+			p := &Parser{}
+			s, err := p.parseRecord(tt.record, p.layout(defaultHeader))
+			if tt.expectErr {
+				c.Assert(err, qt.ErrorMatches, tt.errMsg)
+			} else {
+				c.Assert(err, qt.IsNil)
+				c.Assert(s, qt.DeepEquals, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	header := "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa\n"
+	valid := "1200.0,1150.0,500.0,85.5,10.2,5.1,2.3\n"
+
+	tests := []struct {
+		name    string
+		input   string
+		samples int
+		errs    []string
+		// lines are the RecordError lines of errs.
+		lines []int
+	}{
+		{
+			name:    "Valid",
+			input:   header + valid + valid,
+			samples: 2,
+		},
+		{
+			name:    "ShortRecord",
+			input:   header + "1200.0,1150.0\n" + valid,
+			samples: 1,
+			errs:    []string{"line 2: got 2 fields, want 7: truncated record"},
+			lines:   []int{2},
+		},
+		{
+			name:    "Implausible",
+			input:   header + "1200.0,9e15,500.0,85.5,10.2,5.1,2.3\n" + valid,
+			samples: 1,
+			errs:    []string{`line 2: field 1 \(9e\+15\): implausible value`},
+			lines:   []int{2},
+		},
+		{
+			name:    "MalformedCSV",
+			input:   header + valid + "1200.0,\"11\"50.0,500.0\n" + valid,
+			samples: 2,
+			errs:    []string{`line 3: truncated record: extraneous or missing " in quoted-field`},
+			lines:   []int{3},
+		},
+		{
+			// Anything else is unexpected enough to end parsing
+			name:    "NonNumeric",
+			input:   header + "1200.0,abc,500.0,85.5,10.2,5.1,2.3\n" + valid,
+			samples: 0,
+			errs:    []string{`line 2: error parsing field 1 \(abc\): .*`},
+			lines:   []int{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			var samples int
+			var errs []string
+			var lines []int
+			for _, err := range Parse(strings.NewReader(tt.input)) {
+				if err == nil {
+					samples++
+					continue
+				}
+				errs = append(errs, err.Error())
+				var recordErr *RecordError
+				c.Assert(errors.As(err, &recordErr), qt.IsTrue)
+				lines = append(lines, recordErr.Line)
+			}
+
+			c.Assert(samples, qt.Equals, tt.samples)
+			c.Assert(errs, qt.HasLen, len(tt.errs))
+			for i, err := range errs {
+				c.Assert(err, qt.Matches, tt.errs[i])
+			}
+			c.Assert(lines, qt.DeepEquals, tt.lines)
+		})
+	}
+}
+
+func TestParserOnHeader(t *testing.T) {
+	c := qt.New(t)
+
+	var headers [][]string
+	p := &Parser{OnHeader: func(header []string) { headers = append(headers, header) }}
+	input := "Freq MHz req, RC6 %\n300.0,99.5\nFreq MHz req,RCS %\n300.0,1.5\n"
+
+	c.Assert(parseAll(c, p, input), qt.DeepEquals, []IntelTopStats{
+		{FreqMhzRequested: 300, Rc6Percent: 99.5, Engine: map[string]IntelEngine{}},
+		{FreqMhzRequested: 300, Engine: map[string]IntelEngine{"RCS": {BusyPercent: 1.5}}},
+	})
+	c.Assert(headers, qt.DeepEquals, [][]string{{"Freq MHz req", "RC6 %"}, {"Freq MHz req", "RCS %"}})
+}
+
+func TestLimitsCheckPercent(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  float64
+		strict bool
+		want   float64
+		err    bool
+	}{
+		{name: "InRange", value: 42.5, want: 42.5},
+		{name: "Full", value: 100, want: 100},
+		{name: "Negative", value: -1, want: 0},
+		{name: "Rounding", value: 100.4, want: 100},
+		{name: "Absurd", value: 9999, want: 100},
+		{name: "StrictInRange", value: 42.5, strict: true, want: 42.5},
+		{name: "StrictNegative", value: -1, strict: true, err: true},
+		{name: "StrictAbsurd", value: 9999, strict: true, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			limits := Limits{Strict: tt.strict}
+			got, err := limits.CheckPercent("RCS %", tt.value)
+			if tt.err {
+				c.Assert(err, qt.ErrorIs, ErrImplausibleValue)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(got, qt.Equals, tt.want)
+		})
+	}
+}
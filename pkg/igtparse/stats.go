@@ -0,0 +1,50 @@
+package igtparse
+
+// IntelTopStats is one intel_gpu_top sample. Percentages are on a 0-100
+// scale.
+type IntelTopStats struct {
+	// Device identifies the GPU the sample describes. Parse leaves it empty
+	// for the caller to fill in.
+	Device           string
+	FreqMhzRequested float64
+	FreqMhzActual    float64
+	IRQPerSec        float64
+	Rc6Percent       float64
+	// Rc6StatePercent holds the residency of deeper RC6 states, e.g. rc6p
+	// and rc6pp, by state name, on platforms reporting them. Only JSON
+	// output does, so Parse leaves it nil.
+	Rc6StatePercent map[string]float64
+	Engine          map[string]IntelEngine
+	// PowerGPUWatts and PowerPackageWatts are nil when not reported, e.g.
+	// on kernels without GPU energy counters.
+	PowerGPUWatts     *float64
+	PowerPackageWatts *float64
+	// IMCReadsMiBs and IMCWritesMiBs are nil unless the header has IMC
+	// bandwidth columns, which only client platforms have.
+	IMCReadsMiBs  *float64
+	IMCWritesMiBs *float64
+	// FanRPM and VoltageVolts are nil when not reported, which is always
+	// the case for CSV output.
+	FanRPM       *float64
+	VoltageVolts *float64
+	// Clients are the processes using the GPU, only reported in JSON
+	// output.
+	Clients []IntelClient
+}
+
+// IntelEngine is the utilisation of one engine, e.g. RCS or VCS/1.
+type IntelEngine struct {
+	BusyPercent float64
+	SemaPercent float64
+	WaitPercent float64
+}
+
+// IntelClient is a process using the GPU, from the clients section of
+// intel_gpu_top -J output.
+type IntelClient struct {
+	PID  int
+	Name string
+	// EngineBusyPercent holds the busy percentage of each engine class the
+	// client uses, by the same class names as IntelTopStats.Engine.
+	EngineBusyPercent map[string]float64
+}
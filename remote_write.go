@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteJob is the job label attached to every pushed series.
+const remoteWriteJob = "intel-gpu-exporter"
+
+// remoteWriteTimeout bounds a single push to the remote endpoint.
+const remoteWriteTimeout = 10 * time.Second
+
+// remoteWriteDrainTimeout bounds pushing the samples still queued at
+// shutdown.
+const remoteWriteDrainTimeout = 5 * time.Second
+
+type remoteWriteLabel struct {
+	name, value string
+}
+
+type remoteWriteSeries struct {
+	labels []remoteWriteLabel
+	value  float64
+}
+
+// remoteWriteRequest is a batch of series sharing a single timestamp.
+type remoteWriteRequest struct {
+	series    []remoteWriteSeries
+	timestamp int64
+}
+
+// remoteWriteSink pushes samples to a Prometheus remote_write endpoint. Samples
+// are queued in a bounded buffer and pushed by Run so a slow endpoint never
+// blocks collection; when the buffer is full new samples are dropped.
+type remoteWriteSink struct {
+	url      string
+	username string
+	password string
+	instance string
+	// splitEngineInstance labels engines as -split-engine-instance does.
+	splitEngineInstance bool
+	// namespace prefixes metric names as -namespace does.
+	namespace string
+	// engineOccupancy adds engine occupancy as -engine-occupancy does.
+	engineOccupancy bool
+	// fullScale is the value of a fully busy engine, 1 with -fraction.
+	fullScale float64
+	client    *http.Client
+	queue     chan remoteWriteRequest
+}
+
+func newRemoteWriteSink(url, username, password string, bufferSize int) *remoteWriteSink {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "unknown"
+	}
+
+	return &remoteWriteSink{
+		url:       url,
+		username:  username,
+		password:  password,
+		instance:  instance,
+		namespace: defaultNamespace,
+		fullScale: 100,
+		client:    &http.Client{Timeout: remoteWriteTimeout},
+		queue:     make(chan remoteWriteRequest, bufferSize),
+	}
+}
+
+func (s *remoteWriteSink) Update(stats IntelTopStats, prev *IntelTopStats) {
+	now := time.Now()
+	req := remoteWriteRequest{
+		series:    s.statsSeries(stats, prev, now),
+		timestamp: now.UnixMilli(),
+	}
+
+	select {
+	case s.queue <- req:
+	default:
+		SamplesDroppedCounter.WithLabelValues("remote_write").Inc()
+	}
+}
+
+// Run pushes queued samples until ctx is cancelled, then pushes those still
+// queued so stopping the exporter doesn't lose them. A push in flight when
+// ctx is cancelled is left to finish, bounded by remoteWriteTimeout.
+func (s *remoteWriteSink) Run(ctx context.Context) {
+	pushCtx := context.WithoutCancel(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			s.drain()
+			return
+		case req := <-s.queue:
+			if err := s.push(pushCtx, req); err != nil {
+				slog.Error("Error pushing to remote write endpoint", "err", err)
+			}
+		}
+	}
+}
+
+// drain pushes the samples queued, with a context of its own as the one Run
+// was given is already cancelled. Samples still queued once
+// remoteWriteDrainTimeout has passed are given up on.
+func (s *remoteWriteSink) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteWriteDrainTimeout)
+	defer cancel()
+
+	for {
+		select {
+		case req := <-s.queue:
+			if err := s.push(ctx, req); err != nil {
+				slog.Error("Error pushing to remote write endpoint", "err", err)
+			}
+			if ctx.Err() != nil {
+				slog.Warn("Gave up pushing queued samples at shutdown", "samples", len(s.queue))
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (s *remoteWriteSink) push(ctx context.Context, req remoteWriteRequest) error {
+	body := snappy.Encode(nil, s.encode(req))
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if s.username != "" || s.password != "" {
+		httpReq.SetBasicAuth(s.username, s.password)
+	}
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// statsSeries flattens stats, received at now, into the series published by
+// the Prometheus sink without -compact, -ratio or the families it disables,
+// and without the job and instance labels. Client series aren't pushed, as
+// short-lived processes would each leave a series behind in remote storage.
+func (s *remoteWriteSink) statsSeries(stats IntelTopStats, prev *IntelTopStats, now time.Time) []remoteWriteSeries {
+	metricName := func(name string) remoteWriteLabel {
+		return remoteWriteLabel{"__name__", prometheus.BuildFQName(s.namespace, "", name)}
+	}
+	optional := func(name string, value *float64) []remoteWriteSeries {
+		if value == nil {
+			return nil
+		}
+		return []remoteWriteSeries{{labels: []remoteWriteLabel{metricName(name)}, value: *value}}
+	}
+
+	series := []remoteWriteSeries{
+		{labels: []remoteWriteLabel{metricName("freq_mhz_requested")}, value: stats.FreqMhzRequested},
+		{labels: []remoteWriteLabel{metricName("freq_mhz_actual")}, value: stats.FreqMhzActual},
+		{labels: []remoteWriteLabel{metricName("freq_mhz_throttle_gap")}, value: stats.FreqMhzRequested - stats.FreqMhzActual},
+		{labels: []remoteWriteLabel{metricName("irq_per_sec")}, value: stats.IRQPerSec},
+		{labels: []remoteWriteLabel{metricName("rc6_percent"), {"state", "rc6"}}, value: stats.Rc6Percent},
+		{labels: []remoteWriteLabel{metricName("busy_percent")}, value: gpuBusy(stats)},
+		{labels: []remoteWriteLabel{metricName("exporter_last_sample_timestamp_seconds")}, value: float64(now.UnixNano()) / 1e9},
+	}
+	for state, value := range stats.Rc6StatePercent {
+		series = append(series, remoteWriteSeries{
+			labels: []remoteWriteLabel{metricName("rc6_percent"), {"state", state}},
+			value:  value,
+		})
+	}
+	series = slices.Concat(series,
+		optional("power_gpu_watts", stats.PowerGPUWatts),
+		optional("power_package_watts", stats.PowerPackageWatts),
+		optional("imc_reads_mib_per_sec", stats.IMCReadsMiBs),
+		optional("imc_writes_mib_per_sec", stats.IMCWritesMiBs),
+		optional("fan_rpm", stats.FanRPM),
+		optional("voltage_volts", stats.VoltageVolts),
+	)
+	if prev != nil {
+		series = append(series, remoteWriteSeries{
+			labels: []remoteWriteLabel{metricName("irq_delta")},
+			value:  stats.IRQPerSec - prev.IRQPerSec,
+		})
+	}
+
+	for name, engine := range stats.Engine {
+		// The device label is added to every series below
+		names := engineLabelNames(s.splitEngineInstance)[1:]
+		var engineLabels []remoteWriteLabel
+		for i, value := range engineLabelValues(s.splitEngineInstance, stats.Device, name)[1:] {
+			engineLabels = append(engineLabels, remoteWriteLabel{names[i], value})
+		}
+
+		for _, m := range []struct {
+			kind  string
+			value float64
+		}{
+			{"busy", engine.BusyPercent},
+			{"sema", engine.SemaPercent},
+			{"wait", engine.WaitPercent},
+		} {
+			series = append(series, remoteWriteSeries{
+				labels: slices.Concat([]remoteWriteLabel{metricName("engine_percent")}, engineLabels, []remoteWriteLabel{{"type", m.kind}}),
+				value:  m.value,
+			})
+		}
+		series = append(series, remoteWriteSeries{
+			labels: slices.Concat([]remoteWriteLabel{metricName("engine_sema_wait_ratio")}, engineLabels),
+			value:  semaWaitRatio(engine),
+		})
+		if s.engineOccupancy {
+			series = append(series, remoteWriteSeries{
+				labels: slices.Concat([]remoteWriteLabel{metricName("engine_occupancy_percent")}, engineLabels),
+				value:  occupancy(engine, s.fullScale),
+			})
+		}
+	}
+
+	// An empty device is the same as no label, so it is only sent when set
+	if stats.Device != "" {
+		for i := range series {
+			series[i].labels = append(series[i].labels, remoteWriteLabel{deviceLabel, stats.Device})
+		}
+	}
+
+	return series
+}
+
+// encode marshals req as a prometheus.WriteRequest protobuf message.
+func (s *remoteWriteSink) encode(req remoteWriteRequest) []byte {
+	var buf []byte
+	for _, series := range req.series {
+		var ts []byte
+		labels := slices.Concat(series.labels, []remoteWriteLabel{{"instance", s.instance}, {"job", remoteWriteJob}})
+		slices.SortFunc(labels, func(a, b remoteWriteLabel) int { return strings.Compare(a.name, b.name) })
+		for _, l := range labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(series.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(req.timestamp))
+
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+	return buf
+}
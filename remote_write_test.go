@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestRemoteWriteSinkCompresses(t *testing.T) {
+	c := qt.New(t)
+
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := newRemoteWriteSink(server.URL, "", "", 1)
+	engines := make(map[string]IntelEngine)
+	for _, name := range []string{"RCS", "BCS", "VCS/0", "VCS/1", "VECS", "CCS"} {
+		engines[name] = IntelEngine{BusyPercent: 10}
+	}
+	sink.Update(IntelTopStats{Engine: engines}, nil)
+	req := <-sink.queue
+
+	c.Assert(sink.push(context.Background(), req), qt.IsNil)
+	decoded, err := snappy.Decode(nil, body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(decoded, qt.DeepEquals, sink.encode(req))
+	// The repeated metric and label names compress well
+	c.Assert(len(body) < len(decoded)/2, qt.IsTrue, qt.Commentf("%d of %d bytes", len(body), len(decoded)))
+}
+
+func TestRemoteWriteSinkPush(t *testing.T) {
+	c := qt.New(t)
+
+	var (
+		header http.Header
+		body   []byte
+		user   string
+		pass   string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		user, pass, _ = r.BasicAuth()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := newRemoteWriteSink(server.URL, "prom", "secret", 1)
+	stats := IntelTopStats{
+		FreqMhzRequested: 1200.0,
+		FreqMhzActual:    1150.0,
+		IRQPerSec:        500.0,
+		Rc6Percent:       85.5,
+		Engine: map[string]IntelEngine{
+			"RCS": {BusyPercent: 10.2, SemaPercent: 5.1, WaitPercent: 2.3},
+		},
+	}
+	sink.Update(stats, nil)
+
+	err := sink.push(context.Background(), <-sink.queue)
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(header.Get("Content-Encoding"), qt.Equals, "snappy")
+	c.Assert(header.Get("Content-Type"), qt.Equals, "application/x-protobuf")
+	c.Assert(header.Get("X-Prometheus-Remote-Write-Version"), qt.Equals, "0.1.0")
+	c.Assert(user, qt.Equals, "prom")
+	c.Assert(pass, qt.Equals, "secret")
+
+	// Seven device-wide series plus busy/sema/wait and the sema/wait ratio
+	// for the single engine
+	decoded, err := snappy.Decode(nil, body)
+	c.Assert(err, qt.IsNil)
+	msg := decoded
+	series := 0
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeField(msg)
+		c.Assert(n > 0, qt.IsTrue)
+		c.Assert(num, qt.Equals, protowire.Number(1))
+		c.Assert(typ, qt.Equals, protowire.BytesType)
+		msg = msg[n:]
+		series++
+	}
+	c.Assert(series, qt.Equals, 11)
+
+	for _, want := range []string{"intel_gpu_freq_mhz_actual", "intel_gpu_engine_percent", "RCS", "job", remoteWriteJob} {
+		c.Assert(bytes.Contains(decoded, []byte(want)), qt.IsTrue, qt.Commentf("missing %q", want))
+	}
+}
+
+func TestRemoteWriteSinkPushError(t *testing.T) {
+	c := qt.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	sink := newRemoteWriteSink(server.URL, "", "", 1)
+	sink.Update(IntelTopStats{}, nil)
+
+	err := sink.push(context.Background(), <-sink.queue)
+	c.Assert(err, qt.ErrorMatches, "unexpected status 400 Bad Request: out of order sample")
+}
+
+func TestRemoteWriteSinkDropsWhenFull(t *testing.T) {
+	c := qt.New(t)
+
+	dropped := SamplesDroppedCounter.WithLabelValues("remote_write")
+	before := testutil.ToFloat64(dropped)
+
+	// Nothing drains the queue, so everything past the first sample is dropped
+	sink := newRemoteWriteSink("http://127.0.0.1:0", "", "", 1)
+	for range 3 {
+		sink.Update(IntelTopStats{}, nil)
+	}
+
+	c.Assert(len(sink.queue), qt.Equals, 1)
+	c.Assert(testutil.ToFloat64(dropped)-before, qt.Equals, 2.0)
+}
+
+func TestRemoteWriteSinkRunDrainsOnShutdown(t *testing.T) {
+	c := qt.New(t)
+
+	var pushes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := newRemoteWriteSink(server.URL, "", "", 3)
+	for range 3 {
+		sink.Update(IntelTopStats{}, nil)
+	}
+
+	// Already cancelled, as on SIGTERM, yet the queued samples are pushed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sink.Run(ctx)
+
+	c.Assert(pushes.Load(), qt.Equals, int32(3))
+	c.Assert(sink.queue, qt.HasLen, 0)
+}
+
+func TestStatsSeriesDevice(t *testing.T) {
+	c := qt.New(t)
+
+	sink := newRemoteWriteSink("http://127.0.0.1:0", "", "", 1)
+	stats := IntelTopStats{Engine: map[string]IntelEngine{"RCS": {}}}
+	for _, device := range []string{"", "0000:03:00.0"} {
+		stats.Device = device
+		series := sink.statsSeries(stats, nil, time.Now())
+		c.Assert(series, qt.HasLen, 11)
+		for _, s := range series {
+			var got []string
+			for _, l := range s.labels {
+				if l.name == deviceLabel {
+					got = append(got, l.value)
+				}
+			}
+			// An empty device is left out rather than sent as an empty label
+			if device == "" {
+				c.Assert(got, qt.HasLen, 0)
+			} else {
+				c.Assert(got, qt.DeepEquals, []string{device})
+			}
+		}
+	}
+}
+
+func TestStatsSeriesMatchesCollector(t *testing.T) {
+	c := qt.New(t)
+
+	fan, volts := 1200.0, 0.9
+	stats := IntelTopStats{
+		FreqMhzActual: 1150,
+		FanRPM:        &fan,
+		VoltageVolts:  &volts,
+		Engine: map[string]IntelEngine{
+			"RCS": {BusyPercent: 70, SemaPercent: 30, WaitPercent: 10},
+			"VCS": {BusyPercent: 20},
+		},
+	}
+	now := time.Unix(1700000000, 500000000)
+
+	sink := newRemoteWriteSink("http://127.0.0.1:0", "", "", 1)
+	sink.engineOccupancy = true
+	got := make(map[string]float64)
+	for _, s := range sink.statsSeries(stats, nil, now) {
+		var key []string
+		for _, l := range s.labels {
+			key = append(key, l.name+"="+l.value)
+		}
+		got[strings.Join(key, ",")] = s.value
+	}
+
+	for key, want := range map[string]float64{
+		"__name__=intel_gpu_busy_percent":                           70,
+		"__name__=intel_gpu_fan_rpm":                                1200,
+		"__name__=intel_gpu_voltage_volts":                          0.9,
+		"__name__=intel_gpu_exporter_last_sample_timestamp_seconds": 1700000000.5,
+		"__name__=intel_gpu_engine_sema_wait_ratio,engine=RCS":      0.75,
+		"__name__=intel_gpu_engine_sema_wait_ratio,engine=VCS":      0,
+		"__name__=intel_gpu_engine_occupancy_percent,engine=RCS":    100,
+		"__name__=intel_gpu_engine_occupancy_percent,engine=VCS":    20,
+		"__name__=intel_gpu_engine_percent,engine=RCS,type=busy":    70,
+	} {
+		c.Assert(got[key], qt.Equals, want, qt.Commentf("%s", key))
+	}
+
+	// Every series is one the Prometheus sink publishes for the same sample
+	col := newCollector(collectorOptions{namespace: defaultNamespace, engineOccupancy: true})
+	col.now = func() time.Time { return now }
+	col.Update(stats, nil)
+	published := make(map[string]bool)
+	mfs, err := col.registry.Gather()
+	c.Assert(err, qt.IsNil)
+	for _, mf := range mfs {
+		published[mf.GetName()] = true
+	}
+	for key := range got {
+		name := strings.TrimPrefix(strings.Split(key, ",")[0], "__name__=")
+		c.Assert(published[name], qt.IsTrue, qt.Commentf("%s is not published by the Prometheus sink", name))
+	}
+}
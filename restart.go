@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+// Bounds of the delay before relaunching intel_gpu_top after it exits.
+const (
+	minRestartBackoff = time.Second
+	maxRestartBackoff = 30 * time.Second
+)
+
+// restartBackoff paces relaunching intel_gpu_top after it exits, e.g. when
+// killed by a driver reload. The delay doubles with each consecutive exit
+// up to max, so a binary that fails straight away isn't relaunched in a
+// tight loop, and drops back to min once a run produced samples.
+type restartBackoff struct {
+	min, max time.Duration
+
+	next time.Duration
+}
+
+// Delay returns how long to wait before the next relaunch. sampled reports
+// whether the run that just ended published any samples.
+func (b *restartBackoff) Delay(sampled bool) time.Duration {
+	if sampled || b.next == 0 {
+		b.next = b.min
+	}
+	delay := b.next
+	b.next = min(b.next*2, b.max)
+	return delay
+}
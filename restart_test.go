@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	c := qt.New(t)
+
+	b := &restartBackoff{min: time.Second, max: 5 * time.Second}
+	steps := []struct {
+		sampled bool
+		want    time.Duration
+	}{
+		{sampled: false, want: time.Second},
+		{sampled: false, want: 2 * time.Second},
+		{sampled: false, want: 4 * time.Second},
+		{sampled: false, want: 5 * time.Second},
+		{sampled: false, want: 5 * time.Second},
+		{sampled: true, want: time.Second},
+		{sampled: false, want: 2 * time.Second},
+	}
+	for i, step := range steps {
+		c.Assert(b.Delay(step.sampled), qt.Equals, step.want, qt.Commentf("step %d", i))
+	}
+}
+
+func TestRunGPUTopRestartsAfterExit(t *testing.T) {
+	c := qt.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	output := "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa\n1200.0,1150.0,500.0,10.0,80.0,0.0,0.0\n"
+	runner := &fakeRunner{
+		outputs:     slices.Repeat([]string{output}, 3),
+		waitErr:     errors.New("exit status 1"),
+		cancel:      cancel,
+		cancelAfter: 3,
+	}
+	recorder := &eventRecorder{}
+	opts := collectOptions{
+		interval: time.Second,
+		backoff:  &restartBackoff{min: time.Millisecond, max: time.Millisecond},
+		logger:   slog.New(recorder),
+	}
+
+	before := testutil.ToFloat64(GPUTopRestartsCounter)
+	runGPUTop(ctx, cancel, runner, discardSink{}, opts)
+
+	// The last run is cut short by cancellation, which isn't restarted
+	c.Assert(runner.starts, qt.Equals, 3)
+	c.Assert(testutil.ToFloat64(GPUTopRestartsCounter)-before, qt.Equals, 2.0)
+	c.Assert(recorder.events, qt.DeepEquals, []string{
+		"start", "first_sample", "crash", "restart",
+		"start", "first_sample", "crash", "restart",
+		"start", "exit", "shutdown",
+	})
+}
+
+func TestRunGPUTopShutdownDuringBackoff(t *testing.T) {
+	c := qt.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Cancelled while waiting to relaunch, as on SIGTERM
+	runner := &fakeRunner{startErr: errors.New("not found")}
+	opts := collectOptions{
+		interval: time.Second,
+		backoff:  &restartBackoff{min: time.Hour, max: time.Hour},
+	}
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	done := make(chan struct{})
+	go func() {
+		runGPUTop(ctx, cancel, runner, discardSink{}, opts)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("runGPUTop didn't return after cancellation")
+	}
+}
+
+func TestCollectReadTimeout(t *testing.T) {
+	c := qt.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// One record, then the pipe stays open but quiet
+	runner, w := newStreamingRunner()
+	go io.WriteString(w, "Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa\n1200.0,1150.0,500.0,10.0,80.0,0.0,0.0\n")
+
+	recorder := &eventRecorder{}
+	opts := collectOptions{
+		interval:    time.Millisecond,
+		readTimeout: 50 * time.Millisecond,
+		logger:      slog.New(recorder),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		runGPUTop(ctx, cancel, runner, discardSink{}, opts)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("quiet intel_gpu_top was never killed")
+	}
+	c.Assert(recorder.events, qt.DeepEquals, []string{"start", "first_sample", "stall", "exit"})
+}
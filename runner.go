@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// stderrLimit bounds how much of intel_gpu_top's stderr is kept for logging.
+const stderrLimit = 4096
+
+// defaultTermTimeout is how long intel_gpu_top gets to exit after SIGTERM
+// before it is sent SIGKILL, unless -term-timeout says otherwise.
+const defaultTermTimeout = 2 * time.Second
+
+// pipeCloseDelay is how long Wait waits for intel_gpu_top's pipes to close
+// once its process group has been sent SIGKILL, in case something outside
+// the group still holds them.
+const pipeCloseDelay = 5 * time.Second
+
+// gpuTopRunner launches intel_gpu_top and streams its CSV output. It is an
+// interface so tests can substitute canned output for the real process.
+type gpuTopRunner interface {
+	// Start launches the process sampling every interval and returns its
+	// stdout. The process is killed when ctx is cancelled.
+	Start(ctx context.Context, interval time.Duration) (io.ReadCloser, error)
+	// Wait blocks until the process exits, reaping it, and returns its
+	// exit status.
+	Wait() error
+}
+
+// execRunner runs intel_gpu_top as a child process. The process runs in a
+// process group of its own, and cancellation signals the whole group so
+// nothing it launched, such as intel_gpu_top under a wrapper script or
+// sudo, is orphaned and keeps polling the GPU. Killing intel_gpu_top
+// outright can leave the GPU's perf counters in a bad state on some
+// kernels, so the group is sent SIGTERM first and only SIGKILL if it is
+// still running after termTimeout.
+type execRunner struct {
+	name        string
+	args        []string
+	termTimeout time.Duration
+	cmd         *exec.Cmd
+	stderr      cappedBuffer
+
+	mu   sync.Mutex
+	kill *time.Timer
+}
+
+func newExecRunner(name string, args ...string) *execRunner {
+	return &execRunner{name: name, args: args, termTimeout: defaultTermTimeout}
+}
+
+// newSudoRunner runs name through sudo -n, which fails rather than prompting
+// for a password as there is no TTY to prompt on. intel_gpu_top then runs as
+// root, out of reach of an unprivileged exporter's SIGKILL, but sudo relays
+// the SIGTERM the group is sent first to it.
+func newSudoRunner(name string, args ...string) *execRunner {
+	return newExecRunner("sudo", append([]string{"-n", name}, args...)...)
+}
+
+func (r *execRunner) Start(ctx context.Context, interval time.Duration) (io.ReadCloser, error) {
+	args := append(slices.Clone(r.args), "-s", strconv.FormatInt(interval.Milliseconds(), 10))
+
+	r.stderr.Reset()
+	r.cmd = exec.CommandContext(ctx, r.name, args...)
+	r.cmd.Stderr = &r.stderr
+	newProcessGroup(r.cmd)
+	r.cmd.Cancel = r.terminate
+	r.cmd.WaitDelay = r.termTimeout + pipeCloseDelay
+
+	stdout, err := r.cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating stdout pipe: %w", err)
+	}
+
+	if err := r.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting %s: %w", r.name, err)
+	}
+
+	return stdout, nil
+}
+
+// terminate sends the process group SIGTERM, arming a timer that sends it
+// SIGKILL unless Wait reaps the process within termTimeout. A zero
+// termTimeout sends SIGKILL straight away.
+func (r *execRunner) terminate() error {
+	cmd := r.cmd
+	if r.termTimeout <= 0 {
+		slog.Info("Killing process group due to context cancellation", "binary", r.name)
+		return killProcessGroup(cmd)
+	}
+
+	slog.Info("Terminating process group due to context cancellation", "binary", r.name)
+	r.mu.Lock()
+	r.kill = time.AfterFunc(r.termTimeout, func() {
+		slog.Warn("Process group still running after SIGTERM, killing it", "binary", r.name, "term_timeout", r.termTimeout)
+		killProcessGroup(cmd)
+	})
+	r.mu.Unlock()
+	return terminateProcessGroup(cmd)
+}
+
+func (r *execRunner) Wait() error {
+	err := r.cmd.Wait()
+
+	// Once reaped the group ID may be reused, so it must not be killed
+	r.mu.Lock()
+	if r.kill != nil {
+		r.kill.Stop()
+		r.kill = nil
+	}
+	r.mu.Unlock()
+
+	if err != nil && r.stderr.Len() > 0 {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(r.stderr.Bytes()))
+	}
+	return err
+}
+
+// cappedBuffer keeps the first stderrLimit bytes written to it and silently
+// discards the rest.
+type cappedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := stderrLimit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// readerRunner is a gpuTopRunner reading an existing stream, such as stdin
+// piped from an externally managed intel_gpu_top, instead of launching it.
+type readerRunner struct {
+	r io.Reader
+}
+
+// Start returns the stream itself when it can be closed, as os.Stdin can, so
+// closing it on shutdown unblocks a pending read.
+func (r readerRunner) Start(ctx context.Context, interval time.Duration) (io.ReadCloser, error) {
+	if rc, ok := r.r.(io.ReadCloser); ok {
+		return rc, nil
+	}
+	return io.NopCloser(r.r), nil
+}
+
+func (r readerRunner) Wait() error {
+	return nil
+}
+
+// fileRunner is a gpuTopRunner replaying intel_gpu_top output captured to a
+// file, for -input. Each start reads the file from the beginning.
+type fileRunner struct {
+	path string
+}
+
+func (r fileRunner) Start(ctx context.Context, interval time.Duration) (io.ReadCloser, error) {
+	return os.Open(r.path)
+}
+
+func (r fileRunner) Wait() error {
+	return nil
+}
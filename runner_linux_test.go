@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// processAlive reports whether pid is a running process, treating a zombie
+// waiting to be reaped as gone.
+func processAlive(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesised command name
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z" && fields[0] != "X"
+}
+
+func TestExecRunnerCancelKillsDescendants(t *testing.T) {
+	tests := []struct {
+		name        string
+		script      string
+		termTimeout time.Duration
+		want        string
+	}{
+		{
+			// The outer shell stands in for a wrapper such as sudo
+			name:        "Terminated",
+			script:      `sh -c 'sleep 30 & echo $!; wait' & wait`,
+			termTimeout: time.Minute,
+			want:        "signal: terminated",
+		},
+		{
+			name:        "IgnoresTerm",
+			script:      `trap '' TERM; sh -c 'sleep 30 & echo $!; wait' & wait`,
+			termTimeout: 100 * time.Millisecond,
+			want:        "signal: killed",
+		},
+		{
+			name:   "NoTermTimeout",
+			script: `sh -c 'sleep 30 & echo $!; wait' & wait`,
+			want:   "signal: killed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			// A wrapper whose own child forks the process standing in for
+			// intel_gpu_top, which holds stdout open
+			r := newExecRunner("sh", "-c", tt.script)
+			r.termTimeout = tt.termTimeout
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stdout, err := r.Start(ctx, time.Second)
+			c.Assert(err, qt.IsNil)
+
+			line, err := bufio.NewReader(stdout).ReadString('\n')
+			c.Assert(err, qt.IsNil)
+			pid, err := strconv.Atoi(strings.TrimSpace(line))
+			c.Assert(err, qt.IsNil)
+			c.Assert(processAlive(pid), qt.IsTrue)
+
+			// Had the sleep survived, Wait would give up on its stdout
+			// only after pipeCloseDelay
+			cancel()
+			start := time.Now()
+			c.Assert(r.Wait(), qt.ErrorMatches, tt.want)
+			c.Assert(time.Since(start) < pipeCloseDelay, qt.IsTrue)
+
+			deadline := time.Now().Add(time.Second)
+			for processAlive(pid) && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			c.Assert(processAlive(pid), qt.IsFalse)
+		})
+	}
+}
+
+func TestExecRunnerCancelGraceful(t *testing.T) {
+	c := qt.New(t)
+
+	// A stub that exits cleanly on SIGTERM, like intel_gpu_top restoring
+	// the perf counters
+	r := newExecRunner("sh", "-c", "trap 'exit 3' TERM; echo ready; while :; do sleep 0.05; done")
+	r.termTimeout = 200 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stdout, err := r.Start(ctx, time.Second)
+	c.Assert(err, qt.IsNil)
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	c.Assert(err, qt.IsNil)
+	c.Assert(line, qt.Equals, "ready\n")
+
+	// Its own exit status shows it wasn't escalated to SIGKILL
+	cancel()
+	c.Assert(r.Wait(), qt.ErrorMatches, "exit status 3(: .*)?")
+
+	// Nor is it sent SIGKILL once reaped
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c.Assert(r.kill, qt.IsNil)
+}
+
+func TestExecRunnerProcessGroup(t *testing.T) {
+	c := qt.New(t)
+
+	r := newExecRunner("true")
+	stdout, err := r.Start(context.Background(), time.Second)
+	c.Assert(err, qt.IsNil)
+	io.Copy(io.Discard, stdout)
+	c.Assert(r.Wait(), qt.IsNil)
+	c.Assert(r.cmd.SysProcAttr.Setpgid, qt.IsTrue)
+}
@@ -0,0 +1,20 @@
+//go:build windows || plan9
+
+package main
+
+import "os/exec"
+
+// newProcessGroup leaves cmd as it is, as there are no process groups to
+// signal on this platform.
+func newProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup kills cmd, which can't be asked to exit on this
+// platform.
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// killProcessGroup kills cmd.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
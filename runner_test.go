@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// fakeRunner is a gpuTopRunner standing in for intel_gpu_top in tests. Each
+// Start streams the next of outputs, and nothing once they run out, unless
+// it was made by newStreamingRunner. It records how it was run.
+type fakeRunner struct {
+	outputs  []string
+	startErr error
+	waitErr  error
+	// cancel is called by the cancelAfter-th Start.
+	cancel      context.CancelFunc
+	cancelAfter int
+	// r and w are the pipe a streaming runner streams from.
+	r *io.PipeReader
+	w *io.PipeWriter
+
+	starts    int
+	intervals []time.Duration
+	waited    bool
+}
+
+// newStreamingRunner returns a fakeRunner streaming whatever the test writes
+// to w, stalling in between, until the context it was started with is
+// cancelled.
+func newStreamingRunner() (*fakeRunner, *io.PipeWriter) {
+	r, w := io.Pipe()
+	return &fakeRunner{r: r, w: w}, w
+}
+
+func (f *fakeRunner) Start(ctx context.Context, interval time.Duration) (io.ReadCloser, error) {
+	f.starts++
+	f.intervals = append(f.intervals, interval)
+	if f.starts == f.cancelAfter {
+		f.cancel()
+	}
+	if f.startErr != nil {
+		return nil, f.startErr
+	}
+
+	if f.r != nil {
+		go func() {
+			<-ctx.Done()
+			f.w.Close()
+		}()
+		return f.r, nil
+	}
+	output := ""
+	if len(f.outputs) > 0 {
+		output, f.outputs = f.outputs[0], f.outputs[1:]
+	}
+	return io.NopCloser(strings.NewReader(output)), nil
+}
+
+func (f *fakeRunner) Wait() error {
+	f.waited = true
+	return f.waitErr
+}
+
+func TestExecRunner(t *testing.T) {
+	c := qt.New(t)
+
+	r := newExecRunner("sh", "-c", "echo 1,2,3; echo oops >&2; exit 3")
+	stdout, err := r.Start(context.Background(), time.Second)
+	c.Assert(err, qt.IsNil)
+
+	out, err := io.ReadAll(stdout)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(out), qt.Equals, "1,2,3\n")
+
+	c.Assert(r.Wait(), qt.ErrorMatches, "exit status 3: oops")
+}
+
+func TestExecRunnerInterval(t *testing.T) {
+	c := qt.New(t)
+
+	r := newExecRunner("echo", "-c")
+	stdout, err := r.Start(context.Background(), 250*time.Millisecond)
+	c.Assert(err, qt.IsNil)
+
+	out, err := io.ReadAll(stdout)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(out), qt.Equals, "-c -s 250\n")
+	c.Assert(r.Wait(), qt.IsNil)
+}
+
+func TestExecRunnerStartError(t *testing.T) {
+	c := qt.New(t)
+
+	r := newExecRunner("/nonexistent/intel_gpu_top")
+	_, err := r.Start(context.Background(), time.Second)
+	c.Assert(err, qt.ErrorMatches, "error starting /nonexistent/intel_gpu_top: .*")
+}
+
+func TestSudoRunner(t *testing.T) {
+	c := qt.New(t)
+
+	r := newSudoRunner("intel_gpu_top", "-c", "-d", "card1")
+	c.Assert(r.name, qt.Equals, "sudo")
+
+	// Stand in for sudo to see the arguments it would get
+	r.name = "true"
+	stdout, err := r.Start(context.Background(), time.Second)
+	c.Assert(err, qt.IsNil)
+	io.Copy(io.Discard, stdout)
+	c.Assert(r.Wait(), qt.IsNil)
+	c.Assert(r.cmd.Args[1:], qt.DeepEquals, []string{"-n", "intel_gpu_top", "-c", "-d", "card1", "-s", "1000"})
+}
+
+func TestCappedBuffer(t *testing.T) {
+	c := qt.New(t)
+
+	var b cappedBuffer
+	n, err := b.Write([]byte(strings.Repeat("x", stderrLimit+10)))
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, stderrLimit+10)
+	c.Assert(b.Len(), qt.Equals, stderrLimit)
+}
+
+func TestReaderRunner(t *testing.T) {
+	c := qt.New(t)
+
+	r, w, err := os.Pipe()
+	c.Assert(err, qt.IsNil)
+	defer r.Close()
+
+	go func() {
+		io.WriteString(w, `Freq MHz req,Freq MHz act,IRQ /s,RC6 %,RCS %,RCS se,RCS wa,BCS %,BCS se,BCS wa,VCS %,VCS se,VCS wa,VECS %,VECS se,VECS wa
+1200.0,1150.0,500.0,85.5,10.2,5.1,2.3,15.4,7.8,3.2,8.9,4.5,1.8,12.7,6.3,2.9
+1300.0,1250.0,600.0,90.0,20.5,10.2,4.6,25.8,15.6,6.4,18.8,9.0,3.6,25.4,12.6,5.8
+`)
+		w.Close()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sink := &recordingSink{}
+	runGPUTop(ctx, cancel, readerRunner{r: r}, sink, collectOptions{interval: time.Second})
+
+	// EOF on the pipe ends collection cleanly
+	c.Assert(sink.updates, qt.HasLen, 2)
+	c.Assert(sink.updates[1].FreqMhzActual, qt.Equals, 1250.0)
+	c.Assert(ctx.Err(), qt.Equals, context.Canceled)
+}
+
+func TestReaderRunnerCloseUnblocksRead(t *testing.T) {
+	c := qt.New(t)
+
+	r, w, err := os.Pipe()
+	c.Assert(err, qt.IsNil)
+	defer w.Close()
+
+	stream, err := readerRunner{r: r}.Start(context.Background(), time.Second)
+	c.Assert(err, qt.IsNil)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := stream.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	c.Assert(stream.Close(), qt.IsNil)
+	select {
+	case err := <-done:
+		c.Assert(err, qt.ErrorIs, os.ErrClosed)
+	case <-time.After(5 * time.Second):
+		c.Fatal("read still blocked after Close")
+	}
+}
+
+func TestFileRunnerReplay(t *testing.T) {
+	c := qt.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	col := newCollector(collectorOptions{namespace: defaultNamespace})
+	runGPUTop(ctx, cancel, fileRunner{path: "testdata/intel_gpu_top_imc.csv"}, col, collectOptions{interval: time.Second})
+
+	// The end of the capture stops collection, leaving its last record
+	c.Assert(ctx.Err(), qt.Equals, context.Canceled)
+	c.Assert(collected(c, col, "intel_gpu_freq_mhz_actual"), qt.DeepEquals, map[string]float64{`device=""`: 300})
+	c.Assert(collected(c, col, "intel_gpu_rc6_percent"), qt.DeepEquals, map[string]float64{`device="",state="rc6"`: 98})
+	c.Assert(collected(c, col, "intel_gpu_power_gpu_watts"), qt.DeepEquals, map[string]float64{`device=""`: 0.3})
+	c.Assert(collected(c, col, "intel_gpu_engine_percent")[`device="",engine="RCS",type="busy"`], qt.Equals, 0.0)
+}
+
+// stopAfterSink records samples, cancelling collection once it has n.
+type stopAfterSink struct {
+	recordingSink
+	n      int
+	cancel context.CancelFunc
+}
+
+func (s *stopAfterSink) Update(stats IntelTopStats, prev *IntelTopStats) {
+	s.recordingSink.Update(stats, prev)
+	if len(s.updates) == s.n {
+		s.cancel()
+	}
+}
+
+func TestFileRunnerLoop(t *testing.T) {
+	c := qt.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// With -input-loop the capture is replayed from the start each time
+	sink := &stopAfterSink{n: 5, cancel: cancel}
+	opts := collectOptions{
+		interval: time.Second,
+		backoff:  &restartBackoff{min: time.Millisecond, max: time.Millisecond},
+	}
+	runGPUTop(ctx, cancel, fileRunner{path: "testdata/intel_gpu_top_imc.csv"}, sink, opts)
+
+	var freqs []float64
+	for _, stats := range sink.updates {
+		freqs = append(freqs, stats.FreqMhzActual)
+	}
+	c.Assert(freqs, qt.DeepEquals, []float64{1250, 300, 1250, 300, 1250})
+}
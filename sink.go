@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SamplesDroppedCounter counts samples a sink discarded because its buffer
+// was full.
+var SamplesDroppedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "exporter_samples_dropped_total",
+	Help: "Samples dropped because a sink's buffer was full",
+}, []string{"sink"})
+
+// MetricsSink publishes parsed intel_gpu_top samples to a backend.
+type MetricsSink interface {
+	// Update publishes stats. prev is the previously published sample, or
+	// nil for the first one.
+	Update(stats IntelTopStats, prev *IntelTopStats)
+}
+
+// prometheusSink publishes samples to the gauges of collector, served on
+// /metrics.
+type prometheusSink struct {
+	collector *Collector
+	// freqSummary, when set, observes the actual frequency of each sample.
+	freqSummary prometheus.Summary
+	// powerSummary, when set, observes the GPU power of each sample that
+	// reports it.
+	powerSummary prometheus.Summary
+	// rc6Histogram, when set, observes the RC6 residency of each sample.
+	rc6Histogram prometheus.Histogram
+}
+
+func (p prometheusSink) Update(stats IntelTopStats, prev *IntelTopStats) {
+	p.collector.Update(stats, prev)
+
+	if p.freqSummary != nil {
+		p.freqSummary.Observe(stats.FreqMhzActual)
+	}
+	if p.powerSummary != nil && stats.PowerGPUWatts != nil {
+		p.powerSummary.Observe(*stats.PowerGPUWatts)
+	}
+	if p.rc6Histogram != nil {
+		p.rc6Histogram.Observe(stats.Rc6Percent)
+	}
+}
+
+// multiSink publishes every sample to each of its sinks in turn.
+type multiSink []MetricsSink
+
+func (m multiSink) Update(stats IntelTopStats, prev *IntelTopStats) {
+	for _, sink := range m {
+		sink.Update(stats, prev)
+	}
+}
+
+// fractionSink scales every percentage in a sample to a 0-1 fraction
+// before forwarding it to next.
+type fractionSink struct {
+	next MetricsSink
+}
+
+func (f fractionSink) Update(stats IntelTopStats, prev *IntelTopStats) {
+	var scaledPrev *IntelTopStats
+	if prev != nil {
+		p := percentToFraction(*prev)
+		scaledPrev = &p
+	}
+	f.next.Update(percentToFraction(stats), scaledPrev)
+}
+
+// percentToFraction returns a copy of stats with RC6, engine and client
+// percentages divided by 100.
+func percentToFraction(stats IntelTopStats) IntelTopStats {
+	stats.Rc6Percent /= 100
+	if stats.Rc6StatePercent != nil {
+		states := make(map[string]float64, len(stats.Rc6StatePercent))
+		for state, value := range stats.Rc6StatePercent {
+			states[state] = value / 100
+		}
+		stats.Rc6StatePercent = states
+	}
+	engines := make(map[string]IntelEngine, len(stats.Engine))
+	for name, e := range stats.Engine {
+		engines[name] = IntelEngine{
+			BusyPercent: e.BusyPercent / 100,
+			SemaPercent: e.SemaPercent / 100,
+			WaitPercent: e.WaitPercent / 100,
+		}
+	}
+	stats.Engine = engines
+	if stats.Clients != nil {
+		clients := make([]IntelClient, len(stats.Clients))
+		for i, client := range stats.Clients {
+			busy := make(map[string]float64, len(client.EngineBusyPercent))
+			for name, value := range client.EngineBusyPercent {
+				busy[name] = value / 100
+			}
+			client.EngineBusyPercent = busy
+			clients[i] = client
+		}
+		stats.Clients = clients
+	}
+	return stats
+}
+
+// changeFilterSink forwards a sample to next only when it differs from the
+// last forwarded one, cutting redundant points from push backends while the
+// GPU sits idle.
+type changeFilterSink struct {
+	next    MetricsSink
+	epsilon float64
+	last    *IntelTopStats
+}
+
+func (f *changeFilterSink) Update(stats IntelTopStats, _ *IntelTopStats) {
+	if f.last != nil && statsEqual(stats, *f.last, f.epsilon) {
+		return
+	}
+
+	f.next.Update(stats, f.last)
+	f.last = &stats
+}
+
+// statsEqual reports whether every value in a and b is within epsilon and
+// both report the same engines, RC6 states and optional readings.
+func statsEqual(a, b IntelTopStats, epsilon float64) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) <= epsilon }
+	// An optional reading appearing or going away is a change
+	nearOptional := func(x, y *float64) bool {
+		if x == nil || y == nil {
+			return x == y
+		}
+		return near(*x, *y)
+	}
+
+	if a.Device != b.Device ||
+		!near(a.FreqMhzRequested, b.FreqMhzRequested) ||
+		!near(a.FreqMhzActual, b.FreqMhzActual) ||
+		!near(a.IRQPerSec, b.IRQPerSec) ||
+		!near(a.Rc6Percent, b.Rc6Percent) ||
+		!nearOptional(a.PowerGPUWatts, b.PowerGPUWatts) ||
+		!nearOptional(a.PowerPackageWatts, b.PowerPackageWatts) ||
+		!nearOptional(a.IMCReadsMiBs, b.IMCReadsMiBs) ||
+		!nearOptional(a.IMCWritesMiBs, b.IMCWritesMiBs) ||
+		!nearOptional(a.FanRPM, b.FanRPM) ||
+		!nearOptional(a.VoltageVolts, b.VoltageVolts) ||
+		len(a.Rc6StatePercent) != len(b.Rc6StatePercent) ||
+		len(a.Engine) != len(b.Engine) {
+		return false
+	}
+
+	for state, va := range a.Rc6StatePercent {
+		if vb, ok := b.Rc6StatePercent[state]; !ok || !near(va, vb) {
+			return false
+		}
+	}
+
+	for name, ea := range a.Engine {
+		eb, ok := b.Engine[name]
+		if !ok ||
+			!near(ea.BusyPercent, eb.BusyPercent) ||
+			!near(ea.SemaPercent, eb.SemaPercent) ||
+			!near(ea.WaitPercent, eb.WaitPercent) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// asyncUpdate is a sample queued by asyncSink.
+type asyncUpdate struct {
+	stats IntelTopStats
+	prev  *IntelTopStats
+}
+
+// asyncSink decouples collection from a slow sink. Samples are queued in a
+// bounded buffer and forwarded to next by Run; when the buffer is full new
+// samples are dropped rather than stalling the read loop.
+type asyncSink struct {
+	next  MetricsSink
+	queue chan asyncUpdate
+}
+
+func newAsyncSink(next MetricsSink, bufferSize int) *asyncSink {
+	return &asyncSink{next: next, queue: make(chan asyncUpdate, bufferSize)}
+}
+
+func (a *asyncSink) Update(stats IntelTopStats, prev *IntelTopStats) {
+	select {
+	case a.queue <- asyncUpdate{stats: stats, prev: prev}:
+	default:
+		SamplesDroppedCounter.WithLabelValues("async").Inc()
+	}
+}
+
+// Run forwards queued samples until ctx is cancelled.
+func (a *asyncSink) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case u := <-a.queue:
+			a.next.Update(u.stats, u.prev)
+		}
+	}
+}
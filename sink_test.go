@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// recordingSink is a MetricsSink remembering every sample it was given.
+type recordingSink struct {
+	updates []IntelTopStats
+	prevs   []*IntelTopStats
+}
+
+func (r *recordingSink) Update(stats IntelTopStats, prev *IntelTopStats) {
+	r.updates = append(r.updates, stats)
+	r.prevs = append(r.prevs, prev)
+}
+
+func TestChangeFilterSink(t *testing.T) {
+	c := qt.New(t)
+
+	idle := IntelTopStats{
+		FreqMhzActual: 300,
+		Rc6Percent:    99.5,
+		Engine:        map[string]IntelEngine{"RCS": {BusyPercent: 0.0}},
+	}
+	jitter := IntelTopStats{
+		FreqMhzActual: 300.005,
+		Rc6Percent:    99.5,
+		Engine:        map[string]IntelEngine{"RCS": {BusyPercent: 0.001}},
+	}
+	busy := IntelTopStats{
+		FreqMhzActual: 1200,
+		Rc6Percent:    10,
+		Engine:        map[string]IntelEngine{"RCS": {BusyPercent: 80}},
+	}
+	newEngine := IntelTopStats{
+		FreqMhzActual: 1200,
+		Rc6Percent:    10,
+		Engine: map[string]IntelEngine{
+			"RCS": {BusyPercent: 80},
+			"VCS": {BusyPercent: 0},
+		},
+	}
+
+	next := &recordingSink{}
+	f := &changeFilterSink{next: next, epsilon: 0.01}
+	for _, stats := range []IntelTopStats{idle, idle, jitter, busy, busy, newEngine} {
+		f.Update(stats, nil)
+	}
+
+	c.Assert(next.updates, qt.DeepEquals, []IntelTopStats{idle, busy, newEngine})
+	c.Assert(next.prevs[0], qt.IsNil)
+	c.Assert(*next.prevs[1], qt.DeepEquals, idle)
+	c.Assert(*next.prevs[2], qt.DeepEquals, busy)
+}
+
+func TestChangeFilterSinkOptional(t *testing.T) {
+	c := qt.New(t)
+
+	reading := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name    string
+		first   IntelTopStats
+		second  IntelTopStats
+		changed bool
+	}{
+		{
+			name:    "GPUPowerChanged",
+			first:   IntelTopStats{PowerGPUWatts: reading(5)},
+			second:  IntelTopStats{PowerGPUWatts: reading(12)},
+			changed: true,
+		},
+		{
+			name:   "GPUPowerJitter",
+			first:  IntelTopStats{PowerGPUWatts: reading(5)},
+			second: IntelTopStats{PowerGPUWatts: reading(5.001)},
+		},
+		{
+			name:    "PackagePowerChanged",
+			first:   IntelTopStats{PowerPackageWatts: reading(15)},
+			second:  IntelTopStats{PowerPackageWatts: reading(25)},
+			changed: true,
+		},
+		{
+			name:    "PackagePowerReported",
+			first:   IntelTopStats{},
+			second:  IntelTopStats{PowerPackageWatts: reading(15)},
+			changed: true,
+		},
+		{
+			name:    "GPUPowerGone",
+			first:   IntelTopStats{PowerGPUWatts: reading(5)},
+			second:  IntelTopStats{},
+			changed: true,
+		},
+		{
+			name:    "IMCReadsChanged",
+			first:   IntelTopStats{IMCReadsMiBs: reading(800)},
+			second:  IntelTopStats{IMCReadsMiBs: reading(2400)},
+			changed: true,
+		},
+		{
+			name:    "IMCWritesChanged",
+			first:   IntelTopStats{IMCWritesMiBs: reading(300)},
+			second:  IntelTopStats{IMCWritesMiBs: reading(900)},
+			changed: true,
+		},
+		{
+			name:   "IMCUnchanged",
+			first:  IntelTopStats{IMCReadsMiBs: reading(800), IMCWritesMiBs: reading(300)},
+			second: IntelTopStats{IMCReadsMiBs: reading(800), IMCWritesMiBs: reading(300)},
+		},
+		{
+			name:    "FanChanged",
+			first:   IntelTopStats{FanRPM: reading(900)},
+			second:  IntelTopStats{FanRPM: reading(1800)},
+			changed: true,
+		},
+		{
+			name:    "VoltageChanged",
+			first:   IntelTopStats{VoltageVolts: reading(0.75)},
+			second:  IntelTopStats{VoltageVolts: reading(0.9)},
+			changed: true,
+		},
+		{
+			name:    "FanReported",
+			first:   IntelTopStats{},
+			second:  IntelTopStats{FanRPM: reading(900)},
+			changed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			next := &recordingSink{}
+			f := &changeFilterSink{next: next, epsilon: 0.01}
+			f.Update(tt.first, nil)
+			f.Update(tt.second, nil)
+
+			want := []IntelTopStats{tt.first}
+			if tt.changed {
+				want = append(want, tt.second)
+			}
+			c.Assert(next.updates, qt.DeepEquals, want)
+		})
+	}
+}
+
+func TestFractionSink(t *testing.T) {
+	c := qt.New(t)
+
+	first := IntelTopStats{
+		FreqMhzActual: 1200,
+		IRQPerSec:     500,
+		Rc6Percent:    50,
+		Engine:        map[string]IntelEngine{"RCS": {BusyPercent: 80, SemaPercent: 10, WaitPercent: 5}},
+	}
+	second := IntelTopStats{
+		Rc6Percent: 100,
+		Engine:     map[string]IntelEngine{"RCS": {BusyPercent: 0}},
+	}
+
+	next := &recordingSink{}
+	f := fractionSink{next: next}
+	f.Update(first, nil)
+	f.Update(second, &first)
+
+	c.Assert(next.updates, qt.DeepEquals, []IntelTopStats{
+		{
+			FreqMhzActual: 1200,
+			IRQPerSec:     500,
+			Rc6Percent:    0.5,
+			Engine:        map[string]IntelEngine{"RCS": {BusyPercent: 0.8, SemaPercent: 0.1, WaitPercent: 0.05}},
+		},
+		{
+			Rc6Percent: 1,
+			Engine:     map[string]IntelEngine{"RCS": {BusyPercent: 0}},
+		},
+	})
+	c.Assert(next.prevs[0], qt.IsNil)
+	c.Assert(*next.prevs[1], qt.DeepEquals, next.updates[0])
+
+	// The caller's sample is left untouched
+	c.Assert(first.Engine["RCS"].BusyPercent, qt.Equals, 80.0)
+}
+
+func TestPrometheusSinkCompact(t *testing.T) {
+	c := qt.New(t)
+
+	// Only GPU power is reported, so package power isn't published
+	power := 12.5
+	stats := IntelTopStats{
+		FreqMhzRequested: 1200,
+		FreqMhzActual:    1150,
+		IRQPerSec:        500,
+		Rc6Percent:       20,
+		PowerGPUWatts:    &power,
+		Engine: map[string]IntelEngine{
+			"RCS": {BusyPercent: 35, SemaPercent: 5},
+			"VCS": {BusyPercent: 70},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		compact bool
+		want    []string
+	}{
+		{
+			name:    "Compact",
+			compact: true,
+			want:    []string{"intel_gpu_busy_percent", "intel_gpu_exporter_last_sample_timestamp_seconds", "intel_gpu_freq_mhz_actual", "intel_gpu_power_gpu_watts", "intel_gpu_rc6_percent"},
+		},
+		{
+			// Without a previous sample there is no IRQ delta yet
+			name: "Full",
+			want: []string{
+				"intel_gpu_busy_percent", "intel_gpu_engine_percent", "intel_gpu_engine_sema_wait_ratio", "intel_gpu_exporter_last_sample_timestamp_seconds",
+				"intel_gpu_freq_mhz_actual", "intel_gpu_freq_mhz_requested", "intel_gpu_freq_mhz_throttle_gap", "intel_gpu_irq_per_sec", "intel_gpu_power_gpu_watts", "intel_gpu_rc6_percent",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			col := newCollector(collectorOptions{namespace: defaultNamespace, compact: tt.compact})
+			prometheusSink{collector: col}.Update(stats, nil)
+
+			families, err := col.registry.Gather()
+			c.Assert(err, qt.IsNil)
+			var names []string
+			for _, mf := range families {
+				names = append(names, mf.GetName())
+			}
+			c.Assert(names, qt.DeepEquals, tt.want)
+			c.Assert(collected(c, col, "intel_gpu_busy_percent"), qt.DeepEquals, map[string]float64{`device=""`: 70})
+		})
+	}
+}
+
+// slowSink is a MetricsSink blocking each update until release is closed.
+type slowSink struct {
+	release chan struct{}
+	done    chan IntelTopStats
+}
+
+func (s *slowSink) Update(stats IntelTopStats, _ *IntelTopStats) {
+	<-s.release
+	s.done <- stats
+}
+
+func TestAsyncSink(t *testing.T) {
+	c := qt.New(t)
+
+	dropped := SamplesDroppedCounter.WithLabelValues("async")
+	before := testutil.ToFloat64(dropped)
+
+	slow := &slowSink{release: make(chan struct{}), done: make(chan IntelTopStats, 10)}
+	async := newAsyncSink(slow, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go async.Run(ctx)
+
+	// Run takes the first sample and blocks in the slow sink, two more fill
+	// the buffer and the rest are dropped, all without blocking the caller.
+	async.Update(IntelTopStats{FreqMhzActual: 1}, nil)
+	deadline := time.Now().Add(time.Second)
+	for len(async.queue) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 2; i <= 5; i++ {
+		async.Update(IntelTopStats{FreqMhzActual: float64(i)}, nil)
+	}
+	c.Assert(testutil.ToFloat64(dropped)-before, qt.Equals, 2.0)
+
+	close(slow.release)
+	var got []float64
+	for range 3 {
+		got = append(got, (<-slow.done).FreqMhzActual)
+	}
+	c.Assert(got, qt.DeepEquals, []float64{1, 2, 3})
+}
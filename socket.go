@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"time"
+)
+
+// socketProbeTimeout bounds checking whether a leftover socket is still
+// served by another process.
+const socketProbeTimeout = time.Second
+
+// listenUnix listens on the Unix domain socket at path for -unix-socket. A
+// socket file left behind by an exporter that didn't shut down cleanly is
+// removed first, while one still being served or a file that isn't a socket
+// is refused. The socket file is unlinked again when the listener is
+// closed.
+func listenUnix(path string) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case info.Mode().Type() != fs.ModeSocket:
+		return nil, fmt.Errorf("%s exists and is not a socket", path)
+	default:
+		if conn, err := net.DialTimeout("unix", path, socketProbeTimeout); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale socket: %w", err)
+		}
+	}
+
+	// Listeners from net.Listen unlink their socket file on Close
+	return net.Listen("unix", path)
+}
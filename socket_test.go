@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestListenUnixServesMetrics(t *testing.T) {
+	c := qt.New(t)
+
+	col := newCollector(collectorOptions{namespace: defaultNamespace})
+	col.Update(IntelTopStats{FreqMhzActual: 1150}, nil)
+
+	path := filepath.Join(c.TempDir(), "exporter.sock")
+	listener, err := listenUnix(path)
+	c.Assert(err, qt.IsNil)
+	server := &http.Server{Handler: newMetricsMux(col.registry, defaultMetricsPath, false)}
+	go server.Serve(listener)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/metrics")
+	c.Assert(err, qt.IsNil)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(strings.Contains(string(body), `intel_gpu_freq_mhz_actual{device=""} 1150`), qt.IsTrue)
+
+	// Shutting down unlinks the socket
+	c.Assert(server.Shutdown(context.Background()), qt.IsNil)
+	_, err = os.Lstat(path)
+	c.Assert(err, qt.ErrorIs, os.ErrNotExist)
+}
+
+func TestListenUnixExisting(t *testing.T) {
+	c := qt.New(t)
+
+	dir := c.TempDir()
+
+	// An exporter that crashed leaves its socket behind
+	stale := filepath.Join(dir, "stale.sock")
+	l, err := net.Listen("unix", stale)
+	c.Assert(err, qt.IsNil)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = listenUnix(stale)
+	c.Assert(err, qt.IsNil)
+	l.Close()
+
+	inUse := filepath.Join(dir, "in-use.sock")
+	l, err = net.Listen("unix", inUse)
+	c.Assert(err, qt.IsNil)
+	defer l.Close()
+	_, err = listenUnix(inUse)
+	c.Assert(err, qt.ErrorMatches, `.*in-use.sock is in use by another process`)
+
+	regular := filepath.Join(dir, "regular")
+	c.Assert(os.WriteFile(regular, nil, 0o644), qt.IsNil)
+	_, err = listenUnix(regular)
+	c.Assert(err, qt.ErrorMatches, `.*regular exists and is not a socket`)
+	_, err = os.Stat(regular)
+	c.Assert(err, qt.IsNil)
+}
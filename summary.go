@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultSummaryObjectives are the quantiles reported by -summaries, as
+// quantile:allowed-error pairs.
+const defaultSummaryObjectives = "0.5:0.05,0.9:0.01,0.99:0.001"
+
+// parseObjectives parses comma separated quantile:error pairs into summary
+// objectives.
+func parseObjectives(s string) (map[float64]float64, error) {
+	objectives := make(map[float64]float64)
+	for pair := range strings.SplitSeq(s, ",") {
+		q, e, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid objective %q: expected quantile:error", pair)
+		}
+
+		quantile, err := strconv.ParseFloat(q, 64)
+		if err != nil || quantile < 0 || quantile > 1 {
+			return nil, fmt.Errorf("invalid quantile %q: must be between 0 and 1", q)
+		}
+		allowed, err := strconv.ParseFloat(e, 64)
+		if err != nil || allowed < 0 || allowed > 1 {
+			return nil, fmt.Errorf("invalid error %q: must be between 0 and 1", e)
+		}
+
+		objectives[quantile] = allowed
+	}
+	return objectives, nil
+}
+
+// newFreqSummary returns a summary of actual GPU frequency over a sliding
+// window of maxAge, named under namespace.
+func newFreqSummary(namespace string, objectives map[float64]float64, maxAge time.Duration) prometheus.Summary {
+	return prometheus.NewSummary(prometheus.SummaryOpts{
+		Namespace:  namespace,
+		Name:       "freq_mhz_actual_summary",
+		Help:       "Intel GPU actual frequency in MHz over a sliding window",
+		Objectives: objectives,
+		MaxAge:     maxAge,
+	})
+}
+
+// newPowerSummary returns a summary of GPU power over a sliding window of
+// maxAge, named under namespace. Only samples that report GPU power are
+// observed.
+func newPowerSummary(namespace string, objectives map[float64]float64, maxAge time.Duration) prometheus.Summary {
+	return prometheus.NewSummary(prometheus.SummaryOpts{
+		Namespace:  namespace,
+		Name:       "power_gpu_watts_summary",
+		Help:       "Intel GPU power draw in watts over a sliding window",
+		Objectives: objectives,
+		MaxAge:     maxAge,
+	})
+}
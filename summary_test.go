@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseObjectives(t *testing.T) {
+	c := qt.New(t)
+
+	objectives, err := parseObjectives(defaultSummaryObjectives)
+	c.Assert(err, qt.IsNil)
+	c.Assert(objectives, qt.DeepEquals, map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001})
+
+	_, err = parseObjectives("0.5")
+	c.Assert(err, qt.ErrorMatches, `invalid objective "0.5": expected quantile:error`)
+
+	_, err = parseObjectives("1.5:0.01")
+	c.Assert(err, qt.ErrorMatches, `invalid quantile "1.5": must be between 0 and 1`)
+
+	_, err = parseObjectives("0.5:x")
+	c.Assert(err, qt.ErrorMatches, `invalid error "x": must be between 0 and 1`)
+}
+
+func TestPrometheusSinkFreqSummary(t *testing.T) {
+	c := qt.New(t)
+
+	objectives, err := parseObjectives(defaultSummaryObjectives)
+	c.Assert(err, qt.IsNil)
+
+	summary := newFreqSummary(defaultNamespace, objectives, time.Minute)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(summary)
+
+	// Uniform frequencies 1..1000 MHz
+	sink := prometheusSink{collector: newCollector(collectorOptions{}), freqSummary: summary}
+	for i := 1; i <= 1000; i++ {
+		sink.Update(IntelTopStats{FreqMhzActual: float64(i)}, nil)
+	}
+
+	families, err := reg.Gather()
+	c.Assert(err, qt.IsNil)
+	c.Assert(families, qt.HasLen, 1)
+
+	s := families[0].GetMetric()[0].GetSummary()
+	c.Assert(s.GetSampleCount(), qt.Equals, uint64(1000))
+	c.Assert(s.GetSampleSum(), qt.Equals, 500500.0)
+
+	quantiles := make(map[float64]float64)
+	for _, q := range s.GetQuantile() {
+		quantiles[q.GetQuantile()] = q.GetValue()
+	}
+	c.Assert(quantiles, qt.HasLen, 3)
+	c.Assert(quantiles[0.5] >= 450 && quantiles[0.5] <= 550, qt.IsTrue, qt.Commentf("p50 = %v", quantiles[0.5]))
+	c.Assert(quantiles[0.9] >= 890 && quantiles[0.9] <= 910, qt.IsTrue, qt.Commentf("p90 = %v", quantiles[0.9]))
+	c.Assert(quantiles[0.99] >= 989 && quantiles[0.99] <= 991, qt.IsTrue, qt.Commentf("p99 = %v", quantiles[0.99]))
+}
+
+func TestPrometheusSinkPowerSummary(t *testing.T) {
+	c := qt.New(t)
+
+	objectives, err := parseObjectives(defaultSummaryObjectives)
+	c.Assert(err, qt.IsNil)
+
+	summary := newPowerSummary(defaultNamespace, objectives, time.Minute)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(summary)
+
+	// Uniform power 1..100 W, interleaved with samples that report none
+	sink := prometheusSink{collector: newCollector(collectorOptions{}), powerSummary: summary}
+	for i := 1; i <= 100; i++ {
+		watts := float64(i)
+		sink.Update(IntelTopStats{PowerGPUWatts: &watts}, nil)
+		sink.Update(IntelTopStats{}, nil)
+	}
+
+	families, err := reg.Gather()
+	c.Assert(err, qt.IsNil)
+	c.Assert(families, qt.HasLen, 1)
+
+	s := families[0].GetMetric()[0].GetSummary()
+	c.Assert(s.GetSampleCount(), qt.Equals, uint64(100))
+	c.Assert(s.GetSampleSum(), qt.Equals, 5050.0)
+	c.Assert(s.GetQuantile(), qt.HasLen, 3)
+}
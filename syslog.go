@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogSeverities are the priorities accepted by -syslog-priority, indexed
+// by syslog severity.
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// parseSyslogSeverity returns the syslog severity called name.
+func parseSyslogSeverity(name string) (int, error) {
+	severity := slices.Index(syslogSeverities, name)
+	if severity < 0 {
+		return 0, fmt.Errorf("unknown syslog priority %q: must be one of %s", name, strings.Join(syslogSeverities, ", "))
+	}
+	return severity, nil
+}
+
+// syslogSink writes a one-line summary of the samples seen in each interval
+// to syslog, for appliances that aggregate through syslog rather than
+// Prometheus. Samples arrive from the collection goroutine while Run writes
+// from its own, so they are guarded by mu.
+type syslogSink struct {
+	w        io.Writer
+	interval time.Duration
+
+	mu      sync.Mutex
+	samples []IntelTopStats
+}
+
+func (s *syslogSink) Update(stats IntelTopStats, _ *IntelTopStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(s.samples, stats)
+}
+
+// Run writes a summary every interval until ctx is done.
+func (s *syslogSink) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+// flush writes the summary of the samples since the last flush, if any.
+func (s *syslogSink) flush() {
+	s.mu.Lock()
+	samples := s.samples
+	s.samples = nil
+	s.mu.Unlock()
+
+	if len(samples) == 0 {
+		return
+	}
+	if _, err := io.WriteString(s.w, formatSyslogSummary(aggregateStats(samples, aggregateMean), len(samples))); err != nil {
+		slog.Error("Unable to write to syslog", "err", err)
+	}
+}
+
+// formatSyslogSummary formats mean, the mean of n samples, as a logfmt
+// line with engines in name order.
+func formatSyslogSummary(mean IntelTopStats, n int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "samples=%d freq_mhz_req=%.1f freq_mhz_act=%.1f irq_per_sec=%.1f rc6_percent=%.1f busy_percent=%.1f",
+		n, mean.FreqMhzRequested, mean.FreqMhzActual, mean.IRQPerSec, mean.Rc6Percent, gpuBusy(mean))
+	for _, name := range slices.Sorted(maps.Keys(mean.Engine)) {
+		fmt.Fprintf(&b, " engine_%s=%.1f", name, mean.Engine[name].BusyPercent)
+	}
+	return b.String()
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// openSyslog reports that syslog isn't available on this platform.
+func openSyslog(severity int) (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestFormatSyslogSummary(t *testing.T) {
+	c := qt.New(t)
+
+	mean := IntelTopStats{
+		FreqMhzRequested: 1200,
+		FreqMhzActual:    1150.04,
+		IRQPerSec:        500,
+		Rc6Percent:       85.54,
+		Engine: map[string]IntelEngine{
+			"VCS/1": {BusyPercent: 7},
+			"RCS":   {BusyPercent: 10.2, SemaPercent: 5},
+			"BCS":   {},
+		},
+	}
+
+	c.Assert(formatSyslogSummary(mean, 60), qt.Equals,
+		"samples=60 freq_mhz_req=1200.0 freq_mhz_act=1150.0 irq_per_sec=500.0 rc6_percent=85.5 busy_percent=10.2 engine_BCS=0.0 engine_RCS=10.2 engine_VCS/1=7.0")
+	c.Assert(formatSyslogSummary(IntelTopStats{}, 1), qt.Equals,
+		"samples=1 freq_mhz_req=0.0 freq_mhz_act=0.0 irq_per_sec=0.0 rc6_percent=0.0 busy_percent=0.0")
+}
+
+func TestSyslogSinkFlush(t *testing.T) {
+	c := qt.New(t)
+
+	var buf bytes.Buffer
+	s := &syslogSink{w: &buf}
+
+	// Nothing is written for an interval without samples
+	s.flush()
+	c.Assert(buf.String(), qt.Equals, "")
+
+	s.Update(IntelTopStats{FreqMhzActual: 1000, Engine: map[string]IntelEngine{"RCS": {BusyPercent: 10}}}, nil)
+	s.Update(IntelTopStats{FreqMhzActual: 1200, Engine: map[string]IntelEngine{"RCS": {BusyPercent: 30}}}, nil)
+	s.flush()
+	c.Assert(buf.String(), qt.Equals,
+		"samples=2 freq_mhz_req=0.0 freq_mhz_act=1100.0 irq_per_sec=0.0 rc6_percent=0.0 busy_percent=20.0 engine_RCS=20.0")
+
+	// Samples are only summarised once
+	buf.Reset()
+	s.flush()
+	c.Assert(buf.String(), qt.Equals, "")
+}
+
+func TestParseSyslogSeverity(t *testing.T) {
+	c := qt.New(t)
+
+	severity, err := parseSyslogSeverity("info")
+	c.Assert(err, qt.IsNil)
+	c.Assert(severity, qt.Equals, 6)
+
+	_, err = parseSyslogSeverity("loud")
+	c.Assert(err, qt.ErrorMatches, `unknown syslog priority "loud": must be one of emerg, alert, crit, err, warning, notice, info, debug`)
+}
@@ -0,0 +1,14 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// openSyslog connects to the local syslog daemon, logging at severity with
+// the daemon facility.
+func openSyslog(severity int) (io.Writer, error) {
+	return syslog.New(syslog.Priority(severity)|syslog.LOG_DAEMON, "intel-gpu-exporter")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serviceName identifies the exporter in target_info.
+const serviceName = "intel-gpu-exporter"
+
+// newTargetInfo returns the target_info metric exposed with -openmetrics.
+// Following the OpenTelemetry to Prometheus conventions it carries metadata
+// about the host and GPU as labels on a constant 1, so it can be joined onto
+// GPU series in PromQL. Labels with empty values are omitted.
+func newTargetInfo(hostname, device, version string) prometheus.Gauge {
+	labels := prometheus.Labels{"service_name": serviceName}
+	for name, value := range map[string]string{
+		"host_name":       hostname,
+		"device":          device,
+		"service_version": version,
+	} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+
+	info := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "target_info",
+		Help:        "Target metadata",
+		ConstLabels: labels,
+	})
+	info.Set(1)
+	return info
+}
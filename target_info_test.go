@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestNewTargetInfo(t *testing.T) {
+	c := qt.New(t)
+
+	info := newTargetInfo("gpu-host", "0000:03:00.0", "v1.2.3")
+	c.Assert(testutil.CollectAndCompare(info, strings.NewReader(`
+# HELP target_info Target metadata
+# TYPE target_info gauge
+target_info{device="0000:03:00.0",host_name="gpu-host",service_name="intel-gpu-exporter",service_version="v1.2.3"} 1
+`)), qt.IsNil)
+
+	// Unknown metadata is left out rather than exposed as empty labels
+	info = newTargetInfo("gpu-host", "", "v1.2.3")
+	c.Assert(testutil.CollectAndCompare(info, strings.NewReader(`
+# HELP target_info Target metadata
+# TYPE target_info gauge
+target_info{host_name="gpu-host",service_name="intel-gpu-exporter",service_version="v1.2.3"} 1
+`)), qt.IsNil)
+}
+
+func TestMetricsMuxOpenMetrics(t *testing.T) {
+	c := qt.New(t)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newTargetInfo("gpu-host", "", "dev"))
+
+	server := httptest.NewServer(newMetricsMux(reg, defaultMetricsPath, false))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("Accept", string(expfmt.NewFormat(expfmt.TypeOpenMetrics)))
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.Header.Get("Content-Type"), qt.Matches, "application/openmetrics-text.*")
+	c.Assert(string(body), qt.Contains, `target_info{host_name="gpu-host",service_name="intel-gpu-exporter",service_version="dev"} 1`)
+	c.Assert(strings.HasSuffix(string(body), "# EOF\n"), qt.IsTrue)
+}
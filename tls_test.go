@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// writeTestCert writes a self-signed certificate with the given serial
+// number to certFile and keyFile, setting their modification time to mod.
+func writeTestCert(c *qt.C, certFile, keyFile string, serial int64, mod time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, qt.IsNil)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	c.Assert(err, qt.IsNil)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600), qt.IsNil)
+	c.Assert(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600), qt.IsNil)
+	c.Assert(os.Chtimes(certFile, mod, mod), qt.IsNil)
+	c.Assert(os.Chtimes(keyFile, mod, mod), qt.IsNil)
+}
+
+// servedSerial performs a TLS handshake against config and returns the
+// serial number of the certificate the server presented.
+func servedSerial(c *qt.C, config *tls.Config) int64 {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	c.Assert(err, qt.IsNil)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.(*tls.Conn).Handshake()
+		conn.Close()
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	c.Assert(err, qt.IsNil)
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	c := qt.New(t)
+
+	dir := c.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	start := time.Now().Add(-time.Minute)
+	writeTestCert(c, certFile, keyFile, 1, start)
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	c.Assert(err, qt.IsNil)
+	config := &tls.Config{GetCertificate: reloader.GetCertificate}
+
+	c.Assert(servedSerial(c, config), qt.Equals, int64(1))
+
+	// A renewed certificate is picked up on the next handshake
+	writeTestCert(c, certFile, keyFile, 2, start.Add(time.Second))
+	c.Assert(servedSerial(c, config), qt.Equals, int64(2))
+
+	// A broken renewal keeps the previous certificate in service
+	c.Assert(os.WriteFile(certFile, []byte("garbage"), 0o600), qt.IsNil)
+	c.Assert(servedSerial(c, config), qt.Equals, int64(2))
+}
+
+func TestNewCertReloaderInvalid(t *testing.T) {
+	c := qt.New(t)
+
+	dir := c.TempDir()
+	_, err := newCertReloader(filepath.Join(dir, "missing.crt"), filepath.Join(dir, "missing.key"))
+	c.Assert(err, qt.ErrorMatches, ".*no such file or directory")
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	c.Assert(os.WriteFile(certFile, []byte("garbage"), 0o600), qt.IsNil)
+	c.Assert(os.WriteFile(keyFile, []byte("garbage"), 0o600), qt.IsNil)
+	_, err = newCertReloader(certFile, keyFile)
+	c.Assert(err, qt.ErrorMatches, "tls: .*")
+}
+
+func TestTLSServerShutdown(t *testing.T) {
+	c := qt.New(t)
+
+	dir := c.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeTestCert(c, certFile, keyFile, 1, time.Now())
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	c.Assert(err, qt.IsNil)
+
+	// Served the same way as run does
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, qt.IsNil)
+	server := &http.Server{
+		Handler:   newMetricsMux(prometheus.NewRegistry(), defaultMetricsPath, false),
+		TLSConfig: &tls.Config{GetCertificate: reloader.GetCertificate},
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.ServeTLS(listener, "", "") }()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + listener.Addr().String() + "/metrics")
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.Assert(server.Shutdown(ctx), qt.IsNil)
+	c.Assert(<-served, qt.Equals, http.ErrServerClosed)
+}
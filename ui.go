@@ -0,0 +1,35 @@
+package main
+
+import (
+	_ "embed"
+	"log/slog"
+	"net/http"
+	"text/template"
+)
+
+// uiPage is the self-contained live chart page served at /ui. It polls
+// metrics from the browser, so it adds no state to the exporter.
+//
+//go:embed ui/index.html
+var uiPage string
+
+// uiTemplate fills in the metrics path uiPage polls. It is a text template,
+// as html/template would strip the page's script comments, with the path
+// escaped for the string literal it goes in.
+var uiTemplate = template.Must(template.New("ui").Parse(uiPage))
+
+// uiHandler serves uiPage, polling metricsPath.
+func uiHandler(metricsPath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := uiTemplate.Execute(w, struct{ MetricsPath string }{relativeMetricsPath(metricsPath)})
+		if err != nil {
+			slog.Error("Error writing UI page", "err", err)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestUIPage(t *testing.T) {
+	tests := []struct {
+		name string
+		ui   bool
+		want int
+	}{
+		{name: "Enabled", ui: true, want: http.StatusOK},
+		{name: "Disabled", ui: false, want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+			server := httptest.NewServer(newMetricsMux(prometheus.NewRegistry(), defaultMetricsPath, tt.ui))
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + "/ui")
+			c.Assert(err, qt.IsNil)
+			defer resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, tt.want)
+			if !tt.ui {
+				return
+			}
+
+			body, err := io.ReadAll(resp.Body)
+			c.Assert(err, qt.IsNil)
+			c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "text/html; charset=utf-8")
+			c.Assert(strings.Contains(string(body), `fetch("metrics")`), qt.IsTrue)
+			c.Assert(strings.Contains(string(body), `<canvas id="engines">`), qt.IsTrue)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// FreqBusyWeightedGauge is the effective clock while working: actual
+// frequency averaged over a window with each sample weighted by how busy
+// the GPU was. Idle periods, however long, don't drag it down.
+var FreqBusyWeightedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "freq_mhz_busy_weighted_avg",
+	Help: "Intel GPU actual frequency in MHz averaged over a window, weighted by busy percentage",
+})
+
+// weightedSample is one sample's contribution to a busyWeightedFreq.
+type weightedSample struct {
+	at   time.Time
+	freq float64
+	busy float64
+}
+
+// busyWeightedFreq maintains the busy-weighted average actual frequency
+// over a sliding window.
+type busyWeightedFreq struct {
+	window  time.Duration
+	samples []weightedSample
+	// sum is the sum of freq*busy and weight the sum of busy over samples.
+	sum, weight float64
+}
+
+// Observe adds stats, seen at now, drops samples older than the window and
+// returns the weighted average. It is NaN while no engine was busy at all
+// during the window.
+func (w *busyWeightedFreq) Observe(stats IntelTopStats, now time.Time) float64 {
+	s := weightedSample{at: now, freq: stats.FreqMhzActual, busy: gpuBusy(stats)}
+	w.samples = append(w.samples, s)
+	w.sum += s.freq * s.busy
+	w.weight += s.busy
+
+	expired := 0
+	for _, old := range w.samples {
+		if now.Sub(old.at) < w.window {
+			break
+		}
+		w.sum -= old.freq * old.busy
+		w.weight -= old.busy
+		expired++
+	}
+	w.samples = w.samples[expired:]
+
+	// Recompute once the window empties of weight so rounding errors from
+	// the running sums can't accumulate forever
+	if w.weight <= 1e-9 {
+		w.sum, w.weight = 0, 0
+		for _, s := range w.samples {
+			w.sum += s.freq * s.busy
+			w.weight += s.busy
+		}
+	}
+	if w.weight == 0 {
+		return math.NaN()
+	}
+	return w.sum / w.weight
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestBusyWeightedFreq(t *testing.T) {
+	c := qt.New(t)
+
+	sample := func(freq, busy float64) IntelTopStats {
+		return IntelTopStats{
+			FreqMhzActual: freq,
+			Engine:        map[string]IntelEngine{"RCS": {BusyPercent: busy}, "VCS": {BusyPercent: busy / 2}},
+		}
+	}
+
+	steps := []struct {
+		stats    IntelTopStats
+		at       time.Duration
+		expected float64
+	}{
+		// Idle from the start: nothing to weight yet
+		{stats: sample(300, 0), at: 0, expected: math.NaN()},
+		// Only the busy sample counts, however long the idle stretch
+		{stats: sample(1200, 50), at: 1 * time.Second, expected: 1200},
+		{stats: sample(300, 0), at: 2 * time.Second, expected: 1200},
+		// (1200*50 + 1800*100) / (50+100)
+		{stats: sample(1800, 100), at: 3 * time.Second, expected: 1600},
+		// The 1200 MHz sample at 1s leaves the 10s window at 11s
+		{stats: sample(600, 100), at: 11 * time.Second, expected: 1200},
+		// Everything busy has aged out
+		{stats: sample(300, 0), at: 30 * time.Second, expected: math.NaN()},
+	}
+
+	w := &busyWeightedFreq{window: 10 * time.Second}
+	start := time.Unix(0, 0)
+	for i, step := range steps {
+		got := w.Observe(step.stats, start.Add(step.at))
+		if math.IsNaN(step.expected) {
+			c.Assert(math.IsNaN(got), qt.IsTrue, qt.Commentf("step %d: got %v", i, got))
+			continue
+		}
+		c.Assert(got, qt.Equals, step.expected, qt.Commentf("step %d", i))
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// windowStat is an aggregate of the samples in the -aggregate window,
+// published as variants of the key metrics.
+type windowStat struct {
+	// suffix is appended to the name of the metric aggregated.
+	suffix string
+	// method is the aggregateStats method computing it.
+	method string
+	// help describes it in help text.
+	help string
+}
+
+// windowStats are the aggregates -aggregate publishes.
+var windowStats = []windowStat{
+	{suffix: "min", method: aggregateMin, help: "minimum"},
+	{suffix: "avg", method: aggregateMean, help: "average"},
+	{suffix: "max", method: aggregateMax, help: "maximum"},
+}
+
+// windowDescs describe one windowStat of the key metrics, which catch spikes
+// falling between scrapes.
+type windowDescs struct {
+	windowStat
+
+	freqRequested *prometheus.Desc
+	freqActual    *prometheus.Desc
+	irqPerSec     *prometheus.Desc
+	powerGPU      *prometheus.Desc
+	powerPackage  *prometheus.Desc
+	rc6           *prometheus.Desc
+	busy          *prometheus.Desc
+	engine        *prometheus.Desc
+}
+
+// descs returns the metrics w publishes, limited to the high-level set with
+// compact and never including disabled families, as Collector.descs.
+func (w windowDescs) descs(compact bool, disabled disabledFamilies) []*prometheus.Desc {
+	descs := []*prometheus.Desc{w.freqActual, w.busy, w.powerGPU, w.powerPackage}
+	if !disabled.rc6 {
+		descs = append(descs, w.rc6)
+	}
+	if compact {
+		return descs
+	}
+	descs = append(descs, w.freqRequested, w.engine)
+	if !disabled.irq {
+		descs = append(descs, w.irqPerSec)
+	}
+	return descs
+}
+
+// windowSample is a sample held in the -aggregate window.
+type windowSample struct {
+	at    time.Time
+	stats IntelTopStats
+}
+
+// recordWindow adds stats to the window of its device, received at now.
+// c.mu must be held.
+func (c *Collector) recordWindow(stats IntelTopStats, now time.Time) {
+	c.window[stats.Device] = append(c.window[stats.Device], windowSample{at: now, stats: stats})
+	c.pruneWindow(stats.Device, now)
+}
+
+// pruneWindow drops the samples of device that fell out of the window by
+// now, and the oldest beyond maxPendingSamples. c.mu must be held.
+func (c *Collector) pruneWindow(device string, now time.Time) {
+	samples := c.window[device]
+	start := now.Add(-c.opts.window)
+	n, _ := slices.BinarySearchFunc(samples, start, func(s windowSample, t time.Time) int {
+		return s.at.Compare(t)
+	})
+	n = max(n, len(samples)-maxPendingSamples)
+	if n == len(samples) {
+		delete(c.window, device)
+		return
+	}
+	c.window[device] = slices.Delete(samples, 0, n)
+}
+
+// collectWindow reports every windowStat of samples, those of device in the
+// window.
+func (c *Collector) collectWindow(ch chan<- prometheus.Metric, device string, samples []windowSample) {
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+	optional := func(desc *prometheus.Desc, value *float64) {
+		if value != nil {
+			gauge(desc, *value, device)
+		}
+	}
+
+	stats := make([]IntelTopStats, len(samples))
+	busy := make([]float64, len(samples))
+	for i, s := range samples {
+		stats[i] = s.stats
+		// The busiest engine can differ between samples, so busy is
+		// aggregated per sample rather than from the aggregated engines
+		busy[i] = gpuBusy(s.stats)
+	}
+
+	disabled := c.opts.disabled
+	for _, w := range c.windows {
+		agg := aggregateStats(stats, w.method)
+		gauge(w.freqActual, agg.FreqMhzActual, device)
+		gauge(w.busy, combineValues(busy, w.method), device)
+		optional(w.powerGPU, agg.PowerGPUWatts)
+		optional(w.powerPackage, agg.PowerPackageWatts)
+		if !disabled.rc6 {
+			gauge(w.rc6, agg.Rc6Percent, device, "rc6")
+			for state, value := range agg.Rc6StatePercent {
+				gauge(w.rc6, value, device, state)
+			}
+		}
+		if c.opts.compact {
+			continue
+		}
+
+		gauge(w.freqRequested, agg.FreqMhzRequested, device)
+		if !disabled.irq {
+			gauge(w.irqPerSec, agg.IRQPerSec, device)
+		}
+		for name, engine := range agg.Engine {
+			labels := engineLabelValues(c.opts.splitEngineInstance, device, name)
+			gauge(w.engine, engine.BusyPercent, append(labels, "busy")...)
+			if !disabled.sema {
+				gauge(w.engine, engine.SemaPercent, append(labels, "sema")...)
+			}
+			if !disabled.wait {
+				gauge(w.engine, engine.WaitPercent, append(labels, "wait")...)
+			}
+		}
+	}
+}
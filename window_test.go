@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestCollectorWindow(t *testing.T) {
+	c := qt.New(t)
+
+	now := time.Unix(1700000000, 0)
+	col := newCollector(collectorOptions{
+		namespace: defaultNamespace,
+		window:    5 * time.Second,
+		disabled:  disabledFamilies{sema: true, wait: true},
+	})
+	col.now = func() time.Time { return now }
+
+	for _, stats := range []IntelTopStats{
+		{FreqMhzActual: 300, Engine: map[string]IntelEngine{"RCS": {BusyPercent: 10}, "VCS": {BusyPercent: 80}}},
+		{FreqMhzActual: 900, Engine: map[string]IntelEngine{"RCS": {BusyPercent: 50}, "VCS": {BusyPercent: 20}}},
+		{FreqMhzActual: 600, Engine: map[string]IntelEngine{"RCS": {BusyPercent: 0}, "VCS": {BusyPercent: 20}}},
+	} {
+		col.Update(stats, nil)
+		now = now.Add(2 * time.Second)
+	}
+	now = now.Add(-2 * time.Second)
+
+	tests := []struct {
+		name string
+		want map[string]float64
+	}{
+		{
+			name: "intel_gpu_freq_mhz_actual_min",
+			want: map[string]float64{`device=""`: 300},
+		},
+		{
+			name: "intel_gpu_freq_mhz_actual_avg",
+			want: map[string]float64{`device=""`: 600},
+		},
+		{
+			name: "intel_gpu_freq_mhz_actual_max",
+			want: map[string]float64{`device=""`: 900},
+		},
+		{
+			name: "intel_gpu_engine_percent_min",
+			want: map[string]float64{
+				`device="",engine="RCS",type="busy"`: 0,
+				`device="",engine="VCS",type="busy"`: 20,
+			},
+		},
+		{
+			name: "intel_gpu_engine_percent_avg",
+			want: map[string]float64{
+				`device="",engine="RCS",type="busy"`: 20,
+				`device="",engine="VCS",type="busy"`: 40,
+			},
+		},
+		{
+			name: "intel_gpu_engine_percent_max",
+			want: map[string]float64{
+				`device="",engine="RCS",type="busy"`: 50,
+				`device="",engine="VCS",type="busy"`: 80,
+			},
+		},
+		{
+			// Averaged over the busiest engine of each sample, which the
+			// busiest averaged engine would put at 40
+			name: "intel_gpu_busy_percent_avg",
+			want: map[string]float64{`device=""`: 50},
+		},
+		{
+			// The latest sample is still published as is
+			name: "intel_gpu_freq_mhz_actual",
+			want: map[string]float64{`device=""`: 600},
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			c.Assert(collected(c, col, tt.name), qt.DeepEquals, tt.want)
+		})
+	}
+
+	// The first sample falls out of the window
+	now = now.Add(2500 * time.Millisecond)
+	c.Assert(collected(c, col, "intel_gpu_freq_mhz_actual_min"), qt.DeepEquals, map[string]float64{`device=""`: 600})
+	c.Assert(collected(c, col, "intel_gpu_freq_mhz_actual_avg"), qt.DeepEquals, map[string]float64{`device=""`: 750})
+
+	// And the rest once no more arrive
+	now = now.Add(time.Minute)
+	c.Assert(collected(c, col, "intel_gpu_freq_mhz_actual_max"), qt.HasLen, 0)
+	c.Assert(collected(c, col, "intel_gpu_freq_mhz_actual"), qt.HasLen, 1)
+}